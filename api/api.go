@@ -0,0 +1,208 @@
+// Package api serves the HTTP API used to request on-demand snapshots
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/audit"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+	w "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+)
+
+// Snapshotter interface specifies the functions used to create on-demand snapshots
+type Snapshotter interface {
+	SnapshotVolume(ctx context.Context, req *w.OnDemandRequest) (*ec2.Snapshot, error)
+}
+
+// SnapshotRequest used to store the body of an on-demand snapshot request
+type SnapshotRequest struct {
+	Label       string     `json:"label,omitempty"`
+	RetainUntil *time.Time `json:"retainUntil,omitempty"`
+	Hold        bool       `json:"hold,omitempty"`
+}
+
+// SnapshotResponse used to store the body of an on-demand snapshot response
+type SnapshotResponse struct {
+	SnapshotID  string     `json:"snapshotId"`
+	VolumeID    string     `json:"volumeId"`
+	Label       string     `json:"label,omitempty"`
+	RetainUntil *time.Time `json:"retainUntil,omitempty"`
+	Hold        bool       `json:"hold,omitempty"`
+}
+
+// auditReason is the reason of the audit records written for on-demand requests
+const auditReason = "on_demand"
+
+type handler struct {
+	snapshotters []Snapshotter
+	token        string
+	logger       *slog.Logger
+	auditSink    audit.Sink
+}
+
+// NewHandler used to create the API handler. Every request must carry given token as a bearer token.
+// Volumes are looked up in each snapshotter in turn, so that every target can be reached. Requests
+// rejected before a snapshot is attempted are written to given audit sink, if any, as the snapshotters
+// record the others.
+func NewHandler(logger *slog.Logger, auditSink audit.Sink, token string, snapshotters ...Snapshotter) http.Handler {
+	return &handler{
+		snapshotters: snapshotters,
+		token:        token,
+		logger:       logger,
+		auditSink:    auditSink,
+	}
+}
+
+// ServeHTTP used to handle `POST /v1/volumes/{id}/snapshots` and
+// `POST /v1/namespaces/{namespace}/pvcs/{name}/snapshots`
+func (h *handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	req, ok := parsePath(r.URL.Path)
+	if !h.authorized(r) {
+		h.reject(r, req, "unauthorized")
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !ok {
+		http.NotFound(rw, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := &SnapshotRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+			h.reject(r, req, "invalid request body")
+			http.Error(rw, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	req.Label = body.Label
+	req.Hold = body.Hold
+	if body.RetainUntil != nil {
+		if !body.RetainUntil.After(time.Now()) {
+			h.reject(r, req, "retainUntil must be in the future")
+			http.Error(rw, "retainUntil must be in the future", http.StatusBadRequest)
+			return
+		}
+		req.RetainUntil = *body.RetainUntil
+	}
+
+	snapshot, err := h.snapshot(r.Context(), req)
+	switch {
+	case errors.Cause(err) == w.ErrVolumeNotFound:
+		h.reject(r, req, "volume not found")
+		http.Error(rw, "volume not found", http.StatusNotFound)
+		return
+	case err != nil:
+		h.requestLogger(r, req).Error("failed to create on-demand snapshot", logging.Error, err)
+		http.Error(rw, "error while creating snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	h.requestLogger(r, req).Info("served on-demand snapshot request",
+		logging.SnapshotID, aws.StringValue(snapshot.SnapshotId))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(&SnapshotResponse{
+		SnapshotID:  aws.StringValue(snapshot.SnapshotId),
+		VolumeID:    aws.StringValue(snapshot.VolumeId),
+		Label:       body.Label,
+		RetainUntil: body.RetainUntil,
+		Hold:        body.Hold,
+	})
+}
+
+func (h *handler) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && h.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+func (h *handler) snapshot(ctx context.Context, req *w.OnDemandRequest) (*ec2.Snapshot, error) {
+	for _, snapshotter := range h.snapshotters {
+		snapshot, err := snapshotter.SnapshotVolume(ctx, req)
+		if errors.Cause(err) == w.ErrVolumeNotFound {
+			continue
+		}
+		return snapshot, err
+	}
+	return nil, w.ErrVolumeNotFound
+}
+
+func parsePath(path string) (*w.OnDemandRequest, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) == 4 && parts[0] == "v1" && parts[1] == "volumes" && parts[3] == "snapshots" && parts[2] != "":
+		return &w.OnDemandRequest{VolumeID: parts[2]}, true
+	case len(parts) == 6 && parts[0] == "v1" && parts[1] == "namespaces" && parts[3] == "pvcs" &&
+		parts[5] == "snapshots" && parts[2] != "" && parts[4] != "":
+		return &w.OnDemandRequest{PVCNamespace: parts[2], PVCName: parts[4]}, true
+	}
+	return nil, false
+}
+
+// reject used to log and audit a request rejected before a snapshot was attempted
+func (h *handler) reject(r *http.Request, req *w.OnDemandRequest, reason string) {
+	h.requestLogger(r, req).Warn("rejected on-demand snapshot request", logging.Error, reason)
+	if h.auditSink == nil {
+		return
+	}
+
+	record := &audit.Record{
+		Time:    time.Now(),
+		Action:  audit.ActionCreate,
+		Reason:  auditReason,
+		Outcome: audit.OutcomeFailure,
+		Error:   reason,
+		Remote:  r.RemoteAddr,
+	}
+	if req != nil {
+		record.VolumeID = req.VolumeID
+		record.PVCName = req.PVCName
+		record.PVCNamespace = req.PVCNamespace
+	}
+	if err := h.auditSink.Write(r.Context(), record); err != nil {
+		h.requestLogger(r, req).Error("failed to write audit record",
+			logging.Action, audit.ActionCreate,
+			logging.Error, err)
+	}
+}
+
+// requestLogger used to obtain a logger carrying the client and the target of a request
+func (h *handler) requestLogger(r *http.Request, req *w.OnDemandRequest) *slog.Logger {
+	logger := h.logger.With(
+		logging.Reason, auditReason,
+		"remote", r.RemoteAddr,
+		"user_agent", r.UserAgent())
+	if req == nil {
+		return logger.With("path", r.URL.Path)
+	}
+	if req.VolumeID != "" {
+		logger = logger.With(logging.VolumeID, req.VolumeID)
+	} else {
+		logger = logger.With(logging.PVCName, req.PVCName, logging.PVCNamespace, req.PVCNamespace)
+	}
+	if req.Label != "" {
+		logger = logger.With("label", req.Label)
+	}
+	if !req.RetainUntil.IsZero() {
+		logger = logger.With("retain_until", req.RetainUntil.UTC().Format(time.RFC3339))
+	}
+	if req.Hold {
+		logger = logger.With("hold", true)
+	}
+	return logger
+}
@@ -0,0 +1,150 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/api"
+	"github.com/utilitywarehouse/ebs-snapshotter/audit"
+	w "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&APISuite{})
+
+type APISuite struct{}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestAPI(t *testing.T) { TestingT(t) }
+
+func (s *APISuite) TestSnapshotCreatedForVolumeWithRetainUntil(c *C) {
+	snapshotter := &fakeSnapshotter{volumes: map[string]bool{"vol-1": true}}
+	handler := api.NewHandler(discardLogger, nil, "token", snapshotter)
+	retainUntil := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+
+	rec := post(handler, "/v1/volumes/vol-1/snapshots", "token",
+		`{"label": "before-migration", "retainUntil": "`+retainUntil.Format(time.RFC3339)+`"}`)
+
+	c.Assert(rec.Code, Equals, http.StatusCreated)
+	var resp api.SnapshotResponse
+	c.Assert(json.NewDecoder(rec.Body).Decode(&resp), IsNil)
+	c.Assert(resp.SnapshotID, Equals, "snap-vol-1")
+	c.Assert(resp.Label, Equals, "before-migration")
+	c.Assert(snapshotter.requests, HasLen, 1)
+	c.Assert(snapshotter.requests[0].VolumeID, Equals, "vol-1")
+	c.Assert(snapshotter.requests[0].Label, Equals, "before-migration")
+	c.Assert(snapshotter.requests[0].RetainUntil.Equal(retainUntil), Equals, true)
+}
+
+func (s *APISuite) TestSnapshotCreatedForPVCInAnyTarget(c *C) {
+	other := &fakeSnapshotter{}
+	snapshotter := &fakeSnapshotter{volumes: map[string]bool{"kafka/datadir-kafka-0": true}}
+	handler := api.NewHandler(discardLogger, nil, "token", other, snapshotter)
+
+	rec := post(handler, "/v1/namespaces/kafka/pvcs/datadir-kafka-0/snapshots", "token", `{"hold": true}`)
+
+	c.Assert(rec.Code, Equals, http.StatusCreated)
+	c.Assert(snapshotter.requests[0].PVCNamespace, Equals, "kafka")
+	c.Assert(snapshotter.requests[0].PVCName, Equals, "datadir-kafka-0")
+	c.Assert(snapshotter.requests[0].Hold, Equals, true)
+}
+
+func (s *APISuite) TestRequestsRejected(c *C) {
+	snapshotter := &fakeSnapshotter{volumes: map[string]bool{"vol-1": true}}
+	handler := api.NewHandler(discardLogger, nil, "token", snapshotter)
+
+	c.Assert(post(handler, "/v1/volumes/vol-1/snapshots", "wrong", "").Code, Equals, http.StatusUnauthorized)
+	c.Assert(post(handler, "/v1/volumes/vol-2/snapshots", "token", "").Code, Equals, http.StatusNotFound)
+	c.Assert(post(handler, "/v1/volumes/vol-1/snapshots", "token",
+		`{"retainUntil": "2000-01-01T00:00:00Z"}`).Code, Equals, http.StatusBadRequest)
+	c.Assert(snapshotter.requests, HasLen, 0)
+}
+
+func (s *APISuite) TestNoRequestAuthorizedWithoutToken(c *C) {
+	handler := api.NewHandler(discardLogger, nil, "", &fakeSnapshotter{volumes: map[string]bool{"vol-1": true}})
+
+	c.Assert(post(handler, "/v1/volumes/vol-1/snapshots", "", "").Code, Equals, http.StatusUnauthorized)
+}
+
+func (s *APISuite) TestTokenWithoutBearerPrefixRejected(c *C) {
+	snapshotter := &fakeSnapshotter{volumes: map[string]bool{"vol-1": true}}
+	handler := api.NewHandler(discardLogger, nil, "token", snapshotter)
+	req := httptest.NewRequest(http.MethodPost, "/v1/volumes/vol-1/snapshots", nil)
+	req.Header.Set("Authorization", "token")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	c.Assert(rec.Code, Equals, http.StatusUnauthorized)
+	c.Assert(snapshotter.requests, HasLen, 0)
+}
+
+func (s *APISuite) TestRejectedRequestsAudited(c *C) {
+	sink := &fakeSink{}
+	handler := api.NewHandler(discardLogger, sink, "token", &fakeSnapshotter{volumes: map[string]bool{"vol-1": true}})
+
+	post(handler, "/v1/volumes/vol-1/snapshots", "wrong", "")
+	post(handler, "/v1/namespaces/kafka/pvcs/datadir-kafka-0/snapshots", "token", "")
+	post(handler, "/v1/volumes/vol-1/snapshots", "token", `{"retainUntil": "2000-01-01T00:00:00Z"}`)
+	post(handler, "/v1/volumes/vol-1/snapshots", "token", "")
+
+	c.Assert(sink.records, HasLen, 3)
+	for _, record := range sink.records {
+		c.Assert(record.Action, Equals, audit.ActionCreate)
+		c.Assert(record.Reason, Equals, "on_demand")
+		c.Assert(record.Outcome, Equals, audit.OutcomeFailure)
+		c.Assert(record.Remote, Not(Equals), "")
+	}
+	c.Assert(sink.records[0].VolumeID, Equals, "vol-1")
+	c.Assert(sink.records[0].Error, Equals, "unauthorized")
+	c.Assert(sink.records[1].PVCName, Equals, "datadir-kafka-0")
+	c.Assert(sink.records[1].PVCNamespace, Equals, "kafka")
+	c.Assert(sink.records[1].Error, Equals, "volume not found")
+	c.Assert(sink.records[2].Error, Equals, "retainUntil must be in the future")
+}
+
+type fakeSink struct {
+	records []*audit.Record
+}
+
+func (f *fakeSink) Write(ctx context.Context, record *audit.Record) error {
+	f.records = append(f.records, record)
+	return nil
+}
+
+type fakeSnapshotter struct {
+	volumes  map[string]bool
+	requests []*w.OnDemandRequest
+}
+
+func (f *fakeSnapshotter) SnapshotVolume(ctx context.Context, req *w.OnDemandRequest) (*ec2.Snapshot, error) {
+	id := req.VolumeID
+	if id == "" {
+		id = req.PVCNamespace + "/" + req.PVCName
+	}
+	if !f.volumes[id] {
+		return nil, w.ErrVolumeNotFound
+	}
+	f.requests = append(f.requests, req)
+	return &ec2.Snapshot{SnapshotId: aws.String("snap-" + id), VolumeId: aws.String(id)}, nil
+}
+
+func post(handler http.Handler, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,50 @@
+// Package v1alpha1 contains the SnapshotPolicy custom resource types
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// GroupName is the API group of ebs-snapshotter resources
+	GroupName = "ebs-snapshotter.uw.systems"
+	// Version is the API version of ebs-snapshotter resources
+	Version = "v1alpha1"
+
+	// ConditionReady is the condition type set once a policy has been reconciled
+	ConditionReady = "Ready"
+)
+
+// SnapshotPolicyResource is the group version resource of SnapshotPolicy objects
+var SnapshotPolicyResource = schema.GroupVersionResource{
+	Group:    GroupName,
+	Version:  Version,
+	Resource: "snapshotpolicies",
+}
+
+// SnapshotPolicy used to define how the EBS volumes of selected PVCs are snapshotted
+type SnapshotPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SnapshotPolicySpec   `json:"spec"`
+	Status SnapshotPolicyStatus `json:"status,omitempty"`
+}
+
+// SnapshotPolicySpec used to store the PVC selector, schedule and retention of a policy
+type SnapshotPolicySpec struct {
+	Selector             metav1.LabelSelector `json:"selector"`
+	IntervalSeconds      int64                `json:"intervalSeconds"`
+	RetentionPeriodHours int64                `json:"retentionPeriodHours"`
+}
+
+// SnapshotPolicyStatus used to store the outcome of the last reconciliation of a policy
+type SnapshotPolicyStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Volumes            int                `json:"volumes"`
+	LastSnapshotTime   *metav1.Time       `json:"lastSnapshotTime,omitempty"`
+	SnapshotCount      int                `json:"snapshotCount"`
+	LastError          string             `json:"lastError,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
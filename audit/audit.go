@@ -0,0 +1,112 @@
+// Package audit records every snapshot created or deleted, and why, to an append-only sink
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Actions recorded by the watcher
+const (
+	ActionCreate = "create"
+	ActionDelete = "delete"
+)
+
+// Outcomes of an action
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Record used to store a single snapshot create or delete
+type Record struct {
+	Time         time.Time `json:"time"`
+	Action       string    `json:"action"`
+	VolumeID     string    `json:"volumeId"`
+	PVCName      string    `json:"pvcName,omitempty"`
+	PVCNamespace string    `json:"pvcNamespace,omitempty"`
+	SnapshotID   string    `json:"snapshotId,omitempty"`
+	Policy       string    `json:"policy,omitempty"`
+	Reason       string    `json:"reason"`
+	Outcome      string    `json:"outcome"`
+	Error        string    `json:"error,omitempty"`
+	// Remote is the address of the client of an on-demand snapshot request
+	Remote  string `json:"remote,omitempty"`
+	Version string `json:"version"`
+}
+
+// Sink interface specifies functions used to store audit records
+type Sink interface {
+	Write(ctx context.Context, record *Record) error
+}
+
+type versionedSink struct {
+	sink    Sink
+	version string
+}
+
+// WithVersion used to wrap a sink so that every record carries given version of the tool
+func WithVersion(sink Sink, version string) Sink {
+	return &versionedSink{sink: sink, version: version}
+}
+
+func (s *versionedSink) Write(ctx context.Context, record *Record) error {
+	r := *record
+	r.Version = s.version
+	return s.sink.Write(ctx, &r)
+}
+
+type writerSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewWriterSink used to create a sink writing every record as a line of JSON, such as to stdout
+func NewWriterSink(out io.Writer) Sink {
+	return &writerSink{out: out}
+}
+
+func (s *writerSink) Write(ctx context.Context, record *Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "error while encoding audit record")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.out.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "error while writing audit record")
+	}
+	return nil
+}
+
+type fileSink struct {
+	writerSink
+	file *os.File
+}
+
+// NewFileSink used to create a sink appending every record as a line of JSON to given file, which
+// is created when missing. Records are synced to disk before Write returns.
+func NewFileSink(path string) (Sink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while opening audit file %s", path)
+	}
+	return &fileSink{writerSink: writerSink{out: file}, file: file}, nil
+}
+
+func (s *fileSink) Write(ctx context.Context, record *Record) error {
+	if err := s.writerSink.Write(ctx, record); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return errors.Wrap(err, "error while syncing audit file")
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package audit_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/utilitywarehouse/ebs-snapshotter/audit"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&AuditSuite{})
+
+type AuditSuite struct{}
+
+func TestAudit(t *testing.T) { TestingT(t) }
+
+func (s *AuditSuite) TestFileSinkAppendsRecords(c *C) {
+	path := filepath.Join(c.MkDir(), "audit.jsonl")
+
+	sink, err := audit.NewFileSink(path)
+	c.Assert(err, IsNil)
+	c.Assert(sink.Write(context.Background(), record(audit.ActionCreate, "snap-1")), IsNil)
+
+	// Reopening the file, such as after a restart, keeps the existing records
+	sink, err = audit.NewFileSink(path)
+	c.Assert(err, IsNil)
+	c.Assert(sink.Write(context.Background(), record(audit.ActionDelete, "snap-0")), IsNil)
+
+	file, err := os.Open(path)
+	c.Assert(err, IsNil)
+	defer file.Close()
+	records := make([]*audit.Record, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		r := &audit.Record{}
+		c.Assert(json.Unmarshal(scanner.Bytes(), r), IsNil)
+		records = append(records, r)
+	}
+	c.Assert(records, HasLen, 2)
+	c.Assert(records[0].Action, Equals, audit.ActionCreate)
+	c.Assert(records[0].SnapshotID, Equals, "snap-1")
+	c.Assert(records[1].Action, Equals, audit.ActionDelete)
+	c.Assert(records[1].SnapshotID, Equals, "snap-0")
+}
+
+func (s *AuditSuite) TestVersionAddedToRecords(c *C) {
+	out := &strings.Builder{}
+	sink := audit.WithVersion(audit.NewWriterSink(out), "abc123")
+
+	c.Assert(sink.Write(context.Background(), record(audit.ActionCreate, "snap-1")), IsNil)
+
+	r := &audit.Record{}
+	c.Assert(json.Unmarshal([]byte(out.String()), r), IsNil)
+	c.Assert(r.Version, Equals, "abc123")
+	c.Assert(r.Outcome, Equals, audit.OutcomeSuccess)
+	c.Assert(r.Reason, Equals, "retention_exceeded")
+	c.Assert(r.Policy, Equals, "kafka")
+}
+
+func (s *AuditSuite) TestS3SinkStoresEachRecordAsObject(c *C) {
+	store := newFakeS3()
+	server := httptest.NewServer(store)
+	defer server.Close()
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("eu-west-1").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithEndpoint(server.URL).
+		WithS3ForcePathStyle(true))
+	c.Assert(err, IsNil)
+	sink := audit.NewS3Sink(s3.New(sess), "audit-bucket", "ebs")
+
+	first := record(audit.ActionDelete, "snap-0")
+	second := record(audit.ActionCreate, "snap-1")
+	c.Assert(sink.Write(context.Background(), first), IsNil)
+	c.Assert(sink.Write(context.Background(), second), IsNil)
+
+	c.Assert(store.objects, HasLen, 2)
+	key := "/audit-bucket/ebs/2021/01/02/150405.000000000Z-delete-snap-0.json"
+	c.Assert(store.objects[key], NotNil)
+	r := &audit.Record{}
+	c.Assert(json.Unmarshal(store.objects[key], r), IsNil)
+	c.Assert(r.SnapshotID, Equals, "snap-0")
+	c.Assert(r.VolumeID, Equals, "vol-1")
+}
+
+func (s *AuditSuite) TestS3SinkErrorReturned(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("eu-west-1").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithEndpoint(server.URL).
+		WithS3ForcePathStyle(true).
+		WithMaxRetries(0))
+	c.Assert(err, IsNil)
+	sink := audit.NewS3Sink(s3.New(sess), "audit-bucket", "ebs")
+
+	c.Assert(sink.Write(context.Background(), record(audit.ActionCreate, "snap-1")), NotNil)
+}
+
+func record(action, snapshotID string) *audit.Record {
+	return &audit.Record{
+		Time:       time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC),
+		Action:     action,
+		VolumeID:   "vol-1",
+		SnapshotID: snapshotID,
+		Policy:     "kafka",
+		Reason:     "retention_exceeded",
+		Outcome:    audit.OutcomeSuccess,
+	}
+}
+
+// fakeS3 is a local stand-in for an S3 compatible store, keeping objects put in memory by path
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte)}
+}
+
+func (f *fakeS3) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[r.URL.Path] = body
+	rw.Header().Set("ETag", `"etag"`)
+}
@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+)
+
+type s3Sink struct {
+	client s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// NewS3Sink used to create a sink storing every record as its own object in an S3 compatible
+// bucket, so that records are never overwritten. Objects are keyed by date, time, action and
+// snapshot under given prefix, e.g. `audit/2021/01/02/150405.000000000Z-delete-snap-1.json`.
+// Object lock or versioning on the bucket is expected to make records immutable.
+func NewS3Sink(client s3iface.S3API, bucket, prefix string) Sink {
+	return &s3Sink{client: client, bucket: bucket, prefix: prefix}
+}
+
+func (s *s3Sink) Write(ctx context.Context, record *Record) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "error while encoding audit record")
+	}
+
+	t := record.Time.UTC()
+	name := fmt.Sprintf("%s-%s-%s.json", t.Format("150405.000000000Z"), record.Action, objectID(record))
+	key := path.Join(s.prefix, t.Format("2006/01/02"), name)
+	if _, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return errors.Wrapf(err, "error while storing audit record %s in bucket %s", key, s.bucket)
+	}
+	return nil
+}
+
+// objectID used to identify the subject of a record, which is the volume for failed creates
+func objectID(record *Record) string {
+	if record.SnapshotID != "" {
+		return record.SnapshotID
+	}
+	return record.VolumeID
+}
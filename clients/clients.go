@@ -1,10 +1,33 @@
 package clients
 
 import (
+	"context"
+	"fmt"
 	"sort"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/clock"
+)
+
+const (
+	// ControlTagPrefix is the prefix of the tags ebs-snapshotter uses to track and protect snapshots
+	ControlTagPrefix = "ebs-snapshotter/"
+	// SourceSnapshotIDTag is the tag key used to store the source snapshot ID on snapshot copies
+	SourceSnapshotIDTag = "ebs-snapshotter/source-snapshot-id"
+	// SourceVolumeIDTag is the tag key used to store the source volume ID on snapshot copies
+	SourceVolumeIDTag = "ebs-snapshotter/source-volume-id"
+	// RetainUntilTag is the tag key used to store the RFC3339 time until which retention keeps a snapshot
+	RetainUntilTag = "ebs-snapshotter/retain-until"
+	// LabelTag is the tag key used to store the label given to an on-demand snapshot
+	LabelTag = "ebs-snapshotter/label"
+	// HoldTag is the tag key used to keep a snapshot from ever being removed by retention, when set to `true`
+	HoldTag = "ebs-snapshotter/hold"
 )
 
 var (
@@ -19,74 +42,133 @@ type EC2Snapshots map[string][]*ec2.Snapshot
 
 // EBSClient interface specifies EBS client functions
 type EBSClient interface {
-	GetVolumes() (EC2Volumes, error)
-	GetSnapshots() (EC2Snapshots, error)
-	CreateSnapshot(volume *ec2.Volume) error
-	RemoveSnapshot(snapshot *ec2.Snapshot) error
+	GetVolumes(ctx context.Context) (EC2Volumes, error)
+	GetSnapshots(ctx context.Context) (EC2Snapshots, error)
+	CreateSnapshot(ctx context.Context, volume *ec2.Volume, tags []*ec2.Tag) (*ec2.Snapshot, error)
+	RemoveSnapshot(ctx context.Context, snapshot *ec2.Snapshot) error
+	CopySnapshot(ctx context.Context, snapshot *ec2.Snapshot, sourceRegion, kmsKeyID string) (string, error)
+	GetSnapshotCopies(ctx context.Context) (EC2Snapshots, error)
+	ShareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string) error
+	UnshareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string, keepKeyGrant bool) error
+	CreateVolume(ctx context.Context, snapshot *ec2.Snapshot, options *VolumeOptions) (*ec2.Volume, error)
+	TagSnapshot(ctx context.Context, snapshotID string, tags []*ec2.Tag) error
+	UntagSnapshot(ctx context.Context, snapshotID string, keys []string) error
+}
+
+// VolumeOptions used to store the settings of a volume restored from a snapshot
+type VolumeOptions struct {
+	AvailabilityZone string
+	VolumeType       string
+	Iops             int64
+	Throughput       int64
+	Tags             []*ec2.Tag
 }
 
 type ebsClient struct {
-	ec2Client *ec2.EC2
+	ec2Client   ec2iface.EC2API
+	kmsClient   *kms.KMS
+	retryPolicy RetryPolicy
+	clock       clock.Clock
 }
 
-// NewEBSClient used to create a new EBS client instance
-func NewEBSClient(client *ec2.EC2) EBSClient {
+// NewEBSClient used to create a new EBS client instance, usually with an `*ec2.EC2` client. The KMS
+// client is only needed to share encrypted snapshots and may be nil. Requests are retried as the
+// default retry policy allows.
+func NewEBSClient(client ec2iface.EC2API, kmsClient *kms.KMS) EBSClient {
+	return NewEBSClientWithRetryPolicy(client, kmsClient, DefaultRetryPolicy, clock.Real())
+}
+
+// NewEBSClientWithRetryPolicy used to create a new EBS client instance that retries requests as
+// given policy allows, waiting between retries on given clock
+func NewEBSClientWithRetryPolicy(
+	client ec2iface.EC2API,
+	kmsClient *kms.KMS,
+	retryPolicy RetryPolicy,
+	clk clock.Clock) EBSClient {
+
 	return &ebsClient{
-		ec2Client: client,
+		ec2Client:   client,
+		kmsClient:   kmsClient,
+		retryPolicy: retryPolicy,
+		clock:       clk,
+	}
+}
+
+// call used to make a request, retrying it as the retry policy allows for the class of its error.
+// The error of the last attempt is returned as an `*Error` of its class.
+func (c *ebsClient) call(ctx context.Context, action string, request func() error) error {
+	for retry := 0; ; retry++ {
+		err := request()
+		if err == nil {
+			return nil
+		}
+		class := classify(err)
+		wait, ok := c.retryPolicy.wait(class, retry)
+		if !ok {
+			return &Error{Class: class, Action: action, Err: err}
+		}
+		select {
+		case <-c.clock.After(wait):
+		case <-ctx.Done():
+			return &Error{Class: class, Action: action, Err: err}
+		}
 	}
 }
 
 // GetVolumes used to obtain EC2 volumes
-func (c *ebsClient) GetVolumes() (EC2Volumes, error) {
+func (c *ebsClient) GetVolumes(ctx context.Context) (EC2Volumes, error) {
 	volumes := make([]*ec2.Volume, 0)
 	input := &ec2.DescribeVolumesInput{
 		MaxResults: &resultsPerRequest,
 	}
 
-	vols, err := c.ec2Client.DescribeVolumes(input)
+	for {
+		var vols *ec2.DescribeVolumesOutput
+		if err := c.call(ctx, "describing volumes", func() (err error) {
+			vols, err = c.ec2Client.DescribeVolumesWithContext(ctx, input)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, vols.Volumes...)
+		if vols.NextToken == nil {
+			return mapVolumesToIds(volumes), nil
+		}
+		input.NextToken = vols.NextToken
+	}
+}
+
+// GetLatestSnapshots used to obtain recent EC2 EBS snapshots
+func (c *ebsClient) GetSnapshots(ctx context.Context) (EC2Snapshots, error) {
+	snapshots, err := c.describeSnapshots(ctx, &ec2.DescribeSnapshotsInput{})
 	if err != nil {
-		return nil, errors.Wrap(err, "error while describing volumes")
+		return nil, err
 	}
 
-	volumes = append(volumes, vols.Volumes...)
-	for vols.NextToken != nil {
-		v, err := c.ec2Client.DescribeVolumes(&ec2.DescribeVolumesInput{
-			MaxResults: &resultsPerRequest,
-			NextToken:  vols.NextToken,
-		})
-		if err != nil {
-			return nil, errors.Wrap(err, "error while describing volumes")
-		}
-		volumes = append(volumes, v.Volumes...)
+	mappedSnapshots := MapSnapshotsToVolumes(snapshots)
+	for _, snaps := range mappedSnapshots {
+		SortSnapshotsByStartTime(snaps)
 	}
 
-	return mapVolumesToIds(volumes), nil
+	return mappedSnapshots, nil
 }
 
-// GetLatestSnapshots used to obtain recent EC2 EBS snapshots
-func (c *ebsClient) GetSnapshots() (EC2Snapshots, error) {
-	snapshots := make([]*ec2.Snapshot, 0)
-
-	snaps, err := c.ec2Client.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
-		MaxResults: &resultsPerRequest,
+// GetSnapshotCopies used to obtain snapshots copied by ebs-snapshotter, mapped by source volume ID
+func (c *ebsClient) GetSnapshotCopies(ctx context.Context) (EC2Snapshots, error) {
+	snapshots, err := c.describeSnapshots(ctx, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []*string{aws.String("self")},
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String(SourceSnapshotIDTag)},
+			},
+		},
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "error while describing snapshots")
-	}
-
-	snapshots = append(snapshots, snaps.Snapshots...)
-	for snaps.NextToken != nil {
-		snaps, err = c.ec2Client.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
-			MaxResults: &resultsPerRequest,
-			NextToken:  snaps.NextToken,
-		})
-		if err != nil {
-			return nil, errors.Wrap(err, "error while describing snapshots")
-		}
-		snapshots = append(snapshots, snaps.Snapshots...)
+		return nil, err
 	}
 
-	mappedSnapshots := MapSnapshotsToVolumes(snapshots)
+	mappedSnapshots := MapSnapshotCopiesToVolumes(snapshots)
 	for _, snaps := range mappedSnapshots {
 		SortSnapshotsByStartTime(snaps)
 	}
@@ -94,32 +176,280 @@ func (c *ebsClient) GetSnapshots() (EC2Snapshots, error) {
 	return mappedSnapshots, nil
 }
 
-// CreateSnapshot used to create a new EC2 EBS snapshot for given volume
-func (c *ebsClient) CreateSnapshot(volume *ec2.Volume) error {
+func (c *ebsClient) describeSnapshots(ctx context.Context, input *ec2.DescribeSnapshotsInput) ([]*ec2.Snapshot, error) {
+	snapshots := make([]*ec2.Snapshot, 0)
+	input.MaxResults = &resultsPerRequest
+
+	for {
+		var snaps *ec2.DescribeSnapshotsOutput
+		if err := c.call(ctx, "describing snapshots", func() (err error) {
+			snaps, err = c.ec2Client.DescribeSnapshotsWithContext(ctx, input)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snaps.Snapshots...)
+		if snaps.NextToken == nil {
+			return snapshots, nil
+		}
+		input.NextToken = snaps.NextToken
+	}
+}
+
+// CreateSnapshot used to create a new EC2 EBS snapshot for given volume, tagged with the volume's
+// tags and the extra tags given
+func (c *ebsClient) CreateSnapshot(ctx context.Context, volume *ec2.Volume, tags []*ec2.Tag) (*ec2.Snapshot, error) {
 	desc := string("Created by ebs-snapshotter")
 	input := &ec2.CreateSnapshotInput{
 		VolumeId:    volume.VolumeId,
 		Description: &desc,
 	}
+	// Snapshots carry the volume's tags, so they can still be found by PVC once the volume is gone.
+	// Control tags, such as those of a volume restored from a held snapshot, only apply to the
+	// resource they are set on.
+	if tags := append(WithoutControlTags(CopyableTags(volume.Tags)), tags...); len(tags) > 0 {
+		input.TagSpecifications = []*ec2.TagSpecification{
+			{
+				ResourceType: aws.String(ec2.ResourceTypeSnapshot),
+				Tags:         tags,
+			},
+		}
+	}
 
-	if _, err := c.ec2Client.CreateSnapshot(input); err != nil {
-		return errors.Wrap(err, "error while creating a snapshot")
+	var snapshot *ec2.Snapshot
+	if err := c.call(ctx, "creating a snapshot", func() (err error) {
+		snapshot, err = c.ec2Client.CreateSnapshotWithContext(ctx, input)
+		return err
+	}); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return snapshot, nil
 }
 
 // RemoveSnapshot used to remove EC2 EBS snapshot
-func (c *ebsClient) RemoveSnapshot(snapshot *ec2.Snapshot) error {
-	if _, err := c.ec2Client.DeleteSnapshot(&ec2.DeleteSnapshotInput{
-		SnapshotId: snapshot.SnapshotId,
+func (c *ebsClient) RemoveSnapshot(ctx context.Context, snapshot *ec2.Snapshot) error {
+	return c.call(ctx, "removing a snapshot", func() error {
+		_, err := c.ec2Client.DeleteSnapshotWithContext(ctx, &ec2.DeleteSnapshotInput{
+			SnapshotId: snapshot.SnapshotId,
+		})
+		return err
+	})
+}
+
+// CopySnapshot used to copy an EC2 EBS snapshot from the source region into the client's region.
+// The copy is tagged with the source snapshot and volume IDs and its ID is returned.
+func (c *ebsClient) CopySnapshot(ctx context.Context, snapshot *ec2.Snapshot, sourceRegion, kmsKeyID string) (string, error) {
+	desc := fmt.Sprintf("Copy of %s created by ebs-snapshotter", *snapshot.SnapshotId)
+	input := &ec2.CopySnapshotInput{
+		SourceRegion:     &sourceRegion,
+		SourceSnapshotId: snapshot.SnapshotId,
+		Description:      &desc,
+		TagSpecifications: []*ec2.TagSpecification{
+			{
+				ResourceType: aws.String(ec2.ResourceTypeSnapshot),
+				Tags: []*ec2.Tag{
+					{Key: aws.String(SourceSnapshotIDTag), Value: snapshot.SnapshotId},
+					{Key: aws.String(SourceVolumeIDTag), Value: snapshot.VolumeId},
+				},
+			},
+		},
+	}
+	if kmsKeyID != "" {
+		input.Encrypted = aws.Bool(true)
+		input.KmsKeyId = &kmsKeyID
+	}
+
+	var output *ec2.CopySnapshotOutput
+	if err := c.call(ctx, "copying a snapshot", func() (err error) {
+		output, err = c.ec2Client.CopySnapshotWithContext(ctx, input)
+		return err
 	}); err != nil {
-		return errors.Wrap(err, "error while removing a snapshot")
+		return "", err
+	}
+
+	return *output.SnapshotId, nil
+}
+
+// ShareSnapshot used to allow another account to create volumes from, and copy, given snapshot.
+// For encrypted snapshots the account is also granted use of the snapshot's KMS key.
+func (c *ebsClient) ShareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string) error {
+	if aws.BoolValue(snapshot.Encrypted) {
+		if c.kmsClient == nil {
+			return errors.Errorf("no KMS client configured to share encrypted snapshot %s", *snapshot.SnapshotId)
+		}
+		// Grants with the same name and parameters are idempotent, so this is safe to repeat
+		if err := c.call(ctx, "granting access to a snapshot key", func() error {
+			_, err := c.kmsClient.CreateGrantWithContext(ctx, &kms.CreateGrantInput{
+				KeyId:            snapshot.KmsKeyId,
+				Name:             aws.String(keyGrantName(accountID)),
+				GranteePrincipal: aws.String(fmt.Sprintf("arn:aws:iam::%s:root", accountID)),
+				Operations: aws.StringSlice([]string{
+					kms.GrantOperationDecrypt,
+					kms.GrantOperationDescribeKey,
+					kms.GrantOperationCreateGrant,
+					kms.GrantOperationReEncryptFrom,
+					kms.GrantOperationGenerateDataKeyWithoutPlaintext,
+				}),
+			})
+			return err
+		}); err != nil {
+			return err
+		}
 	}
 
+	return c.call(ctx, "sharing a snapshot", func() error {
+		_, err := c.ec2Client.ModifySnapshotAttributeWithContext(ctx, &ec2.ModifySnapshotAttributeInput{
+			SnapshotId:    snapshot.SnapshotId,
+			Attribute:     aws.String(ec2.SnapshotAttributeNameCreateVolumePermission),
+			OperationType: aws.String(ec2.OperationTypeAdd),
+			UserIds:       []*string{&accountID},
+		})
+		return err
+	})
+}
+
+// UnshareSnapshot used to stop another account from creating volumes from, and copying, given snapshot.
+// For encrypted snapshots the account's grant on the snapshot's KMS key is revoked too, unless
+// keepKeyGrant is set, such as while other snapshots encrypted with the key are still shared.
+func (c *ebsClient) UnshareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string, keepKeyGrant bool) error {
+	if err := c.call(ctx, "unsharing a snapshot", func() error {
+		_, err := c.ec2Client.ModifySnapshotAttributeWithContext(ctx, &ec2.ModifySnapshotAttributeInput{
+			SnapshotId:    snapshot.SnapshotId,
+			Attribute:     aws.String(ec2.SnapshotAttributeNameCreateVolumePermission),
+			OperationType: aws.String(ec2.OperationTypeRemove),
+			UserIds:       []*string{&accountID},
+		})
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if !aws.BoolValue(snapshot.Encrypted) || keepKeyGrant {
+		return nil
+	}
+	if c.kmsClient == nil {
+		return errors.Errorf("no KMS client configured to revoke the key grant of snapshot %s", *snapshot.SnapshotId)
+	}
+	grantIDs := make([]*string, 0)
+	if err := c.call(ctx, "listing snapshot key grants", func() error {
+		grantIDs = grantIDs[:0]
+		return c.kmsClient.ListGrantsPagesWithContext(ctx, &kms.ListGrantsInput{KeyId: snapshot.KmsKeyId},
+			func(page *kms.ListGrantsResponse, _ bool) bool {
+				for _, grant := range page.Grants {
+					if aws.StringValue(grant.Name) == keyGrantName(accountID) {
+						grantIDs = append(grantIDs, grant.GrantId)
+					}
+				}
+				return true
+			})
+	}); err != nil {
+		return err
+	}
+	for _, grantID := range grantIDs {
+		if err := c.call(ctx, "revoking a snapshot key grant", func() error {
+			_, err := c.kmsClient.RevokeGrantWithContext(ctx, &kms.RevokeGrantInput{
+				KeyId:   snapshot.KmsKeyId,
+				GrantId: grantID,
+			})
+			return err
+		}); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// keyGrantName used to obtain the name of the KMS key grant given account is shared snapshots with
+func keyGrantName(accountID string) string {
+	return "ebs-snapshotter-" + accountID
+}
+
+// CreateVolume used to create a new EC2 EBS volume from given snapshot
+func (c *ebsClient) CreateVolume(ctx context.Context, snapshot *ec2.Snapshot, options *VolumeOptions) (*ec2.Volume, error) {
+	input := &ec2.CreateVolumeInput{
+		SnapshotId:       snapshot.SnapshotId,
+		AvailabilityZone: &options.AvailabilityZone,
+	}
+	if options.VolumeType != "" {
+		input.VolumeType = &options.VolumeType
+	}
+	if options.Iops > 0 {
+		input.Iops = &options.Iops
+	}
+	if options.Throughput > 0 {
+		input.Throughput = &options.Throughput
+	}
+	if len(options.Tags) > 0 {
+		input.TagSpecifications = []*ec2.TagSpecification{
+			{
+				ResourceType: aws.String(ec2.ResourceTypeVolume),
+				Tags:         options.Tags,
+			},
+		}
+	}
+
+	var volume *ec2.Volume
+	if err := c.call(ctx, "creating a volume", func() (err error) {
+		volume, err = c.ec2Client.CreateVolumeWithContext(ctx, input)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return volume, nil
+}
+
+// TagSnapshot used to add given tags to a snapshot, replacing the values of existing keys
+func (c *ebsClient) TagSnapshot(ctx context.Context, snapshotID string, tags []*ec2.Tag) error {
+	return c.call(ctx, "tagging a snapshot", func() error {
+		_, err := c.ec2Client.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+			Resources: []*string{&snapshotID},
+			Tags:      tags,
+		})
+		return err
+	})
+}
+
+// UntagSnapshot used to remove the tags with given keys from a snapshot
+func (c *ebsClient) UntagSnapshot(ctx context.Context, snapshotID string, keys []string) error {
+	tags := make([]*ec2.Tag, 0, len(keys))
+	for _, key := range keys {
+		tags = append(tags, &ec2.Tag{Key: aws.String(key)})
+	}
+	return c.call(ctx, "untagging a snapshot", func() error {
+		_, err := c.ec2Client.DeleteTagsWithContext(ctx, &ec2.DeleteTagsInput{
+			Resources: []*string{&snapshotID},
+			Tags:      tags,
+		})
+		return err
+	})
+}
+
+// CopyableTags used to obtain the tags that can be set on other resources, which excludes
+// the reserved `aws:` tags
+func CopyableTags(tags []*ec2.Tag) []*ec2.Tag {
+	output := make([]*ec2.Tag, 0, len(tags))
+	for _, tag := range tags {
+		if !strings.HasPrefix(*tag.Key, "aws:") {
+			output = append(output, &ec2.Tag{Key: tag.Key, Value: tag.Value})
+		}
+	}
+	return output
+}
+
+// WithoutControlTags used to obtain the tags without the `ebs-snapshotter/` ones, which track and
+// protect a single snapshot
+func WithoutControlTags(tags []*ec2.Tag) []*ec2.Tag {
+	output := make([]*ec2.Tag, 0, len(tags))
+	for _, tag := range tags {
+		if !strings.HasPrefix(*tag.Key, ControlTagPrefix) {
+			output = append(output, tag)
+		}
+	}
+	return output
+}
+
 func mapVolumesToIds(volumes []*ec2.Volume) EC2Volumes {
 	output := make(EC2Volumes)
 	for _, vol := range volumes {
@@ -143,6 +473,72 @@ func MapSnapshotsToVolumes(snapshots []*ec2.Snapshot) EC2Snapshots {
 	return output
 }
 
+// MapSnapshotCopiesToVolumes used to map EBS snapshot copies by the source volume ID tag.
+// Snapshots without the tag are ignored.
+func MapSnapshotCopiesToVolumes(snapshots []*ec2.Snapshot) EC2Snapshots {
+	output := make(EC2Snapshots)
+	for _, snapshot := range snapshots {
+		for _, tag := range snapshot.Tags {
+			if *tag.Key == SourceVolumeIDTag {
+				output[*tag.Value] = append(output[*tag.Value], snapshot)
+			}
+		}
+	}
+	return output
+}
+
+// SourceSnapshotID used to obtain the source snapshot ID of a snapshot copy
+func SourceSnapshotID(snapshot *ec2.Snapshot) string {
+	for _, tag := range snapshot.Tags {
+		if *tag.Key == SourceSnapshotIDTag {
+			return *tag.Value
+		}
+	}
+	return ""
+}
+
+// SourceVolumeID used to obtain the source volume ID of a snapshot copy
+func SourceVolumeID(snapshot *ec2.Snapshot) string {
+	for _, tag := range snapshot.Tags {
+		if *tag.Key == SourceVolumeIDTag {
+			return *tag.Value
+		}
+	}
+	return ""
+}
+
+// RetainUntil used to obtain the time until which retention keeps a snapshot, the second return
+// value is false when the snapshot has no valid retain until tag
+func RetainUntil(snapshot *ec2.Snapshot) (time.Time, bool) {
+	for _, tag := range snapshot.Tags {
+		if *tag.Key == RetainUntilTag {
+			until, err := time.Parse(time.RFC3339, *tag.Value)
+			return until, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// Held used to check whether a snapshot is under a hold, which keeps it indefinitely
+func Held(snapshot *ec2.Snapshot) bool {
+	for _, tag := range snapshot.Tags {
+		if *tag.Key == HoldTag {
+			return strings.EqualFold(aws.StringValue(tag.Value), "true")
+		}
+	}
+	return false
+}
+
+// Protected used to check whether a snapshot must not be removed by retention at given time,
+// because it is held or retained until a later time
+func Protected(snapshot *ec2.Snapshot, now time.Time) bool {
+	if Held(snapshot) {
+		return true
+	}
+	until, ok := RetainUntil(snapshot)
+	return ok && until.After(now)
+}
+
 // SortSnapshotsByStartTime used to sort EBS snapshots by start time
 func SortSnapshotsByStartTime(snapshots []*ec2.Snapshot) {
 	sort.Sort(SortByStartTime(snapshots))
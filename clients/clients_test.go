@@ -1,13 +1,26 @@
 package clients_test
 
 import (
+	"context"
 	"testing"
 
 	"fmt"
+	"log/slog"
+	"sort"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/clock"
+	"github.com/utilitywarehouse/ebs-snapshotter/fakeec2"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	. "gopkg.in/check.v1"
 )
 
@@ -58,10 +71,588 @@ func (s *EBSClientSuite) TestSnapshotsCorrectlyMappedPerVolumeId(c *C) {
 	c.Assert(len(mappedSnapshots["volume-5"]), Equals, 1)
 }
 
+func (s *EBSClientSuite) TestSnapshotCopiesMappedPerSourceVolumeId(c *C) {
+	timeNow := time.Now()
+	snaps := []*ec2.Snapshot{
+		createFakeEBSSnapshotCopy("copy-1", "test-snapshot-1", "volume-1", timeNow),
+		createFakeEBSSnapshotCopy("copy-2", "test-snapshot-2", "volume-1", timeNow),
+		createFakeEBSSnapshotCopy("copy-3", "test-snapshot-3", "volume-2", timeNow),
+		createFakeEBSSnapshot("test-snapshot-4", "volume-3", timeNow),
+	}
+
+	mappedSnapshots := clients.MapSnapshotCopiesToVolumes(snaps)
+
+	c.Assert(len(mappedSnapshots), Equals, 2)
+	c.Assert(len(mappedSnapshots["volume-1"]), Equals, 2)
+	c.Assert(len(mappedSnapshots["volume-2"]), Equals, 1)
+	c.Assert(clients.SourceSnapshotID(mappedSnapshots["volume-2"][0]), Equals, "test-snapshot-3")
+}
+
+func (s *EBSClientSuite) TestOnlyCompletedSnapshotsWithoutCopyAreCopied(c *C) {
+	timeNow := time.Now()
+	dest := &fakeEBSClient{
+		copies: clients.EC2Snapshots{
+			"volume-1": {createFakeEBSSnapshotCopy("copy-1", "test-snapshot-1", "volume-1", timeNow)},
+		},
+	}
+	copier := clients.NewSnapshotCopier("eu-west-1", map[string]clients.EBSClient{"eu-central-1": dest}, slog.Default())
+
+	_, err := copier.Refresh(context.Background(), "eu-central-1")
+	c.Assert(err, IsNil)
+
+	copied := createFakeEBSSnapshot("test-snapshot-1", "volume-1", timeNow)
+	pending := createFakeEBSSnapshot("test-snapshot-2", "volume-1", timeNow)
+	pending.State = aws.String("pending")
+	completed := createFakeEBSSnapshot("test-snapshot-3", "volume-1", timeNow)
+
+	for _, snapshot := range []*ec2.Snapshot{copied, pending, completed, completed} {
+		_, err := copier.StartCopy(context.Background(), snapshot, "eu-central-1", "")
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(dest.copied, DeepEquals, []string{"test-snapshot-3"})
+}
+
+func (s *EBSClientSuite) TestCopyToUnknownRegionFails(c *C) {
+	copier := clients.NewSnapshotCopier("eu-west-1", map[string]clients.EBSClient{}, slog.Default())
+
+	_, err := copier.Refresh(context.Background(), "eu-central-1")
+
+	c.Assert(err, ErrorMatches, "no client configured for eu-central-1 region")
+}
+
+func (s *EBSClientSuite) TestSnapshotSharedWithVaultAccountBeforeCopy(c *C) {
+	source := &fakeEBSClient{}
+	vault := &fakeEBSClient{copies: clients.EC2Snapshots{}}
+	copier := clients.NewVaultCopier(source, "eu-west-1", "222222222222", "eu-west-1", vault, slog.Default())
+
+	_, err := copier.Refresh(context.Background(), "eu-west-1")
+	c.Assert(err, IsNil)
+
+	started, err := copier.StartCopy(context.Background(), createFakeEBSSnapshot("test-snapshot-1", "volume-1", time.Now()), "eu-west-1", "")
+
+	c.Assert(err, IsNil)
+	c.Assert(started, Equals, true)
+	c.Assert(source.shared, DeepEquals, []string{"222222222222/test-snapshot-1"})
+	c.Assert(source.copied, IsNil)
+	c.Assert(vault.copied, DeepEquals, []string{"test-snapshot-1"})
+}
+
+func (s *EBSClientSuite) TestSnapshotUnsharedOnceVaultCopyFinished(c *C) {
+	timeNow := time.Now()
+	source := &fakeEBSClient{}
+	vault := &fakeEBSClient{copies: clients.EC2Snapshots{}}
+	copier := clients.NewVaultCopier(source, "eu-west-1", "222222222222", "eu-west-1", vault, slog.Default())
+
+	_, err := copier.Refresh(context.Background(), "eu-west-1")
+	c.Assert(err, IsNil)
+
+	key := aws.String("key-1")
+	snapshots := []*ec2.Snapshot{
+		createFakeEBSSnapshot("test-snapshot-1", "volume-1", timeNow),
+		createFakeEBSSnapshot("test-snapshot-2", "volume-2", timeNow),
+		createFakeEBSSnapshot("test-snapshot-3", "volume-3", timeNow),
+	}
+	for _, snapshot := range snapshots {
+		snapshot.Encrypted = aws.Bool(true)
+		snapshot.KmsKeyId = key
+		_, err := copier.StartCopy(context.Background(), snapshot, "eu-west-1", "")
+		c.Assert(err, IsNil)
+	}
+
+	pending := createFakeEBSSnapshotCopy("copy-test-snapshot-1", "test-snapshot-1", "volume-1", timeNow)
+	pending.State = aws.String("pending")
+	failed := createFakeEBSSnapshotCopy("copy-test-snapshot-2", "test-snapshot-2", "volume-2", timeNow)
+	failed.State = aws.String("error")
+	vault.copies = clients.EC2Snapshots{
+		"volume-1": {pending},
+		"volume-2": {failed},
+		"volume-3": {createFakeEBSSnapshotCopy("copy-test-snapshot-3", "test-snapshot-3", "volume-3", timeNow)},
+	}
+	_, err = copier.Refresh(context.Background(), "eu-west-1")
+	c.Assert(err, IsNil)
+
+	// The key grant is kept while the copy of another snapshot encrypted with the key is pending
+	sort.Strings(source.unshared)
+	c.Assert(source.unshared, DeepEquals, []string{
+		"222222222222/test-snapshot-2/true",
+		"222222222222/test-snapshot-3/true",
+	})
+
+	pending.State = aws.String("completed")
+	_, err = copier.Refresh(context.Background(), "eu-west-1")
+	c.Assert(err, IsNil)
+
+	c.Assert(source.unshared, HasLen, 3)
+	c.Assert(source.unshared[2], Equals, "222222222222/test-snapshot-1/false")
+
+	_, err = copier.Refresh(context.Background(), "eu-west-1")
+	c.Assert(err, IsNil)
+	c.Assert(source.unshared, HasLen, 3)
+}
+
+func (s *EBSClientSuite) TestSourceSnapshotNoLongerSharedAfterVaultCopy(c *C) {
+	source := fakeec2.New("eu-west-1", "111111111111")
+	vault := fakeec2.New("eu-central-1", "222222222222")
+	vault.LinkRegion(source)
+	volumeID := source.AddVolume(&ec2.Volume{})
+	snapshotID := source.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String(volumeID)})
+
+	copier := clients.NewVaultCopier(
+		newClientWithoutRetries(source), "eu-west-1", "222222222222",
+		"eu-central-1", newClientWithoutRetries(vault), slog.Default())
+	_, err := copier.Refresh(context.Background(), "eu-central-1")
+	c.Assert(err, IsNil)
+
+	started, err := copier.StartCopy(context.Background(), source.Snapshot(snapshotID), "eu-central-1", "")
+	c.Assert(err, IsNil)
+	c.Assert(started, Equals, true)
+	c.Assert(source.SharedWith(snapshotID), DeepEquals, []string{"222222222222"})
+
+	copies, err := copier.Refresh(context.Background(), "eu-central-1")
+	c.Assert(err, IsNil)
+
+	c.Assert(copies[volumeID], HasLen, 1)
+	c.Assert(*copies[volumeID][0].State, Equals, ec2.SnapshotStateCompleted)
+	c.Assert(source.SharedWith(snapshotID), HasLen, 0)
+}
+
+func (s *EBSClientSuite) TestReservedTagsNotCopied(c *C) {
+	tags := clients.CopyableTags([]*ec2.Tag{
+		{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("stack")},
+		{Key: aws.String("kubernetes.io/created-for/pvc/name"), Value: aws.String("datadir-kafka-0")},
+	})
+
+	c.Assert(len(tags), Equals, 1)
+	c.Assert(*tags[0].Key, Equals, "kubernetes.io/created-for/pvc/name")
+}
+
+func (s *EBSClientSuite) TestHeldOrRetainedSnapshotsProtected(c *C) {
+	now := time.Now()
+	held := createFakeEBSSnapshot("snap-1", "vol-1", now)
+	held.Tags = []*ec2.Tag{{Key: aws.String(clients.HoldTag), Value: aws.String("true")}}
+	released := createFakeEBSSnapshot("snap-2", "vol-1", now)
+	released.Tags = []*ec2.Tag{{Key: aws.String(clients.HoldTag), Value: aws.String("false")}}
+	retained := createFakeEBSSnapshot("snap-3", "vol-1", now)
+	retained.Tags = []*ec2.Tag{{Key: aws.String(clients.RetainUntilTag), Value: aws.String(now.Add(time.Hour).Format(time.RFC3339))}}
+	expired := createFakeEBSSnapshot("snap-4", "vol-1", now)
+	expired.Tags = []*ec2.Tag{{Key: aws.String(clients.RetainUntilTag), Value: aws.String(now.Add(-time.Hour).Format(time.RFC3339))}}
+
+	c.Assert(clients.Protected(held, now), Equals, true)
+	c.Assert(clients.Protected(released, now), Equals, false)
+	c.Assert(clients.Protected(retained, now), Equals, true)
+	c.Assert(clients.Protected(expired, now), Equals, false)
+	c.Assert(clients.Protected(createFakeEBSSnapshot("snap-5", "vol-1", now), now), Equals, false)
+}
+
+func (s *EBSClientSuite) TestInstrumentedCallsTimedAndTraced(c *C) {
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"operation"})
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	client := clients.NewInstrumentedEBSClient(&fakeEBSClient{}, latency, provider.Tracer("test"))
+
+	_, err := client.CreateSnapshot(context.Background(), &ec2.Volume{VolumeId: aws.String("vol-1")}, nil)
+
+	c.Assert(err, IsNil)
+	c.Assert(testutil.CollectAndCount(latency), Equals, 1)
+	spans := recorder.Ended()
+	c.Assert(spans, HasLen, 1)
+	c.Assert(spans[0].Name(), Equals, "ebs.create_snapshot")
+	c.Assert(spans[0].Attributes(), DeepEquals, []attribute.KeyValue{attribute.String("volume.id", "vol-1")})
+}
+
+func (s *EBSClientSuite) TestCreateSnapshot(c *C) {
+	pvcTag := &ec2.Tag{Key: aws.String("kubernetes.io/created-for/pvc/name"), Value: aws.String("datadir-kafka-0")}
+	labelTag := &ec2.Tag{Key: aws.String(clients.LabelTag), Value: aws.String("before-upgrade")}
+	cases := []struct {
+		name     string
+		volume   *ec2.Volume
+		tags     []*ec2.Tag
+		fail     error
+		expected []*ec2.Tag
+		code     string
+		class    clients.ErrorClass
+	}{
+		{
+			name:   "volume without tags",
+			volume: &ec2.Volume{VolumeId: aws.String("vol-1")},
+		},
+		{
+			name: "volume tags without reserved ones",
+			volume: &ec2.Volume{VolumeId: aws.String("vol-1"), Tags: []*ec2.Tag{
+				pvcTag,
+				{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("stack")},
+			}},
+			expected: []*ec2.Tag{pvcTag},
+		},
+		{
+			name: "volume tags without control ones",
+			volume: &ec2.Volume{VolumeId: aws.String("vol-1"), Tags: []*ec2.Tag{
+				pvcTag,
+				{Key: aws.String(clients.HoldTag), Value: aws.String("true")},
+				{Key: aws.String("ebs-snapshotter/restored-from"), Value: aws.String("snap-1")},
+			}},
+			expected: []*ec2.Tag{pvcTag},
+		},
+		{
+			name:     "volume and extra tags",
+			volume:   &ec2.Volume{VolumeId: aws.String("vol-1"), Tags: []*ec2.Tag{pvcTag}},
+			tags:     []*ec2.Tag{labelTag},
+			expected: []*ec2.Tag{pvcTag, labelTag},
+		},
+		{
+			name:   "missing volume",
+			volume: &ec2.Volume{VolumeId: aws.String("vol-2")},
+			code:   fakeec2.ErrCodeVolumeNotFound,
+			class:  clients.ErrorClassNotFound,
+		},
+		{
+			name:   "throttled",
+			volume: &ec2.Volume{VolumeId: aws.String("vol-1")},
+			fail:   awserr.New(fakeec2.ErrCodeThrottled, "Request limit exceeded.", nil),
+			code:   fakeec2.ErrCodeThrottled,
+			class:  clients.ErrorClassThrottled,
+		},
+	}
+
+	for _, tc := range cases {
+		fake := fakeec2.New("eu-west-1", "111111111111")
+		fake.AddVolume(&ec2.Volume{VolumeId: aws.String("vol-1"), Size: aws.Int64(10)})
+		if tc.fail != nil {
+			fake.FailNext(fakeec2.CreateSnapshot, tc.fail)
+		}
+
+		snapshot, err := newClientWithoutRetries(fake).CreateSnapshot(context.Background(), tc.volume, tc.tags)
+
+		if tc.code != "" {
+			c.Assert(awsErrorCode(err), Equals, tc.code, Commentf(tc.name))
+			c.Assert(clients.ErrorClassOf(err), Equals, tc.class, Commentf(tc.name))
+			c.Assert(err, ErrorMatches, "error while creating a snapshot: .*", Commentf(tc.name))
+			c.Assert(fake.Snapshots(""), HasLen, 0, Commentf(tc.name))
+			continue
+		}
+		c.Assert(err, IsNil, Commentf(tc.name))
+		c.Assert(*snapshot.VolumeId, Equals, "vol-1", Commentf(tc.name))
+		c.Assert(*snapshot.VolumeSize, Equals, int64(10), Commentf(tc.name))
+		c.Assert(*snapshot.Description, Equals, "Created by ebs-snapshotter", Commentf(tc.name))
+		c.Assert(snapshot.Tags, DeepEquals, tc.expected, Commentf(tc.name))
+		c.Assert(fake.Snapshot(*snapshot.SnapshotId), NotNil, Commentf(tc.name))
+	}
+}
+
+func (s *EBSClientSuite) TestRemoveSnapshot(c *C) {
+	cases := []struct {
+		name       string
+		snapshotID string
+		inUseBy    string
+		fail       error
+		code       string
+		class      clients.ErrorClass
+		removed    bool
+	}{
+		{
+			name:       "existing snapshot",
+			snapshotID: "snap-1",
+			removed:    true,
+		},
+		{
+			name:       "snapshot backing an AMI",
+			snapshotID: "snap-1",
+			inUseBy:    "ami-1",
+			code:       fakeec2.ErrCodeSnapshotInUse,
+			class:      clients.ErrorClassInUse,
+		},
+		{
+			name:       "missing snapshot",
+			snapshotID: "snap-2",
+			code:       fakeec2.ErrCodeSnapshotNotFound,
+			class:      clients.ErrorClassNotFound,
+		},
+		{
+			name:       "throttled",
+			snapshotID: "snap-1",
+			fail:       awserr.New(fakeec2.ErrCodeThrottled, "Request limit exceeded.", nil),
+			code:       fakeec2.ErrCodeThrottled,
+			class:      clients.ErrorClassThrottled,
+		},
+		{
+			name:       "not authorised",
+			snapshotID: "snap-1",
+			fail:       awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil),
+			code:       "UnauthorizedOperation",
+			class:      clients.ErrorClassPermission,
+		},
+	}
+
+	for _, tc := range cases {
+		fake := fakeec2.New("eu-west-1", "111111111111")
+		fake.AddSnapshot(&ec2.Snapshot{SnapshotId: aws.String("snap-1"), VolumeId: aws.String("vol-1")})
+		fake.SetSnapshotInUse("snap-1", tc.inUseBy)
+		if tc.fail != nil {
+			fake.FailNext(fakeec2.DeleteSnapshot, tc.fail)
+		}
+
+		err := newClientWithoutRetries(fake).RemoveSnapshot(
+			context.Background(), &ec2.Snapshot{SnapshotId: aws.String(tc.snapshotID)})
+
+		if tc.code != "" {
+			c.Assert(awsErrorCode(err), Equals, tc.code, Commentf(tc.name))
+			c.Assert(clients.ErrorClassOf(err), Equals, tc.class, Commentf(tc.name))
+			c.Assert(err, ErrorMatches, "error while removing a snapshot: .*", Commentf(tc.name))
+		} else {
+			c.Assert(err, IsNil, Commentf(tc.name))
+		}
+		c.Assert(fake.Snapshot("snap-1") == nil, Equals, tc.removed, Commentf(tc.name))
+	}
+}
+
+func (s *EBSClientSuite) TestVolumesAndSnapshotsListedAcrossPages(c *C) {
+	cases := []struct {
+		name     string
+		count    int
+		pageSize int
+		calls    int
+	}{
+		{name: "none", count: 0, calls: 1},
+		{name: "single page", count: 3, calls: 1},
+		{name: "exact pages", count: 4, pageSize: 2, calls: 2},
+		{name: "partial last page", count: 5, pageSize: 2, calls: 3},
+		{name: "more than max results", count: 1001, calls: 2},
+	}
+
+	for _, tc := range cases {
+		fake := fakeec2.New("eu-west-1", "111111111111")
+		fake.SetPageSize(tc.pageSize)
+		for i := 0; i < tc.count; i++ {
+			volumeID := fake.AddVolume(&ec2.Volume{})
+			fake.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String(volumeID)})
+		}
+		client := clients.NewEBSClient(fake, nil)
+
+		volumes, err := client.GetVolumes(context.Background())
+		c.Assert(err, IsNil, Commentf(tc.name))
+		c.Assert(volumes, HasLen, tc.count, Commentf(tc.name))
+
+		snapshots, err := client.GetSnapshots(context.Background())
+		c.Assert(err, IsNil, Commentf(tc.name))
+		c.Assert(snapshots, HasLen, tc.count, Commentf(tc.name))
+
+		calls := make(map[string]int)
+		for _, call := range fake.Calls() {
+			calls[call]++
+		}
+		c.Assert(calls, DeepEquals, map[string]int{
+			fakeec2.DescribeVolumes:   tc.calls,
+			fakeec2.DescribeSnapshots: tc.calls,
+		}, Commentf(tc.name))
+	}
+}
+
+func (s *EBSClientSuite) TestFailedPageFailsListing(c *C) {
+	fake := fakeec2.New("eu-west-1", "111111111111")
+	fake.SetPageSize(1)
+	fake.AddVolume(&ec2.Volume{})
+	fake.AddVolume(&ec2.Volume{})
+	fake.FailNext(fakeec2.DescribeVolumes, nil)
+	fake.Throttle(fakeec2.DescribeVolumes, 1)
+
+	_, err := newClientWithoutRetries(fake).GetVolumes(context.Background())
+
+	c.Assert(awsErrorCode(err), Equals, fakeec2.ErrCodeThrottled)
+	c.Assert(fake.Calls(), DeepEquals, []string{fakeec2.DescribeVolumes, fakeec2.DescribeVolumes})
+}
+
+func (s *EBSClientSuite) TestErrorsClassified(c *C) {
+	cases := []struct {
+		err   error
+		class clients.ErrorClass
+	}{
+		{awserr.New("RequestLimitExceeded", "", nil), clients.ErrorClassThrottled},
+		{awserr.New("SnapshotCreationPerVolumeRateExceeded", "", nil), clients.ErrorClassThrottled},
+		{awserr.New("InvalidSnapshot.InUse", "", nil), clients.ErrorClassInUse},
+		{awserr.New("InvalidSnapshot.NotFound", "", nil), clients.ErrorClassNotFound},
+		{awserr.New("InvalidVolume.NotFound", "", nil), clients.ErrorClassNotFound},
+		{awserr.New("UnauthorizedOperation", "", nil), clients.ErrorClassPermission},
+		{awserr.New("AccessDeniedException", "", nil), clients.ErrorClassPermission},
+		{awserr.New("InternalError", "", nil), clients.ErrorClassUnknown},
+		{errors.New("connection reset"), clients.ErrorClassUnknown},
+	}
+
+	for _, tc := range cases {
+		fake := fakeec2.New("eu-west-1", "111111111111")
+		fake.FailNext(fakeec2.DeleteSnapshot, tc.err)
+
+		err := newClientWithoutRetries(fake).RemoveSnapshot(context.Background(), &ec2.Snapshot{SnapshotId: aws.String("snap-1")})
+
+		c.Assert(clients.ErrorClassOf(err), Equals, tc.class, Commentf(tc.err.Error()))
+		// The class is kept when the error is wrapped further
+		c.Assert(clients.ErrorClassOf(errors.Wrap(err, "error while removing old snapshots")), Equals, tc.class)
+	}
+	c.Assert(clients.ErrorClassOf(errors.New("not from a client")), Equals, clients.ErrorClassUnknown)
+}
+
+func (s *EBSClientSuite) TestThrottledRequestsRetriedWithBackoff(c *C) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	policy := clients.RetryPolicy{
+		clients.ErrorClassThrottled: {Retries: 3, Initial: time.Second, Max: 3 * time.Second},
+	}
+	cases := []struct {
+		name      string
+		throttled int
+		fail      error
+		calls     int
+		waited    time.Duration
+		class     clients.ErrorClass
+	}{
+		{name: "no errors", calls: 1},
+		{name: "throttled once", throttled: 1, calls: 2, waited: time.Second},
+		{name: "throttled until the last retry", throttled: 3, calls: 4, waited: 6 * time.Second},
+		{
+			name:      "throttled beyond retries",
+			throttled: 4,
+			calls:     4,
+			waited:    6 * time.Second,
+			class:     clients.ErrorClassThrottled,
+		},
+		{
+			name:  "in use",
+			fail:  awserr.New(fakeec2.ErrCodeSnapshotInUse, "", nil),
+			calls: 1,
+			class: clients.ErrorClassInUse,
+		},
+		{
+			name:  "not authorised",
+			fail:  awserr.New("UnauthorizedOperation", "", nil),
+			calls: 1,
+			class: clients.ErrorClassPermission,
+		},
+	}
+
+	for _, tc := range cases {
+		clk := clock.NewSimulated(start)
+		fake := fakeec2.New("eu-west-1", "111111111111")
+		fake.AddSnapshot(&ec2.Snapshot{SnapshotId: aws.String("snap-1"), VolumeId: aws.String("vol-1")})
+		fake.Throttle(fakeec2.DeleteSnapshot, tc.throttled)
+		if tc.fail != nil {
+			fake.FailNext(fakeec2.DeleteSnapshot, tc.fail)
+		}
+
+		err := clients.NewEBSClientWithRetryPolicy(fake, nil, policy, clk).RemoveSnapshot(
+			context.Background(), &ec2.Snapshot{SnapshotId: aws.String("snap-1")})
+
+		if tc.class != "" {
+			c.Assert(clients.ErrorClassOf(err), Equals, tc.class, Commentf(tc.name))
+		} else {
+			c.Assert(err, IsNil, Commentf(tc.name))
+		}
+		c.Assert(fake.Calls(), HasLen, tc.calls, Commentf(tc.name))
+		c.Assert(clk.Now().Sub(start), Equals, tc.waited, Commentf(tc.name))
+	}
+}
+
+func (s *EBSClientSuite) TestRetryStoppedWhenContextDone(c *C) {
+	fake := fakeec2.New("eu-west-1", "111111111111")
+	fake.Throttle(fakeec2.DescribeVolumes, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := clients.RetryPolicy{clients.ErrorClassThrottled: {Retries: 1, Initial: time.Hour}}
+
+	_, err := clients.NewEBSClientWithRetryPolicy(fake, nil, policy, &cancellingClock{cancel: cancel}).GetVolumes(ctx)
+
+	c.Assert(clients.ErrorClassOf(err), Equals, clients.ErrorClassThrottled)
+	c.Assert(fake.Calls(), HasLen, 1)
+}
+
+// cancellingClock used to cancel a context as soon as a wait starts, and to never end the wait
+type cancellingClock struct {
+	clock.Clock
+	cancel context.CancelFunc
+}
+
+func (c *cancellingClock) After(d time.Duration) <-chan time.Time {
+	c.cancel()
+	return make(chan time.Time)
+}
+
+// newClientWithoutRetries used to create a client that returns the error of the first attempt
+func newClientWithoutRetries(fake *fakeec2.EC2) clients.EBSClient {
+	return clients.NewEBSClientWithRetryPolicy(fake, nil, clients.RetryPolicy{}, clock.Real())
+}
+
+func awsErrorCode(err error) string {
+	if aerr, ok := errors.Cause(err).(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
+
+func createFakeEBSSnapshotCopy(snapshotId, sourceSnapshotId, sourceVolumeId string, startTime time.Time) *ec2.Snapshot {
+	snapshot := createFakeEBSSnapshot(snapshotId, "vol-ffffffff", startTime)
+	snapshot.Tags = []*ec2.Tag{
+		{Key: aws.String(clients.SourceSnapshotIDTag), Value: aws.String(sourceSnapshotId)},
+		{Key: aws.String(clients.SourceVolumeIDTag), Value: aws.String(sourceVolumeId)},
+	}
+	return snapshot
+}
+
 func createFakeEBSSnapshot(snapshotId, volumeId string, startTime time.Time) *ec2.Snapshot {
 	return &ec2.Snapshot{
 		SnapshotId: &snapshotId,
 		VolumeId:   &volumeId,
 		StartTime:  &startTime,
+		State:      aws.String("completed"),
 	}
 }
+
+type fakeEBSClient struct {
+	copies   clients.EC2Snapshots
+	copied   []string
+	shared   []string
+	unshared []string
+}
+
+func (f *fakeEBSClient) GetVolumes(ctx context.Context) (clients.EC2Volumes, error) {
+	return clients.EC2Volumes{}, nil
+}
+
+func (f *fakeEBSClient) GetSnapshots(ctx context.Context) (clients.EC2Snapshots, error) {
+	return clients.EC2Snapshots{}, nil
+}
+
+func (f *fakeEBSClient) CreateSnapshot(ctx context.Context, volume *ec2.Volume, tags []*ec2.Tag) (*ec2.Snapshot, error) {
+	return &ec2.Snapshot{VolumeId: volume.VolumeId}, nil
+}
+
+func (f *fakeEBSClient) RemoveSnapshot(ctx context.Context, snapshot *ec2.Snapshot) error {
+	return nil
+}
+
+func (f *fakeEBSClient) CopySnapshot(ctx context.Context, snapshot *ec2.Snapshot, sourceRegion, kmsKeyID string) (string, error) {
+	f.copied = append(f.copied, *snapshot.SnapshotId)
+	return "copy-" + *snapshot.SnapshotId, nil
+}
+
+func (f *fakeEBSClient) GetSnapshotCopies(ctx context.Context) (clients.EC2Snapshots, error) {
+	return f.copies, nil
+}
+
+func (f *fakeEBSClient) ShareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string) error {
+	f.shared = append(f.shared, accountID+"/"+*snapshot.SnapshotId)
+	return nil
+}
+
+func (f *fakeEBSClient) UnshareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string, keepKeyGrant bool) error {
+	f.unshared = append(f.unshared, fmt.Sprintf("%s/%s/%t", accountID, *snapshot.SnapshotId, keepKeyGrant))
+	return nil
+}
+
+func (f *fakeEBSClient) CreateVolume(ctx context.Context, snapshot *ec2.Snapshot, options *clients.VolumeOptions) (*ec2.Volume, error) {
+	return &ec2.Volume{}, nil
+}
+
+func (f *fakeEBSClient) TagSnapshot(ctx context.Context, snapshotID string, tags []*ec2.Tag) error {
+	return nil
+}
+
+func (f *fakeEBSClient) UntagSnapshot(ctx context.Context, snapshotID string, keys []string) error {
+	return nil
+}
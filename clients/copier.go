@@ -0,0 +1,239 @@
+package clients
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+)
+
+const (
+	snapshotStateCompleted = "completed"
+	snapshotStateError     = "error"
+)
+
+// SnapshotCopier interface specifies functions used to copy EBS snapshots to other regions or accounts
+type SnapshotCopier interface {
+	Refresh(ctx context.Context, region string) (EC2Snapshots, error)
+	StartCopy(ctx context.Context, snapshot *ec2.Snapshot, region, kmsKeyID string) (bool, error)
+	RemoveCopy(ctx context.Context, snapshot *ec2.Snapshot, region string) error
+}
+
+type snapshotCopier struct {
+	sourceRegion string
+	destinations map[string]EBSClient
+
+	// source and accountID are set when copying into another account,
+	// which requires snapshots to be shared with it first
+	source    EBSClient
+	accountID string
+
+	logger *slog.Logger
+
+	mu sync.Mutex
+	// copies holds the copies found in each region on the last refresh
+	copies map[string]EC2Snapshots
+	// pending holds the copies in progress, by region and source snapshot ID
+	pending map[string]map[string]*pendingCopy
+}
+
+// pendingCopy holds a copy in progress along with the snapshot it is copied from
+type pendingCopy struct {
+	copyID string
+	source *ec2.Snapshot
+}
+
+// NewSnapshotCopier used to create a new snapshot copier. Destinations map a region name
+// to an EBS client for that region.
+func NewSnapshotCopier(sourceRegion string, destinations map[string]EBSClient, logger *slog.Logger) SnapshotCopier {
+	return &snapshotCopier{
+		sourceRegion: sourceRegion,
+		destinations: destinations,
+		logger:       logger,
+		copies:       make(map[string]EC2Snapshots),
+		pending:      make(map[string]map[string]*pendingCopy),
+	}
+}
+
+// NewVaultCopier used to create a new snapshot copier that copies snapshots into a separate
+// vault account. Snapshots are shared with the vault account using the source client and
+// copied using the vault client, which must use credentials of the vault account.
+// Snapshots stop being shared with the vault account once their copy is completed or has failed.
+func NewVaultCopier(
+	source EBSClient,
+	sourceRegion, accountID, vaultRegion string,
+	vault EBSClient,
+	logger *slog.Logger) SnapshotCopier {
+
+	return &snapshotCopier{
+		sourceRegion: sourceRegion,
+		destinations: map[string]EBSClient{vaultRegion: vault},
+		source:       source,
+		accountID:    accountID,
+		logger:       logger,
+		copies:       make(map[string]EC2Snapshots),
+		pending:      make(map[string]map[string]*pendingCopy),
+	}
+}
+
+// Refresh used to obtain the snapshot copies in given region, mapped by source volume ID.
+// Copies in progress are tracked until they are completed or have failed.
+func (c *snapshotCopier) Refresh(ctx context.Context, region string) (EC2Snapshots, error) {
+	client, err := c.destination(region)
+	if err != nil {
+		return nil, err
+	}
+
+	copies, err := client.GetSnapshotCopies(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while fetching snapshot copies in %s", region)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.copies[region] = copies
+	for sourceID, pending := range c.pending[region] {
+		snapshot := findSnapshot(copies, pending.copyID)
+		if snapshot == nil {
+			// The copy may not be visible yet, keep tracking it
+			continue
+		}
+		logger := c.logger.With(
+			logging.SnapshotID, pending.copyID,
+			"source_snapshot_id", sourceID,
+			logging.Destination, region,
+			logging.Action, "copy_snapshot")
+		switch *snapshot.State {
+		case snapshotStateCompleted:
+			if !c.unshare(ctx, region, sourceID, pending) {
+				continue
+			}
+			logger.Info("snapshot copy completed")
+			delete(c.pending[region], sourceID)
+		case snapshotStateError:
+			if !c.unshare(ctx, region, sourceID, pending) {
+				continue
+			}
+			logger.Error("snapshot copy failed")
+			delete(c.pending[region], sourceID)
+		}
+	}
+
+	return copies, nil
+}
+
+// StartCopy used to start copying a snapshot into given region. Snapshots that are not
+// completed yet, or that already have a copy in the region, are skipped.
+// Returns true when a new copy has been started.
+func (c *snapshotCopier) StartCopy(ctx context.Context, snapshot *ec2.Snapshot, region, kmsKeyID string) (bool, error) {
+	if snapshot.State == nil || *snapshot.State != snapshotStateCompleted {
+		return false, nil
+	}
+
+	client, err := c.destination(region)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.pending[region][*snapshot.SnapshotId]; ok {
+		return false, nil
+	}
+	for _, cp := range c.copies[region][*snapshot.VolumeId] {
+		if SourceSnapshotID(cp) == *snapshot.SnapshotId && *cp.State != snapshotStateError {
+			return false, nil
+		}
+	}
+
+	if c.source != nil {
+		if err := c.source.ShareSnapshot(ctx, snapshot, c.accountID); err != nil {
+			return false, err
+		}
+	}
+
+	copyID, err := client.CopySnapshot(ctx, snapshot, c.sourceRegion, kmsKeyID)
+	if err != nil {
+		return false, err
+	}
+
+	if c.pending[region] == nil {
+		c.pending[region] = make(map[string]*pendingCopy)
+	}
+	c.pending[region][*snapshot.SnapshotId] = &pendingCopy{copyID: copyID, source: snapshot}
+	c.logger.Info("started snapshot copy",
+		logging.SnapshotID, copyID,
+		"source_snapshot_id", *snapshot.SnapshotId,
+		logging.VolumeID, *snapshot.VolumeId,
+		logging.Destination, region,
+		logging.Action, "copy_snapshot")
+
+	return true, nil
+}
+
+// RemoveCopy used to remove a snapshot copy from given region
+func (c *snapshotCopier) RemoveCopy(ctx context.Context, snapshot *ec2.Snapshot, region string) error {
+	client, err := c.destination(region)
+	if err != nil {
+		return err
+	}
+	return client.RemoveSnapshot(ctx, snapshot)
+}
+
+// unshare used to stop sharing the source snapshot of a finished copy with the vault account.
+// The key grant is kept while other shared snapshots are encrypted with the same key.
+// Returns false when the snapshot is still shared, so that it is retried on the next refresh.
+func (c *snapshotCopier) unshare(ctx context.Context, region, sourceID string, pending *pendingCopy) bool {
+	if c.source == nil {
+		return true
+	}
+
+	keepKeyGrant := false
+	for r, copies := range c.pending {
+		for id, other := range copies {
+			if (r != region || id != sourceID) && sameKey(other.source, pending.source) {
+				keepKeyGrant = true
+			}
+		}
+	}
+
+	if err := c.source.UnshareSnapshot(ctx, pending.source, c.accountID, keepKeyGrant); err != nil {
+		c.logger.Error("failed to stop sharing snapshot with vault account",
+			logging.SnapshotID, sourceID,
+			logging.Destination, region,
+			logging.Action, "unshare_snapshot",
+			logging.Error, err)
+		return false
+	}
+	return true
+}
+
+func sameKey(a, b *ec2.Snapshot) bool {
+	return aws.BoolValue(a.Encrypted) && aws.BoolValue(b.Encrypted) &&
+		aws.StringValue(a.KmsKeyId) == aws.StringValue(b.KmsKeyId)
+}
+
+func (c *snapshotCopier) destination(region string) (EBSClient, error) {
+	client, ok := c.destinations[region]
+	if !ok {
+		return nil, errors.Errorf("no client configured for %s region", region)
+	}
+	return client, nil
+}
+
+func findSnapshot(snapshots EC2Snapshots, snapshotID string) *ec2.Snapshot {
+	for _, snaps := range snapshots {
+		for _, snapshot := range snaps {
+			if *snapshot.SnapshotId == snapshotID {
+				return snapshot
+			}
+		}
+	}
+	return nil
+}
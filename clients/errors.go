@@ -0,0 +1,128 @@
+package clients
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+)
+
+// ErrorClass is the class of an error of an EBS operation, which decides how it is handled
+type ErrorClass string
+
+// Error classes of EBS operations
+const (
+	// ErrorClassThrottled is the class of requests rejected for exceeding AWS request limits
+	ErrorClassThrottled ErrorClass = "throttled"
+	// ErrorClassInUse is the class of snapshots that can't be removed as they back an AMI
+	ErrorClassInUse ErrorClass = "in_use"
+	// ErrorClassNotFound is the class of volumes and snapshots that don't exist, or no longer exist
+	ErrorClassNotFound ErrorClass = "not_found"
+	// ErrorClassPermission is the class of requests the credentials aren't allowed to make
+	ErrorClassPermission ErrorClass = "permission"
+	// ErrorClassUnknown is the class of any other error
+	ErrorClassUnknown ErrorClass = "unknown"
+)
+
+var (
+	// throttledCodes are the throttling error codes not already recognised by the AWS SDK
+	throttledCodes = map[string]bool{
+		"SnapshotCreationPerVolumeRateExceeded": true,
+	}
+	permissionCodes = map[string]bool{
+		"UnauthorizedOperation": true,
+		"AuthFailure":           true,
+		"AccessDenied":          true,
+		"AccessDeniedException": true,
+		"OptInRequired":         true,
+		"InvalidClientTokenId":  true,
+		"KMSAccessDeniedFault":  true,
+	}
+)
+
+// Error used to carry the class of an error of an EBS operation along with the error
+type Error struct {
+	Class  ErrorClass
+	Action string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return "error while " + e.Action + ": " + e.Err.Error()
+}
+
+// Cause used to obtain the underlying error, usually an `awserr.Error`
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// Unwrap used to obtain the underlying error, usually an `awserr.Error`
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorClassOf used to obtain the class of an error returned by an EBS client, possibly wrapped
+// since. Errors not returned by an EBS client are of the unknown class.
+func ErrorClassOf(err error) ErrorClass {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Class
+	}
+	return ErrorClassUnknown
+}
+
+// classify used to obtain the class of an error returned by the AWS SDK
+func classify(err error) ErrorClass {
+	aerr, ok := errors.Cause(err).(awserr.Error)
+	if !ok {
+		return ErrorClassUnknown
+	}
+	code := aerr.Code()
+	switch {
+	case request.IsErrorThrottle(aerr) || throttledCodes[code]:
+		return ErrorClassThrottled
+	case code == "InvalidSnapshot.InUse":
+		return ErrorClassInUse
+	case strings.HasSuffix(code, ".NotFound") || code == "NotFoundException":
+		return ErrorClassNotFound
+	case permissionCodes[code]:
+		return ErrorClassPermission
+	}
+	return ErrorClassUnknown
+}
+
+// Backoff used to configure how operations failing with an error class are retried. The wait
+// before a retry starts at Initial and doubles with every retry, up to Max when set.
+type Backoff struct {
+	Retries      int
+	Initial, Max time.Duration
+}
+
+// RetryPolicy used to map error classes to how their operations are retried. Operations failing
+// with an error of a class not in the policy aren't retried.
+type RetryPolicy map[ErrorClass]Backoff
+
+// DefaultRetryPolicy retries throttled operations, on top of the retries of the AWS SDK, as
+// their limits are usually exceeded for a while. Other errors aren't expected to go away.
+var DefaultRetryPolicy = RetryPolicy{
+	ErrorClassThrottled: {Retries: 4, Initial: time.Second, Max: 16 * time.Second},
+}
+
+// wait used to obtain how long to wait before given retry, counted from zero, of an operation that
+// failed with an error of given class. The second return value is false when it isn't retried.
+func (p RetryPolicy) wait(class ErrorClass, retry int) (time.Duration, bool) {
+	backoff, ok := p[class]
+	if !ok || retry >= backoff.Retries {
+		return 0, false
+	}
+	wait := backoff.Initial
+	for i := 0; i < retry; i++ {
+		wait *= 2
+		if backoff.Max > 0 && wait >= backoff.Max {
+			return backoff.Max, true
+		}
+	}
+	return wait, true
+}
@@ -0,0 +1,128 @@
+package clients
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type instrumentedEBSClient struct {
+	client  EBSClient
+	latency prometheus.ObserverVec
+	tracer  trace.Tracer
+}
+
+// NewInstrumentedEBSClient used to wrap an EBS client so that the duration of every call is
+// observed in given histogram, labelled by operation, and every call is traced in its own span
+func NewInstrumentedEBSClient(client EBSClient, latency prometheus.ObserverVec, tracer trace.Tracer) EBSClient {
+	return &instrumentedEBSClient{
+		client:  client,
+		latency: latency,
+		tracer:  tracer,
+	}
+}
+
+// observe used to start timing and tracing an operation, the returned function ends both
+func (c *instrumentedEBSClient) observe(
+	ctx context.Context,
+	operation string,
+	attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+
+	timer := prometheus.NewTimer(c.latency.WithLabelValues(operation))
+	ctx, span := c.tracer.Start(ctx, "ebs."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		timer.ObserveDuration()
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func (c *instrumentedEBSClient) GetVolumes(ctx context.Context) (EC2Volumes, error) {
+	ctx, end := c.observe(ctx, "get_volumes")
+	volumes, err := c.client.GetVolumes(ctx)
+	end(err)
+	return volumes, err
+}
+
+func (c *instrumentedEBSClient) GetSnapshots(ctx context.Context) (EC2Snapshots, error) {
+	ctx, end := c.observe(ctx, "get_snapshots")
+	snapshots, err := c.client.GetSnapshots(ctx)
+	end(err)
+	return snapshots, err
+}
+
+func (c *instrumentedEBSClient) CreateSnapshot(ctx context.Context, volume *ec2.Volume, tags []*ec2.Tag) (*ec2.Snapshot, error) {
+	ctx, end := c.observe(ctx, "create_snapshot", attribute.String("volume.id", *volume.VolumeId))
+	snapshot, err := c.client.CreateSnapshot(ctx, volume, tags)
+	end(err)
+	return snapshot, err
+}
+
+func (c *instrumentedEBSClient) RemoveSnapshot(ctx context.Context, snapshot *ec2.Snapshot) error {
+	ctx, end := c.observe(ctx, "remove_snapshot", attribute.String("snapshot.id", *snapshot.SnapshotId))
+	err := c.client.RemoveSnapshot(ctx, snapshot)
+	end(err)
+	return err
+}
+
+func (c *instrumentedEBSClient) CopySnapshot(ctx context.Context, snapshot *ec2.Snapshot, sourceRegion, kmsKeyID string) (string, error) {
+	ctx, end := c.observe(ctx, "copy_snapshot",
+		attribute.String("snapshot.id", *snapshot.SnapshotId),
+		attribute.String("source_region", sourceRegion))
+	copyID, err := c.client.CopySnapshot(ctx, snapshot, sourceRegion, kmsKeyID)
+	end(err)
+	return copyID, err
+}
+
+func (c *instrumentedEBSClient) GetSnapshotCopies(ctx context.Context) (EC2Snapshots, error) {
+	ctx, end := c.observe(ctx, "get_snapshot_copies")
+	copies, err := c.client.GetSnapshotCopies(ctx)
+	end(err)
+	return copies, err
+}
+
+func (c *instrumentedEBSClient) ShareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string) error {
+	ctx, end := c.observe(ctx, "share_snapshot", attribute.String("snapshot.id", *snapshot.SnapshotId))
+	err := c.client.ShareSnapshot(ctx, snapshot, accountID)
+	end(err)
+	return err
+}
+
+func (c *instrumentedEBSClient) UnshareSnapshot(
+	ctx context.Context, snapshot *ec2.Snapshot, accountID string, keepKeyGrant bool) error {
+
+	ctx, end := c.observe(ctx, "unshare_snapshot", attribute.String("snapshot.id", *snapshot.SnapshotId))
+	err := c.client.UnshareSnapshot(ctx, snapshot, accountID, keepKeyGrant)
+	end(err)
+	return err
+}
+
+func (c *instrumentedEBSClient) CreateVolume(ctx context.Context, snapshot *ec2.Snapshot, options *VolumeOptions) (*ec2.Volume, error) {
+	ctx, end := c.observe(ctx, "create_volume", attribute.String("snapshot.id", *snapshot.SnapshotId))
+	volume, err := c.client.CreateVolume(ctx, snapshot, options)
+	end(err)
+	return volume, err
+}
+
+func (c *instrumentedEBSClient) TagSnapshot(ctx context.Context, snapshotID string, tags []*ec2.Tag) error {
+	ctx, end := c.observe(ctx, "tag_snapshot", attribute.String("snapshot.id", snapshotID))
+	err := c.client.TagSnapshot(ctx, snapshotID, tags)
+	end(err)
+	return err
+}
+
+func (c *instrumentedEBSClient) UntagSnapshot(ctx context.Context, snapshotID string, keys []string) error {
+	ctx, end := c.observe(ctx, "untag_snapshot", attribute.String("snapshot.id", snapshotID))
+	err := c.client.UntagSnapshot(ctx, snapshotID, keys)
+	end(err)
+	return err
+}
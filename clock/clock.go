@@ -0,0 +1,63 @@
+// Package clock provides the time ebs-snapshotter decides on, so that policies can be run
+// against a simulated time.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock interface specifies functions used to tell the time and to wait
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+// Real used to obtain the system clock
+func Real() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Simulated used to provide a time that only moves when advanced. Waits don't block, they
+// advance the time by the duration waited instead.
+type Simulated struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewSimulated used to create a new simulated clock starting at given time
+func NewSimulated(start time.Time) *Simulated {
+	return &Simulated{now: start}
+}
+
+// Now used to obtain the simulated time
+func (c *Simulated) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After used to advance the simulated time by given duration. The returned channel already holds the new time.
+func (c *Simulated) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.Advance(d)
+	return ch
+}
+
+// Advance used to move the simulated time forward by given duration. Returns the new time.
+func (c *Simulated) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
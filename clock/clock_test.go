@@ -0,0 +1,25 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/clock"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&ClockSuite{})
+
+type ClockSuite struct{}
+
+func TestClock(t *testing.T) { TestingT(t) }
+
+func (s *ClockSuite) TestSimulatedTimeOnlyMovesWhenAdvanced(c *C) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewSimulated(start)
+
+	c.Assert(clk.Now(), Equals, start)
+	c.Assert(clk.Advance(time.Hour), Equals, start.Add(time.Hour))
+	c.Assert(<-clk.After(2*time.Second), Equals, start.Add(time.Hour+2*time.Second))
+	c.Assert(clk.Now(), Equals, start.Add(time.Hour+2*time.Second))
+}
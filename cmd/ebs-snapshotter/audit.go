@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/audit"
+)
+
+// auditOptions holds the settings of the audit sink
+type auditOptions struct {
+	sink             string
+	file             string
+	bucket, prefix   string
+	endpoint, region string
+	forcePathStyle   bool
+}
+
+// newAuditSink used to create the audit sink chosen by AUDIT_SINK, or nil when auditing is disabled
+func newAuditSink(sess *session.Session, options *auditOptions) (audit.Sink, error) {
+	var sink audit.Sink
+	switch options.sink {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		sink = audit.NewWriterSink(os.Stdout)
+	case "file":
+		if options.file == "" {
+			return nil, errors.New("AUDIT_FILE must be set for the file audit sink")
+		}
+		fileSink, err := audit.NewFileSink(options.file)
+		if err != nil {
+			return nil, err
+		}
+		sink = fileSink
+	case "s3":
+		if options.bucket == "" {
+			return nil, errors.New("AUDIT_S3_BUCKET must be set for the s3 audit sink")
+		}
+		// An endpoint allows S3 compatible stores, which mostly require path style bucket addressing
+		config := aws.NewConfig()
+		if options.region != "" {
+			config = config.WithRegion(options.region)
+		}
+		if options.endpoint != "" {
+			config = config.WithEndpoint(options.endpoint).WithS3ForcePathStyle(options.forcePathStyle)
+		}
+		sink = audit.NewS3Sink(s3.New(sess, config), options.bucket, options.prefix)
+	default:
+		return nil, errors.Errorf("unknown audit sink %s", options.sink)
+	}
+	return audit.WithVersion(sink, gitHash), nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/utilitywarehouse/ebs-snapshotter/election"
+	"k8s.io/client-go/kubernetes"
+)
+
+// electionOptions holds the settings of leader election
+type electionOptions struct {
+	elector         string
+	namespace, name string
+	identity        string
+	lockFile        string
+}
+
+// newElector used to create the elector chosen by LEADER_ELECTION, or nil when every replica leads.
+// The leadership metrics are registered with given registry either way, so that a replica without
+// election reports itself as leader.
+func newElector(
+	kubeClient kubernetes.Interface,
+	options *electionOptions,
+	registry prometheus.Registerer,
+	logger *slog.Logger) (election.Elector, error) {
+
+	identity := options.identity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, errors.Wrap(err, "error while obtaining the hostname as identity")
+		}
+		identity = hostname
+	}
+
+	metrics := election.NewMetrics(registry)
+	switch options.elector {
+	case "", "none":
+		metrics.Leader.Set(1)
+		return nil, nil
+	case "lease":
+		if kubeClient == nil {
+			return nil, errors.New("lease leader election requires running in a Kubernetes cluster")
+		}
+		return election.NewLeaseElector(
+			kubeClient,
+			options.namespace,
+			options.name,
+			identity,
+			metrics,
+			logger)
+	case "file":
+		return election.NewFileElector(
+			options.lockFile,
+			identity,
+			2*time.Second,
+			metrics,
+			logger), nil
+	default:
+		return nil, errors.Errorf("unknown leader election %s", options.elector)
+	}
+}
+
+// waitForCycle used to wait for the next cycle, which starts early when this replica starts or stops
+// leading, so that a new leader doesn't wait for a whole interval after taking over and a former
+// leader stops reporting its volumes straight away
+func waitForCycle(interval time.Duration, elector election.Elector) {
+	next := time.After(interval)
+	if elector == nil {
+		<-next
+		return
+	}
+
+	_, wasLeading := elector.Leading()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-next:
+			return
+		case <-ticker.C:
+			if _, leading := elector.Leading(); leading != wasLeading {
+				return
+			}
+		}
+	}
+}
+
+// leaderContext used to obtain a context that is done when the parent is, or when this replica stops
+// leading. The returned function must be called once the context is no longer used.
+func leaderContext(parent context.Context, elector election.Elector) (context.Context, bool, context.CancelFunc) {
+	if elector == nil {
+		return parent, true, func() {}
+	}
+	leading, ok := elector.Leading()
+	if !ok {
+		return nil, false, func() {}
+	}
+	ctx, cancel := context.WithCancel(parent)
+	stop := context.AfterFunc(leading, cancel)
+	return ctx, true, func() {
+		stop()
+		cancel()
+	}
+}
+
+// runWhileLeading used to run given function for as long as this replica leads, again whenever it
+// starts leading. The function is expected to return once its context is done.
+func runWhileLeading(run func(ctx context.Context), elector election.Elector) {
+	for {
+		ctx, leading, stop := leaderContext(context.Background(), elector)
+		if !leading {
+			<-time.After(time.Second)
+			continue
+		}
+		run(ctx)
+		stop()
+	}
+}
+
+// forgetVolumes used to stop reporting the volumes of every target, once this replica no longer leads
+func forgetVolumes(watchers []*targetWatcher) {
+	for _, tw := range watchers {
+		tw.watcher.ForgetVolumes()
+	}
+}
+
+// leaderOnly used to serve given handler only while this replica leads, other replicas respond with
+// 503 Service Unavailable so that the request is retried, reaching the leader
+func leaderOnly(handler http.Handler, elector election.Elector) http.Handler {
+	if elector == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if _, leading := elector.Leading(); !leading {
+			http.Error(rw, "not leading", http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&ElectionSuite{})
+
+type ElectionSuite struct{}
+
+// fakeElector used to lead whenever told to, until told to stop
+type fakeElector struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (e *fakeElector) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (e *fakeElector) Leading() (context.Context, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.ctx, e.ctx != nil
+}
+
+func (e *fakeElector) start() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+}
+
+func (e *fakeElector) stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cancel()
+	e.ctx = nil
+}
+
+func (s *ElectionSuite) TestEveryReplicaLeadsWithoutElection(c *C) {
+	parent, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctx, leading, stop := leaderContext(parent, nil)
+	defer stop()
+
+	c.Assert(leading, Equals, true)
+	c.Assert(ctx, Equals, parent)
+}
+
+func (s *ElectionSuite) TestFollowerDoesNotObtainContext(c *C) {
+	ctx, leading, stop := leaderContext(context.Background(), &fakeElector{})
+	defer stop()
+
+	c.Assert(leading, Equals, false)
+	c.Assert(ctx, IsNil)
+}
+
+func (s *ElectionSuite) TestLeaderContextDoneWhenLeadershipLost(c *C) {
+	elector := &fakeElector{}
+	elector.start()
+
+	ctx, leading, stop := leaderContext(context.Background(), elector)
+	defer stop()
+	c.Assert(leading, Equals, true)
+	c.Assert(ctx.Err(), IsNil)
+
+	elector.stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		c.Fatal("context not done after leadership was lost")
+	}
+}
+
+func (s *ElectionSuite) TestLeaderContextDoneWhenParentDone(c *C) {
+	elector := &fakeElector{}
+	elector.start()
+	parent, cancel := context.WithCancel(context.Background())
+
+	ctx, _, stop := leaderContext(parent, elector)
+	defer stop()
+	cancel()
+
+	c.Assert(ctx.Err(), Equals, context.Canceled)
+	_, leading := elector.Leading()
+	c.Assert(leading, Equals, true)
+}
+
+func (s *ElectionSuite) TestCycleStartsEarlyWhenLeadershipStarts(c *C) {
+	elector := &fakeElector{}
+	done := make(chan struct{})
+	go func() {
+		waitForCycle(time.Hour, elector)
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	elector.start()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("cycle not started after leadership started")
+	}
+}
+
+func (s *ElectionSuite) TestCycleStartsEarlyWhenLeadershipLost(c *C) {
+	elector := &fakeElector{}
+	elector.start()
+	done := make(chan struct{})
+	go func() {
+		waitForCycle(time.Hour, elector)
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	elector.stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("cycle not started after leadership was lost")
+	}
+}
+
+func (s *ElectionSuite) TestRequestsServedOnlyWhileLeading(c *C) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+	})
+	elector := &fakeElector{}
+	serve := func(handler http.Handler) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/volumes/vol-1/snapshots", nil))
+		return rec.Code
+	}
+
+	c.Assert(serve(leaderOnly(handler, elector)), Equals, http.StatusServiceUnavailable)
+	elector.start()
+	c.Assert(serve(leaderOnly(handler, elector)), Equals, http.StatusCreated)
+	elector.stop()
+	c.Assert(serve(leaderOnly(handler, elector)), Equals, http.StatusServiceUnavailable)
+	c.Assert(serve(leaderOnly(handler, nil)), Equals, http.StatusCreated)
+}
+
+func (s *ElectionSuite) TestReplicaWithoutElectionReportedAsLeader(c *C) {
+	registry := prometheus.NewRegistry()
+
+	elector, err := newElector(nil, &electionOptions{elector: "none", identity: "replica-1"}, registry, discardLogger)
+
+	c.Assert(err, IsNil)
+	c.Assert(elector, IsNil)
+	c.Assert(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP ebs_snapshotter_leader Whether this replica is the leader, which creates and removes snapshots
+# TYPE ebs_snapshotter_leader gauge
+ebs_snapshotter_leader 1
+`), "ebs_snapshotter_leader"), IsNil)
+}
+
+func (s *ElectionSuite) TestReplicaWithElectionNotLeaderUntilElected(c *C) {
+	registry := prometheus.NewRegistry()
+
+	elector, err := newElector(nil, &electionOptions{
+		elector:  "file",
+		identity: "replica-1",
+		lockFile: c.MkDir() + "/ebs-snapshotter.lock",
+	}, registry, discardLogger)
+
+	c.Assert(err, IsNil)
+	c.Assert(elector, NotNil)
+	c.Assert(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP ebs_snapshotter_leader Whether this replica is the leader, which creates and removes snapshots
+# TYPE ebs_snapshotter_leader gauge
+ebs_snapshotter_leader 0
+`), "ebs_snapshotter_leader"), IsNil)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/ebsevents"
+)
+
+// reconcileEvent used to create a handler reconciling the volume an EBS event is about, with the
+// watcher of the region and account the event happened in. Events of other targets are ignored.
+func reconcileEvent(watchers []*targetWatcher) ebsevents.Handler {
+	return func(ctx context.Context, event *ebsevents.Event) error {
+		if !event.Reconcile() {
+			return nil
+		}
+		for _, tw := range watchers {
+			if tw.region == event.Region && tw.account == event.Account {
+				return tw.watcher.ReconcileVolume(ctx, event.VolumeID)
+			}
+		}
+		return nil
+	}
+}
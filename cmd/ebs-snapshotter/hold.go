@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+)
+
+// runHold used to set or clear the hold of a snapshot, which keeps it from being removed by retention
+func runHold(args []string) {
+	flags := flag.NewFlagSet("hold", flag.ExitOnError)
+	var (
+		region     = flags.String("region", "", "region of the snapshot, defaults to the session region")
+		roleARN    = flags.String("role-arn", "", "role to assume in the account of the snapshot")
+		snapshotID = flags.String("snapshot-id", "", "ID of the snapshot to hold")
+		until      = flags.String("until", "", "only hold the snapshot until this RFC3339 time")
+		clear      = flags.Bool("clear", false, "clear the hold, so that retention removes the snapshot again")
+	)
+	flags.Parse(args)
+
+	if *snapshotID == "" {
+		log.Fatalf("-snapshot-id is required")
+	}
+
+	tags, stale, err := holdTags(*until)
+	if err != nil {
+		log.Fatalf("-until must be an RFC3339 time, got %v", *until)
+	}
+
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		log.Fatalf("Error while creating AWS session: %v", err)
+	}
+	if *region == "" {
+		*region = aws.StringValue(sess.Config.Region)
+	}
+	config := targetConfig(sess, &models.Target{Region: *region, RoleARN: *roleARN})
+	client := clients.NewEBSClient(ec2.New(sess, config), nil)
+	ctx := context.Background()
+
+	if *clear {
+		if err := client.UntagSnapshot(ctx, *snapshotID, []string{clients.HoldTag, clients.RetainUntilTag}); err != nil {
+			log.Fatalf("Error while clearing hold: %v", err)
+		}
+		log.Printf("Cleared hold of snapshot %s", *snapshotID)
+		return
+	}
+
+	if err := client.UntagSnapshot(ctx, *snapshotID, []string{stale}); err != nil {
+		log.Fatalf("Error while setting hold: %v", err)
+	}
+	if err := client.TagSnapshot(ctx, *snapshotID, tags); err != nil {
+		log.Fatalf("Error while setting hold: %v", err)
+	}
+	log.Printf("Held snapshot %s", *snapshotID)
+}
+
+// holdTags used to obtain the tags holding a snapshot, indefinitely or until given RFC3339 time when
+// set, and the tag of the other kind of hold. A hold replaces a previous one, so that a snapshot is
+// either held indefinitely or until a time.
+func holdTags(until string) ([]*ec2.Tag, string, error) {
+	if until == "" {
+		return []*ec2.Tag{{Key: aws.String(clients.HoldTag), Value: aws.String("true")}}, clients.RetainUntilTag, nil
+	}
+	retainUntil, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		return nil, "", err
+	}
+	return []*ec2.Tag{{
+		Key:   aws.String(clients.RetainUntilTag),
+		Value: aws.String(retainUntil.UTC().Format(time.RFC3339)),
+	}}, clients.HoldTag, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&HoldSuite{})
+
+type HoldSuite struct{}
+
+func (s *HoldSuite) TestHoldIndefinitelyReplacesRetainUntil(c *C) {
+	tags, stale, err := holdTags("")
+
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, []*ec2.Tag{{Key: aws.String(clients.HoldTag), Value: aws.String("true")}})
+	c.Assert(stale, Equals, clients.RetainUntilTag)
+}
+
+func (s *HoldSuite) TestHoldUntilTimeReplacesHold(c *C) {
+	tags, stale, err := holdTags("2030-01-02T03:04:05+01:00")
+
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, []*ec2.Tag{{
+		Key:   aws.String(clients.RetainUntilTag),
+		Value: aws.String("2030-01-02T02:04:05Z"),
+	}})
+	c.Assert(stale, Equals, clients.HoldTag)
+}
+
+func (s *HoldSuite) TestHoldUntilInvalidTimeFails(c *C) {
+	_, _, err := holdTags("tomorrow")
+
+	c.Assert(err, NotNil)
+}
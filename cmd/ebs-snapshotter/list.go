@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/kube"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	w "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// listEntry holds a catalog entry and the region it was found in
+type listEntry struct {
+	Region string `json:"region"`
+	w.CatalogEntry
+}
+
+// runList used to print the snapshot catalog of every volume matched by the configured policies
+func runList(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	var (
+		configFile  = flags.String("config", getEnv("VOLUME_SNAPSHOT_CONFIG_FILE", ""), "volume snapshot config file")
+		targetsFile = flags.String("targets", getEnv("TARGETS_CONFIG_FILE", ""), "targets config file")
+		output      = flags.String("output", "table", "output format, one of table, json or csv")
+		namespace   = flags.String("namespace", "", "only list volumes of PVCs in this namespace")
+		pvcName     = flags.String("pvc", "", "only list the volume of this PVC")
+		policy      = flags.String("policy", "", "only list volumes handled by this policy")
+		discovery   = flags.Bool("kube-policy-discovery", getEnv("KUBE_POLICY_DISCOVERY", "false") == "true",
+			"include policies of annotated PVCs")
+		policies = flags.Bool("kube-snapshot-policies", getEnv("KUBE_SNAPSHOT_POLICIES", "false") == "true",
+			"include policies of SnapshotPolicy resources")
+	)
+	flags.Parse(args)
+
+	if *output != "table" && *output != "json" && *output != "csv" {
+		log.Fatalf("-output must be one of table, json or csv, got %v", *output)
+	}
+
+	// The config file is optional when policies are obtained from Kubernetes, as it is for the service
+	fileConfigs := &models.VolumeSnapshotConfigs{}
+	if *configFile != "" || (!*discovery && !*policies) {
+		fileConfigs = loadVolumeSnapshotConfig(*configFile)
+	}
+	discoverers := make([]kube.PolicyDiscoverer, 0)
+	if *discovery || *policies {
+		kubeConfig, err := loadKubeconfig()
+		if err != nil {
+			log.Fatalf("Error while loading Kubernetes config: %v", err)
+		}
+		kubeClient, err := kubernetes.NewForConfig(kubeConfig)
+		if err != nil {
+			log.Fatalf("Error while creating Kubernetes client: %v", err)
+		}
+		dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+		if err != nil {
+			log.Fatalf("Error while creating Kubernetes dynamic client: %v", err)
+		}
+		discoverers, _ = newPolicyDiscoverers(kubeClient, dynamicClient, *discovery, *policies, slog.Default())
+	}
+	snapshotConfigs, err := discoverPolicies(fileConfigs, discoverers)
+	if err != nil {
+		log.Fatalf("Error while discovering snapshot policies: %v", err)
+	}
+
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		log.Fatalf("Error while creating AWS session: %v", err)
+	}
+
+	targets, err := loadTargets(*targetsFile, aws.StringValue(sess.Config.Region))
+	if err != nil {
+		log.Fatalf("Error while loading targets: %v", err)
+	}
+
+	now := time.Now()
+	entries := make([]listEntry, 0)
+	for _, target := range targets {
+		ebsClient := clients.NewEBSClient(ec2.New(sess, targetConfig(sess, target)), nil)
+		volumes, err := ebsClient.GetVolumes(context.Background())
+		if err != nil {
+			log.Fatalf("Error while fetching volumes in %s: %v", target.Region, err)
+		}
+		snapshots, err := ebsClient.GetSnapshots(context.Background())
+		if err != nil {
+			log.Fatalf("Error while fetching snapshots in %s: %v", target.Region, err)
+		}
+
+		for _, entry := range w.Catalog(snapshotConfigs, volumes, snapshots, now) {
+			if (*namespace != "" && entry.PVCNamespace != *namespace) ||
+				(*pvcName != "" && entry.PVCName != *pvcName) ||
+				(*policy != "" && entry.Policy != *policy) {
+				continue
+			}
+			entries = append(entries, listEntry{Region: target.Region, CatalogEntry: entry})
+		}
+	}
+
+	switch *output {
+	case "json":
+		err = writeListJSON(os.Stdout, entries)
+	case "csv":
+		err = writeListCSV(os.Stdout, entries, now)
+	default:
+		err = writeListTable(os.Stdout, entries, now)
+	}
+	if err != nil {
+		log.Fatalf("Error while writing the snapshot catalog: %v", err)
+	}
+}
+
+func writeListJSON(out io.Writer, entries []listEntry) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+func writeListCSV(out io.Writer, entries []listEntry, now time.Time) error {
+	writer := csv.NewWriter(out)
+	writer.Write([]string{
+		"region", "namespace", "pvc", "volume_id", "policy", "snapshots", "total_size_gib",
+		"latest_snapshot", "latest_snapshot_age_seconds", "next_due"})
+	for _, entry := range entries {
+		latest, age := "", ""
+		if entry.LatestSnapshotTime != nil {
+			latest = entry.LatestSnapshotTime.Format(time.RFC3339)
+			age = strconv.FormatInt(int64(now.Sub(*entry.LatestSnapshotTime).Seconds()), 10)
+		}
+		writer.Write([]string{
+			entry.Region,
+			entry.PVCNamespace,
+			entry.PVCName,
+			entry.VolumeID,
+			entry.Policy,
+			strconv.Itoa(entry.SnapshotCount),
+			strconv.FormatInt(entry.TotalSizeGiB, 10),
+			latest,
+			age,
+			entry.NextDue.Format(time.RFC3339),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeListTable(out io.Writer, entries []listEntry, now time.Time) error {
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "REGION\tNAMESPACE\tPVC\tVOLUME\tPOLICY\tSNAPSHOTS\tSIZE\tLATEST AGE\tNEXT DUE")
+	for _, entry := range entries {
+		age := "-"
+		if entry.LatestSnapshotTime != nil {
+			age = now.Sub(*entry.LatestSnapshotTime).Truncate(time.Minute).String()
+		}
+		due := "now"
+		if entry.NextDue.After(now) {
+			due = "in " + entry.NextDue.Sub(now).Truncate(time.Minute).String()
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\t%dGiB\t%s\t%s\n",
+			entry.Region,
+			orDash(entry.PVCNamespace),
+			orDash(entry.PVCName),
+			entry.VolumeID,
+			entry.Policy,
+			entry.SnapshotCount,
+			entry.TotalSizeGiB,
+			age,
+			due)
+	}
+	return writer.Flush()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
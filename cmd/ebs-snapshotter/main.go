@@ -1,34 +1,52 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/api"
+	"github.com/utilitywarehouse/ebs-snapshotter/controller"
+	"github.com/utilitywarehouse/ebs-snapshotter/ebsevents"
+	"github.com/utilitywarehouse/ebs-snapshotter/hooks"
+	"github.com/utilitywarehouse/ebs-snapshotter/kube"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
 	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"github.com/utilitywarehouse/ebs-snapshotter/notify"
+	"github.com/utilitywarehouse/ebs-snapshotter/status"
+	"github.com/utilitywarehouse/ebs-snapshotter/tracing"
 	w "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
 	name        = "ebs-snapshotter"
 	description = `Snapshots EBS volumes automatically`
+
+	// tracingShutdownTimeout is how long exiting waits for the remaining spans to be exported
+	tracingShutdownTimeout = 5 * time.Second
 )
 
 var (
-	gitHash                           string
-	crCounter, delCounter, errCounter *prometheus.CounterVec
-	snapshotCounter                   *prometheus.GaugeVec
+	gitHash string
 )
 
 func getEnv(key, fallback string) string {
@@ -39,59 +57,398 @@ func getEnv(key, fallback string) string {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "list":
+			runList(os.Args[2:])
+			return
+		case "hold":
+			runHold(os.Args[2:])
+			return
+		case "simulate":
+			runSimulate(os.Args[2:])
+			return
+		}
+	}
+
 	var (
 		httpPort                 = getEnv("HTTP_PORT", "8080")
 		volumeSnapshotConfigFile = getEnv("VOLUME_SNAPSHOT_CONFIG_FILE", "")
+		targetsConfigFile        = getEnv("TARGETS_CONFIG_FILE", "")
 		pollIntervalSeconds      = getEnv("POLL_INTERVAL_SECONDS", "1800")
+		vaultRoleARN             = getEnv("VAULT_ROLE_ARN", "")
+		vaultRegion              = getEnv("VAULT_REGION", "")
+		kubePolicyDiscovery      = getEnv("KUBE_POLICY_DISCOVERY", "false")
+		kubeSnapshotPolicies     = getEnv("KUBE_SNAPSHOT_POLICIES", "false")
+		csiSnapshots             = getEnv("CSI_SNAPSHOTS", "false")
+		csiSnapshotClass         = getEnv("CSI_SNAPSHOT_CLASS", "")
+		apiToken                 = getEnv("API_TOKEN", "")
+		logLevel                 = getEnv("LOG_LEVEL", "info")
+		tracingExporter          = getEnv("TRACING_EXPORTER", tracing.ExporterNone)
+		notifyKubeEvents         = getEnv("NOTIFY_KUBE_EVENTS", "false")
+		notifyWebhookURL         = getEnv("NOTIFY_WEBHOOK_URL", "")
+		notifySlackWebhookURL    = getEnv("NOTIFY_SLACK_WEBHOOK_URL", "")
+		notifyEvents             = getEnv("NOTIFY_EVENTS", "snapshot_failed,rpo_violated")
+		notifyDedupWindowSeconds = getEnv("NOTIFY_DEDUP_WINDOW_SECONDS", "3600")
+		notifyMaxPerHour         = getEnv("NOTIFY_MAX_PER_HOUR", "60")
+		auditSink                = getEnv("AUDIT_SINK", "none")
+		auditFile                = getEnv("AUDIT_FILE", "")
+		auditS3Bucket            = getEnv("AUDIT_S3_BUCKET", "")
+		auditS3Prefix            = getEnv("AUDIT_S3_PREFIX", "audit")
+		auditS3Endpoint          = getEnv("AUDIT_S3_ENDPOINT", "")
+		auditS3Region            = getEnv("AUDIT_S3_REGION", "")
+		auditS3PathStyle         = getEnv("AUDIT_S3_FORCE_PATH_STYLE", "true")
+		stateStoreKind           = getEnv("STATE_STORE", "none")
+		stateFile                = getEnv("STATE_FILE", "ebs-snapshotter.db")
+		stateNamespace           = getEnv("STATE_NAMESPACE", getEnv("POD_NAMESPACE", "default"))
+		stateConfigMap           = getEnv("STATE_CONFIGMAP", "ebs-snapshotter-state")
+		leaderElection           = getEnv("LEADER_ELECTION", "none")
+		leaderElectionNamespace  = getEnv("LEADER_ELECTION_NAMESPACE", getEnv("POD_NAMESPACE", "default"))
+		leaderElectionName       = getEnv("LEADER_ELECTION_NAME", name)
+		leaderElectionIdentity   = getEnv("LEADER_ELECTION_IDENTITY", getEnv("POD_NAME", ""))
+		leaderElectionLockFile   = getEnv("LEADER_ELECTION_LOCK_FILE", "/tmp/ebs-snapshotter.lock")
+		sqsQueueURL              = getEnv("SQS_QUEUE_URL", "")
+		sqsEndpoint              = getEnv("SQS_ENDPOINT", "")
+		sqsRegion                = getEnv("SQS_REGION", "")
 	)
 
-	crCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "snapshots_performed",
-		Help: "A counter of the total number of snapshots created",
-	}, []string{"pvc_name", "pvc_namespace", "volume_id"})
-	delCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "old_snapshots_removed",
-		Help: "A counter of the total number of old snapshots removed",
-	}, []string{"pvc_name", "pvc_namespace", "volume_id"})
-	errCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "errors_total",
-		Help: "A counter of the total number of errors encountered",
-	}, []string{"pvc_name", "pvc_namespace", "volume_id"})
-	snapshotCounter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "snapshots_total",
-		Help: "A counter of the total number of snapshots",
-	}, []string{"pvc_name", "pvc_namespace", "volume_id"})
-
-	prometheus.DefaultRegisterer.MustRegister(crCounter, delCounter, errCounter, snapshotCounter)
-
-	snapshotConfigs := loadVolumeSnapshotConfig(volumeSnapshotConfigFile)
+	logger, err := logging.New(os.Stdout, logLevel)
+	if err != nil {
+		log.Fatalf("LOG_LEVEL must be one of debug, info, warn or error, got %v", logLevel)
+	}
+	// Anything still logged through the standard logger is written as a JSON record at info level
+	slog.SetDefault(logger)
 
-	sess, err := session.NewSession(&aws.Config{})
-	ec2Client := ec2.New(sess)
-	ebsClient := clients.NewEBSClient(ec2Client)
+	// Spans are written to stderr, so that they aren't mixed with the logs and audit records on stdout
+	tracerProvider, shutdownTracing, err := tracing.Setup(context.Background(), tracingExporter, name, gitHash, os.Stderr)
+	if err != nil {
+		log.Fatalf("Error while setting up tracing: %v", err)
+	}
+	// flushTraces used to export the remaining spans before exiting, as exiting skips deferred calls
+	flushTraces := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("failed to flush traces", logging.Error, err)
+		}
+	}
+	defer flushTraces()
+	tracer := tracerProvider.Tracer("github.com/utilitywarehouse/ebs-snapshotter")
 
-	watcher := w.NewEBSSnapshotWatcher(ebsClient, crCounter, delCounter, errCounter, snapshotCounter)
+	metrics := w.NewMetrics(prometheus.DefaultRegisterer, "region", "account")
 
 	httpPortInt, err := strconv.Atoi(httpPort)
 	if err != nil {
 		log.Fatalf("httpPort must be convertible to Int, got %v", httpPort)
 	}
+
+	// The server is started first so that liveness probes pass while the targets are set up
+	tracker := status.NewTracker(logger)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	tracker.Register(mux)
+	go func() {
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", httpPortInt), mux); err != nil {
+			flushTraces()
+			log.Fatalf("Error while serving HTTP: %v", err)
+		}
+	}()
+	logger.Info("listening", "port", httpPortInt)
+
+	discoveryEnabled, err := strconv.ParseBool(kubePolicyDiscovery)
+	if err != nil {
+		log.Fatalf("KUBE_POLICY_DISCOVERY must be a boolean, got %v", kubePolicyDiscovery)
+	}
+	policiesEnabled, err := strconv.ParseBool(kubeSnapshotPolicies)
+	if err != nil {
+		log.Fatalf("KUBE_SNAPSHOT_POLICIES must be a boolean, got %v", kubeSnapshotPolicies)
+	}
+	csiEnabled, err := strconv.ParseBool(csiSnapshots)
+	if err != nil {
+		log.Fatalf("CSI_SNAPSHOTS must be a boolean, got %v", csiSnapshots)
+	}
+	kubeEventsEnabled, err := strconv.ParseBool(notifyKubeEvents)
+	if err != nil {
+		log.Fatalf("NOTIFY_KUBE_EVENTS must be a boolean, got %v", notifyKubeEvents)
+	}
+	webhookEvents, err := notify.ParseEventTypes(notifyEvents)
+	if err != nil {
+		log.Fatalf("NOTIFY_EVENTS must be a list of event types: %v", err)
+	}
+	dedupWindowSeconds, err := strconv.Atoi(notifyDedupWindowSeconds)
+	if err != nil {
+		log.Fatalf("NOTIFY_DEDUP_WINDOW_SECONDS must be convertible to Int, got %v", notifyDedupWindowSeconds)
+	}
+	maxPerHour, err := strconv.Atoi(notifyMaxPerHour)
+	if err != nil {
+		log.Fatalf("NOTIFY_MAX_PER_HOUR must be convertible to Int, got %v", notifyMaxPerHour)
+	}
+	pathStyle, err := strconv.ParseBool(auditS3PathStyle)
+	if err != nil {
+		log.Fatalf("AUDIT_S3_FORCE_PATH_STYLE must be a boolean, got %v", auditS3PathStyle)
+	}
+
+	// The config file is optional when policies are obtained from Kubernetes
+	fileConfigs := &models.VolumeSnapshotConfigs{}
+	if volumeSnapshotConfigFile != "" || (!discoveryEnabled && !policiesEnabled) {
+		fileConfigs = loadVolumeSnapshotConfig(volumeSnapshotConfigFile)
+	}
+	tracker.ConfigLoaded()
+
+	kubeClient, kubeConfig := newKubeClient()
+	if (discoveryEnabled || policiesEnabled || csiEnabled || kubeEventsEnabled) && kubeClient == nil {
+		log.Fatalf("KUBE_POLICY_DISCOVERY, KUBE_SNAPSHOT_POLICIES, CSI_SNAPSHOTS and NOTIFY_KUBE_EVENTS " +
+			"require running in a Kubernetes cluster")
+	}
+
+	var dynamicClient dynamic.Interface
+	if policiesEnabled || csiEnabled {
+		dynamicClient, err = dynamic.NewForConfig(kubeConfig)
+		if err != nil {
+			log.Fatalf("Error while creating Kubernetes dynamic client: %v", err)
+		}
+	}
+
+	discoverers, policyController := newPolicyDiscoverers(kubeClient, dynamicClient, discoveryEnabled, policiesEnabled, logger)
+
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		log.Fatalf("Error while creating AWS session: %v", err)
+	}
+
+	var podExecutor hooks.PodExecutor
+	if kubeClient != nil {
+		podExecutor = hooks.NewKubeExecutor(kubeClient, kubeConfig)
+	}
+	options := &targetOptions{
+		clients:        newClientFactory(sess),
+		metrics:        metrics,
+		logger:         logger,
+		tracerProvider: tracerProvider,
+		hookRunner:     hooks.NewRunner(&http.Client{}, podExecutor),
+		vaultRoleARN:   vaultRoleARN,
+		vaultRegion:    vaultRegion,
+	}
+	if csiEnabled {
+		options.registrar = kube.NewCSISnapshotRegistrar(dynamicClient, csiSnapshotClass)
+	}
+
+	// Each notifier is limited on its own, so that Kubernetes Events don't use up the webhooks' budget
+	dedupWindow := time.Duration(dedupWindowSeconds) * time.Second
+	notifiers := make([]notify.Notifier, 0)
+	if kubeEventsEnabled {
+		notifiers = append(notifiers, notify.NewLimitedNotifier(notify.NewKubeEventNotifier(kubeClient), dedupWindow, maxPerHour))
+	}
+	webhookClient := &http.Client{Timeout: 10 * time.Second}
+	if notifyWebhookURL != "" {
+		notifiers = append(notifiers, notify.NewFilter(
+			notify.NewLimitedNotifier(notify.NewWebhookNotifier(webhookClient, notifyWebhookURL), dedupWindow, maxPerHour),
+			webhookEvents...))
+	}
+	if notifySlackWebhookURL != "" {
+		notifiers = append(notifiers, notify.NewFilter(
+			notify.NewLimitedNotifier(notify.NewSlackNotifier(webhookClient, notifySlackWebhookURL), dedupWindow, maxPerHour),
+			webhookEvents...))
+	}
+	if len(notifiers) > 0 {
+		options.notifier = notify.NewMulti(notifiers...)
+	}
+
+	options.auditSink, err = newAuditSink(sess, &auditOptions{
+		sink:           auditSink,
+		file:           auditFile,
+		bucket:         auditS3Bucket,
+		prefix:         auditS3Prefix,
+		endpoint:       auditS3Endpoint,
+		region:         auditS3Region,
+		forcePathStyle: pathStyle,
+	})
+	if err != nil {
+		log.Fatalf("Error while setting up the audit sink: %v", err)
+	}
+
+	options.stateStore, err = newStateStore(kubeClient, &stateOptions{
+		store:     stateStoreKind,
+		file:      stateFile,
+		namespace: stateNamespace,
+		configMap: stateConfigMap,
+	})
+	if err != nil {
+		log.Fatalf("Error while setting up the state store: %v", err)
+	}
+
+	targets, err := loadTargets(targetsConfigFile, aws.StringValue(sess.Config.Region))
+	if err != nil {
+		log.Fatalf("Error while loading targets: %v", err)
+	}
+	watchers := make([]*targetWatcher, 0, len(targets))
+	for _, target := range targets {
+		tw, err := newTargetWatcher(sess, target, fileConfigs, options)
+		if err != nil {
+			log.Fatalf("Error while setting up target %s %s: %v", target.Region, target.RoleARN, err)
+		}
+		watchers = append(watchers, tw)
+	}
+
 	pollIntSecInt, err := strconv.Atoi(pollIntervalSeconds)
 	if err != nil {
 		log.Fatalf("pollIntervalSeconds must be convertible to Int, got %v", httpPort)
 	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	go func() {
-		http.ListenAndServe(fmt.Sprintf(":%d", httpPortInt), promhttp.Handler())
-	}()
-	log.Printf("Listening on port %v", httpPortInt)
+	elector, err := newElector(kubeClient, &electionOptions{
+		elector:   leaderElection,
+		namespace: leaderElectionNamespace,
+		name:      leaderElectionName,
+		identity:  leaderElectionIdentity,
+		lockFile:  leaderElectionLockFile,
+	}, prometheus.DefaultRegisterer, logger)
+	if err != nil {
+		log.Fatalf("Error while setting up leader election: %v", err)
+	}
+	if elector != nil {
+		// Leadership is released on shutdown, so that another replica takes over straight away
+		electionCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		go func() {
+			defer stopSignals()
+			if err := elector.Run(electionCtx); err != nil {
+				flushTraces()
+				log.Fatalf("Error while running leader election: %v", err)
+			}
+			logger.Info("released leadership, shutting down")
+			flushTraces()
+			os.Exit(0)
+		}()
+	}
+
+	// The on-demand snapshot API is only served when a token is configured, and only by the leader, which
+	// runs the hooks of the volume's policy and is the only replica to create snapshots
+	if apiToken != "" {
+		snapshotters := make([]api.Snapshotter, 0, len(watchers))
+		for _, tw := range watchers {
+			snapshotters = append(snapshotters, tw.watcher)
+		}
+		mux.Handle("/v1/", leaderOnly(api.NewHandler(logger, options.auditSink, apiToken, snapshotters...), elector))
+		logger.Info("serving on-demand snapshot API")
+	}
 
+	// EBS events reconcile single volumes between cycles, which remain the safety net for missed events
+	if sqsQueueURL != "" {
+		sqsConfig := aws.NewConfig()
+		if sqsRegion != "" {
+			sqsConfig = sqsConfig.WithRegion(sqsRegion)
+		}
+		if sqsEndpoint != "" {
+			sqsConfig = sqsConfig.WithEndpoint(sqsEndpoint)
+		}
+		consumer := ebsevents.NewConsumer(
+			sqs.New(sess, sqsConfig),
+			sqsQueueURL,
+			reconcileEvent(watchers),
+			ebsevents.NewMetrics(prometheus.DefaultRegisterer),
+			logger.With("queue_url", sqsQueueURL))
+		go runWhileLeading(consumer.Run, elector)
+		logger.Info("consuming EBS events", "queue_url", sqsQueueURL)
+	}
+
+	discoveredConfigs := make([]models.VolumeSnapshotConfigs, len(discoverers))
 	for {
-		watcher.WatchSnapshots(snapshotConfigs)
-		<-time.After(time.Duration(pollIntSecInt) * time.Second)
-		log.Printf("Watching snapshots")
+		start := time.Now()
+		ctx, span := tracer.Start(context.Background(), "cycle")
+
+		_, discoverySpan := tracer.Start(ctx, "discoverPolicies")
+		discoveryFailed := false
+		for i, discoverer := range discoverers {
+			configs, err := discoverer.Discover()
+			if err != nil {
+				// Keep using the policies discovered last time
+				logger.Error("failed to discover snapshot policies",
+					logging.Action, "discover_policies",
+					logging.Error, err)
+				discoverySpan.RecordError(err)
+				discoveryFailed = true
+				continue
+			}
+			discoveredConfigs[i] = configs
+		}
+
+		snapshotConfigs := mergePolicies(fileConfigs, discoveredConfigs)
+		discoverySpan.SetAttributes(attribute.Int("policy.count", len(snapshotConfigs)))
+		discoverySpan.End()
+		// Readiness requires every discoverer to succeed once, later failures keep the last policies
+		if !discoveryFailed || tracker.Ready() {
+			tracker.Discovered(snapshotConfigs)
+		}
+
+		// Every replica discovers policies to be ready, but only the leader watches snapshots
+		watchCtx, leading, stopWatching := leaderContext(ctx, elector)
+		if !leading {
+			logger.Info("not leading, skipped watching snapshots")
+			forgetVolumes(watchers)
+			span.End()
+			waitForCycle(time.Duration(pollIntSecInt)*time.Second, elector)
+			continue
+		}
+
+		// Each target is watched in isolation, so a failing account or region doesn't affect others
+		var cycleErr error
+		states := make([]w.VolumeState, 0)
+		results := make([]controller.TargetResult, 0, len(watchers))
+		for _, tw := range watchers {
+			err := tw.watcher.WatchSnapshots(watchCtx, &snapshotConfigs)
+			if err != nil {
+				logger.Error("failed to watch snapshots",
+					"region", tw.region,
+					"account", tw.account,
+					logging.Error, err)
+				cycleErr = err
+			}
+			targetStates := tw.watcher.States()
+			states = append(states, targetStates...)
+			results = append(results, controller.TargetResult{States: targetStates, Err: err})
+		}
+
+		if policyController != nil {
+			if err := policyController.UpdateStatus(results); err != nil {
+				logger.Error("failed to update snapshot policies",
+					logging.Action, "update_policy_status",
+					logging.Error, err)
+			}
+		}
+		// Leadership lost during the cycle leaves the volumes to the new leader
+		lostLeadership := watchCtx.Err() != nil
+		stopWatching()
+		if lostLeadership {
+			forgetVolumes(watchers)
+		}
+		tracker.CycleCompleted(start, states, cycleErr)
+		span.End()
+		waitForCycle(time.Duration(pollIntSecInt)*time.Second, elector)
+		logger.Info("watching snapshots")
+	}
+}
+
+// newKubeClient used to create a Kubernetes client when running in a cluster, otherwise nil is returned
+func newKubeClient() (kubernetes.Interface, *rest.Config) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("Not running in Kubernetes, Kubernetes integrations are disabled: %v", err)
+		return nil, nil
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("Error while creating Kubernetes client: %v", err)
 	}
+	return client, config
+}
+
+// loadKubeconfig used to obtain the config of the current kubeconfig context, for commands run
+// outside the cluster
+func loadKubeconfig() (*rest.Config, error) {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
 }
 
 func loadVolumeSnapshotConfig(volumeSnapshotConfigFile string) *models.VolumeSnapshotConfigs {
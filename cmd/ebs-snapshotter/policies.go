@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/controller"
+	"github.com/utilitywarehouse/ebs-snapshotter/kube"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// newPolicyDiscoverers used to create the discoverers of policies from PVC annotations and from
+// SnapshotPolicy resources, as enabled. The SnapshotPolicy controller is also returned when enabled,
+// so that the outcome of cycles can be written to the resources' status.
+func newPolicyDiscoverers(
+	kubeClient kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	discoveryEnabled, policiesEnabled bool,
+	logger *slog.Logger) ([]kube.PolicyDiscoverer, *controller.SnapshotPolicyController) {
+
+	discoverers := make([]kube.PolicyDiscoverer, 0)
+	if discoveryEnabled {
+		discoverers = append(discoverers, kube.NewPVCPolicyDiscoverer(kubeClient, logger))
+	}
+	var policyController *controller.SnapshotPolicyController
+	if policiesEnabled {
+		policyController = controller.NewSnapshotPolicyController(dynamicClient, kubeClient, logger)
+		discoverers = append(discoverers, policyController)
+	}
+	return discoverers, policyController
+}
+
+// mergePolicies used to combine the policies of the config file with the discovered ones. File
+// policies are listed first, so they take precedence over discovered ones.
+func mergePolicies(fileConfigs *models.VolumeSnapshotConfigs, discovered []models.VolumeSnapshotConfigs) models.VolumeSnapshotConfigs {
+	snapshotConfigs := append(models.VolumeSnapshotConfigs{}, *fileConfigs...)
+	for _, configs := range discovered {
+		snapshotConfigs = append(snapshotConfigs, configs...)
+	}
+	return snapshotConfigs
+}
+
+// discoverPolicies used to discover the policies of every discoverer once, and to combine them with
+// the policies of the config file. Fails when any discoverer fails.
+func discoverPolicies(
+	fileConfigs *models.VolumeSnapshotConfigs,
+	discoverers []kube.PolicyDiscoverer) (models.VolumeSnapshotConfigs, error) {
+
+	discovered := make([]models.VolumeSnapshotConfigs, 0, len(discoverers))
+	for _, discoverer := range discoverers {
+		configs, err := discoverer.Discover()
+		if err != nil {
+			return nil, err
+		}
+		discovered = append(discovered, configs)
+	}
+	return mergePolicies(fileConfigs, discovered), nil
+}
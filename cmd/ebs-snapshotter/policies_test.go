@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/kube"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&PoliciesSuite{})
+
+type PoliciesSuite struct{}
+
+// fakeDiscoverer used to return fixed policies or a fixed error
+type fakeDiscoverer struct {
+	configs models.VolumeSnapshotConfigs
+	err     error
+}
+
+func (d *fakeDiscoverer) Discover() (models.VolumeSnapshotConfigs, error) {
+	return d.configs, d.err
+}
+
+func (s *PoliciesSuite) TestFilePoliciesListedBeforeDiscoveredOnes(c *C) {
+	fileConfigs := &models.VolumeSnapshotConfigs{{Name: "file"}}
+	discoverers := []kube.PolicyDiscoverer{
+		&fakeDiscoverer{configs: models.VolumeSnapshotConfigs{{Name: "pvc"}}},
+		&fakeDiscoverer{configs: models.VolumeSnapshotConfigs{{Name: "policy"}}},
+	}
+
+	configs, err := discoverPolicies(fileConfigs, discoverers)
+
+	c.Assert(err, IsNil)
+	names := make([]string, 0, len(configs))
+	for _, config := range configs {
+		names = append(names, config.Name)
+	}
+	c.Assert(names, DeepEquals, []string{"file", "pvc", "policy"})
+	c.Assert(*fileConfigs, HasLen, 1)
+}
+
+func (s *PoliciesSuite) TestDiscoveredPoliciesUsedWithoutFile(c *C) {
+	discoverers := []kube.PolicyDiscoverer{&fakeDiscoverer{configs: models.VolumeSnapshotConfigs{{Name: "pvc"}}}}
+
+	configs, err := discoverPolicies(&models.VolumeSnapshotConfigs{}, discoverers)
+
+	c.Assert(err, IsNil)
+	c.Assert(configs, HasLen, 1)
+	c.Assert(configs[0].Name, Equals, "pvc")
+}
+
+func (s *PoliciesSuite) TestDiscoveryFailsWhenAnyDiscovererFails(c *C) {
+	discoverers := []kube.PolicyDiscoverer{
+		&fakeDiscoverer{configs: models.VolumeSnapshotConfigs{{Name: "pvc"}}},
+		&fakeDiscoverer{err: errors.New("forbidden")},
+	}
+
+	_, err := discoverPolicies(&models.VolumeSnapshotConfigs{{Name: "file"}}, discoverers)
+
+	c.Assert(err, ErrorMatches, "forbidden")
+}
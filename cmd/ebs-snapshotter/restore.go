@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"github.com/utilitywarehouse/ebs-snapshotter/restore"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// runRestore used to create a volume from a snapshot, and optionally a PV and PVC bound to it
+func runRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	var (
+		region       = flags.String("region", "", "region of the snapshot, defaults to the session region")
+		roleARN      = flags.String("role-arn", "", "role to assume in the account of the snapshot")
+		snapshotID   = flags.String("snapshot-id", "", "ID of the snapshot to restore")
+		volumeID     = flags.String("volume-id", "", "restore the latest snapshot of this volume")
+		pvcName      = flags.String("pvc", "", "restore the latest snapshot of this PVC")
+		pvcNamespace = flags.String("namespace", "", "namespace of the PVC, and of the created PVC")
+		at           = flags.String("at", "", "restore the latest snapshot started at or before this RFC3339 time")
+		zone         = flags.String("az", "", "availability zone of the new volume")
+		volumeType   = flags.String("type", "", "type of the new volume, defaults to the EBS default")
+		iops         = flags.Int64("iops", 0, "provisioned IOPS of the new volume")
+		throughput   = flags.Int64("throughput", 0, "provisioned throughput of the new volume in MiB/s")
+		manifests    = flags.Bool("manifests", false, "print a PV and PVC bound to the new volume")
+		apply        = flags.Bool("apply", false, "create a PV and PVC bound to the new volume")
+		newPVCName   = flags.String("new-pvc", "", "name of the created PVC, defaults to <pvc>-restored")
+		storageClass = flags.String("storage-class", "", "storage class of the created PV and PVC")
+	)
+	flags.Parse(args)
+
+	req := &restore.Request{
+		SnapshotID:       *snapshotID,
+		VolumeID:         *volumeID,
+		PVCName:          *pvcName,
+		PVCNamespace:     *pvcNamespace,
+		AvailabilityZone: *zone,
+		VolumeType:       *volumeType,
+		Iops:             *iops,
+		Throughput:       *throughput,
+	}
+	if *at != "" {
+		pointInTime, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			log.Fatalf("-at must be an RFC3339 time, got %v", *at)
+		}
+		req.PointInTime = pointInTime
+	}
+	if (*manifests || *apply) && *newPVCName == "" {
+		if *pvcName == "" {
+			log.Fatalf("-new-pvc is required when the snapshot isn't chosen by PVC")
+		}
+		*newPVCName = *pvcName + "-restored"
+	}
+	// Checked before the volume is created, so that a missing namespace doesn't leave an unused volume
+	if (*manifests || *apply) && *pvcNamespace == "" {
+		log.Fatalf("-namespace is required when a PV and PVC are created")
+	}
+
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		log.Fatalf("Error while creating AWS session: %v", err)
+	}
+	if *region == "" {
+		*region = aws.StringValue(sess.Config.Region)
+	}
+	config := targetConfig(sess, &models.Target{Region: *region, RoleARN: *roleARN})
+
+	volume, snapshot, err := restore.Restore(context.Background(), clients.NewEBSClient(ec2.New(sess, config), nil), req)
+	if err != nil {
+		log.Fatalf("Error while restoring snapshot: %v", err)
+	}
+	log.Printf("Created volume %s in %s from snapshot %s", *volume.VolumeId, *volume.AvailabilityZone, *snapshot.SnapshotId)
+
+	if !*manifests && !*apply {
+		return
+	}
+
+	pv, pvc := restore.Manifests(volume, &restore.ManifestOptions{
+		PVName:       fmt.Sprintf("restored-%s", *volume.VolumeId),
+		PVCName:      *newPVCName,
+		Namespace:    *pvcNamespace,
+		StorageClass: *storageClass,
+	})
+
+	if *manifests {
+		for _, obj := range []interface{}{pv, pvc} {
+			out, err := yaml.Marshal(obj)
+			if err != nil {
+				log.Fatalf("Error while serialising manifest: %v", err)
+			}
+			fmt.Fprintf(os.Stdout, "---\n%s", out)
+		}
+	}
+
+	if *apply {
+		kubeConfig, err := loadKubeconfig()
+		if err != nil {
+			log.Fatalf("Error while loading Kubernetes config: %v", err)
+		}
+		kubeClient, err := kubernetes.NewForConfig(kubeConfig)
+		if err != nil {
+			log.Fatalf("Error while creating Kubernetes client: %v", err)
+		}
+		if err := restore.Apply(context.Background(), kubeClient, pv, pvc); err != nil {
+			log.Fatalf("Error while applying manifests: %v", err)
+		}
+		log.Printf("Created PV %s and PVC %s/%s", pv.Name, pvc.Namespace, pvc.Name)
+	}
+}
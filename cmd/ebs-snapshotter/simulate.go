@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/clock"
+	"github.com/utilitywarehouse/ebs-snapshotter/fakeec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/hooks"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	w "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+)
+
+const simulatedAccountID = "000000000000"
+
+// simulationOptions holds the parameters of a simulation
+type simulationOptions struct {
+	region         string
+	days           int
+	cycleInterval  time.Duration
+	reportInterval time.Duration
+	volumes        int
+	volumeSizeGiB  int64
+	changeRate     float64
+}
+
+// simulationRow holds the snapshots of a policy in a region at a point of a simulation
+type simulationRow struct {
+	Day        int       `json:"day"`
+	Time       time.Time `json:"time"`
+	Policy     string    `json:"policy"`
+	Region     string    `json:"region"`
+	Snapshots  int       `json:"snapshots"`
+	Created    int       `json:"created"`
+	Deleted    int       `json:"deleted"`
+	StorageGiB float64   `json:"storageGiB"`
+}
+
+// noopHookRunner used to let the hooks of simulated policies succeed without running them
+type noopHookRunner struct{}
+
+func (noopHookRunner) Run(ctx context.Context, hook *models.Hook, target hooks.Target) error {
+	return nil
+}
+
+// runSimulate used to replay the configured policies over simulated days against an in-memory EC2,
+// reporting how many snapshots each policy keeps, creates and deletes, and an estimate of their storage
+func runSimulate(args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	var (
+		configFile     = flags.String("config", getEnv("VOLUME_SNAPSHOT_CONFIG_FILE", ""), "volume snapshot config file")
+		days           = flags.Int("days", 30, "number of days to simulate")
+		cycleInterval  = flags.Duration("cycle-interval", 30*time.Minute, "time between simulated cycles")
+		reportInterval = flags.Duration("report-interval", 24*time.Hour, "time between reported rows")
+		volumes        = flags.Int("volumes", 1, "number of volumes simulated for each label policy")
+		volumeSize     = flags.Int64("volume-size", 100, "size of simulated volumes in GiB")
+		changeRate     = flags.Float64("change-rate", 0.05, "share of a volume changed per day, used to estimate storage")
+		output         = flags.String("output", "table", "output format, one of table or json")
+	)
+	flags.Parse(args)
+
+	if *output != "table" && *output != "json" {
+		log.Fatalf("-output must be one of table or json, got %v", *output)
+	}
+	if *days <= 0 || *cycleInterval <= 0 || *reportInterval <= 0 {
+		log.Fatalf("-days, -cycle-interval and -report-interval must be positive")
+	}
+
+	rows, err := simulate(loadVolumeSnapshotConfig(*configFile), &simulationOptions{
+		region:         "eu-west-1",
+		days:           *days,
+		cycleInterval:  *cycleInterval,
+		reportInterval: *reportInterval,
+		volumes:        *volumes,
+		volumeSizeGiB:  *volumeSize,
+		changeRate:     *changeRate,
+	})
+	if err != nil {
+		log.Fatalf("Error while simulating policies: %v", err)
+	}
+
+	if err := writeSimulationReport(os.Stdout, rows, *output); err != nil {
+		log.Fatalf("Error while writing the simulation report: %v", err)
+	}
+}
+
+// writeSimulationReport used to write the rows of a simulation in given format, one of table or json
+func writeSimulationReport(out io.Writer, rows []simulationRow, output string) error {
+	if output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	}
+	return writeSimulationTable(out, rows)
+}
+
+// simulate used to run cycles of given policies every cycle interval on a simulated clock. Snapshots
+// complete as soon as they are next listed. Vault copies aren't simulated.
+func simulate(configs *models.VolumeSnapshotConfigs, options *simulationOptions) ([]simulationRow, error) {
+	start := time.Now().UTC().Truncate(24 * time.Hour)
+	clk := clock.NewSimulated(start)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	source := fakeec2.New(options.region, simulatedAccountID)
+	source.SetClock(clk)
+	fakes := map[string]*fakeec2.EC2{options.region: source}
+
+	// policies holds the policy of each simulated volume, which is the first one matching it
+	policies := make(map[string]string)
+	destinations := make(map[string]clients.EBSClient)
+	for _, config := range *configs {
+		name := w.PolicyName(config)
+		if config.VolumeID != "" {
+			if source.Volume(config.VolumeID) == nil {
+				policies[source.AddVolume(&ec2.Volume{
+					VolumeId: aws.String(config.VolumeID),
+					Size:     aws.Int64(options.volumeSizeGiB),
+				})] = name
+			}
+		} else {
+			for i := 0; i < options.volumes; i++ {
+				policies[source.AddVolume(&ec2.Volume{
+					Size: aws.Int64(options.volumeSizeGiB),
+					Tags: []*ec2.Tag{{Key: aws.String(config.Labels.Key), Value: aws.String(config.Labels.Value)}},
+				})] = name
+			}
+		}
+		for _, dest := range config.CopyTo {
+			if _, ok := fakes[dest.Region]; ok {
+				continue
+			}
+			fake := fakeec2.New(dest.Region, simulatedAccountID)
+			fake.SetClock(clk)
+			fake.LinkRegion(source)
+			fakes[dest.Region] = fake
+			destinations[dest.Region] = clients.NewEBSClient(fake, nil)
+		}
+	}
+
+	watcher := w.NewEBSSnapshotWatcher(clients.NewEBSClient(source, nil), w.NewMetrics(prometheus.NewRegistry()))
+	watcher.SetClock(clk)
+	watcher.SetLogger(logger)
+	watcher.SetHookRunner(noopHookRunner{})
+	if len(destinations) > 0 {
+		watcher.SetSnapshotCopier(clients.NewSnapshotCopier(options.region, destinations, logger))
+	}
+
+	regions := make([]string, 0, len(fakes))
+	for region := range fakes {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	// seen holds the policy of the snapshots found in each region on the last report
+	seen := make(map[string]map[string]string)
+	rows := make([]simulationRow, 0)
+	end := start.Add(time.Duration(options.days) * 24 * time.Hour)
+	nextReport := start
+	for !clk.Now().After(end) {
+		if err := watcher.WatchSnapshots(context.Background(), configs); err != nil {
+			return nil, err
+		}
+
+		if now := clk.Now(); !now.Before(nextReport) {
+			for _, region := range regions {
+				rows = append(rows, reportRegion(fakes[region], region, policies, seen, now, start, options)...)
+			}
+			nextReport = nextReport.Add(options.reportInterval)
+		}
+		clk.Advance(options.cycleInterval)
+	}
+
+	return rows, nil
+}
+
+// reportRegion used to summarise the snapshots of each policy in a region, counting the snapshots
+// created and deleted since the last report
+func reportRegion(
+	fake *fakeec2.EC2,
+	region string,
+	policies map[string]string,
+	seen map[string]map[string]string,
+	now, start time.Time,
+	options *simulationOptions) []simulationRow {
+
+	current := make(map[string]string)
+	byPolicy := make(map[string]map[string][]*ec2.Snapshot)
+	for _, snapshot := range fake.Snapshots("") {
+		volumeID := aws.StringValue(snapshot.VolumeId)
+		if sourceVolumeID := clients.SourceVolumeID(snapshot); sourceVolumeID != "" {
+			volumeID = sourceVolumeID
+		}
+		policy := policies[volumeID]
+		current[*snapshot.SnapshotId] = policy
+		if byPolicy[policy] == nil {
+			byPolicy[policy] = make(map[string][]*ec2.Snapshot)
+		}
+		byPolicy[policy][volumeID] = append(byPolicy[policy][volumeID], snapshot)
+	}
+
+	created := make(map[string]int)
+	deleted := make(map[string]int)
+	for id, policy := range current {
+		if _, ok := seen[region][id]; !ok {
+			created[policy]++
+		}
+	}
+	for id, policy := range seen[region] {
+		if _, ok := current[id]; !ok {
+			deleted[policy]++
+		}
+	}
+	seen[region] = current
+
+	names := make([]string, 0)
+	for name := range byPolicy {
+		names = append(names, name)
+	}
+	for name := range deleted {
+		if _, ok := byPolicy[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	rows := make([]simulationRow, 0, len(names))
+	for _, name := range names {
+		row := simulationRow{
+			Day:     int(now.Sub(start) / (24 * time.Hour)),
+			Time:    now,
+			Policy:  name,
+			Region:  region,
+			Created: created[name],
+			Deleted: deleted[name],
+		}
+		for _, snapshots := range byPolicy[name] {
+			row.Snapshots += len(snapshots)
+			row.StorageGiB += estimateStorage(snapshots, options.volumeSizeGiB, options.changeRate)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// estimateStorage used to estimate the storage of the snapshots of a volume, oldest first. The oldest
+// snapshot holds the whole volume, later ones only the data changed since the previous snapshot.
+func estimateStorage(snapshots []*ec2.Snapshot, sizeGiB int64, changeRate float64) float64 {
+	size := float64(sizeGiB)
+	total := 0.0
+	for i, snapshot := range snapshots {
+		if i == 0 {
+			total += size
+			continue
+		}
+		days := snapshot.StartTime.Sub(*snapshots[i-1].StartTime).Hours() / 24
+		total += min(size, size*changeRate*days)
+	}
+	return total
+}
+
+func writeSimulationTable(out io.Writer, rows []simulationRow) error {
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "DAY\tTIME\tPOLICY\tREGION\tSNAPSHOTS\tCREATED\tDELETED\tSTORAGE")
+	for _, row := range rows {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%d\t%d\t%d\t%.1fGiB\n",
+			row.Day,
+			row.Time.Format(time.RFC3339),
+			orDash(row.Policy),
+			row.Region,
+			row.Snapshots,
+			row.Created,
+			row.Deleted,
+			row.StorageGiB)
+	}
+	return writer.Flush()
+}
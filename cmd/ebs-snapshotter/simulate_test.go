@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&SimulateSuite{})
+
+type SimulateSuite struct{}
+
+func newSimulationOptions() *simulationOptions {
+	return &simulationOptions{
+		region:         defaultRegion,
+		days:           10,
+		cycleInterval:  30 * time.Minute,
+		reportInterval: 24 * time.Hour,
+		volumes:        1,
+		volumeSizeGiB:  100,
+		changeRate:     0.05,
+	}
+}
+
+func (s *SimulateSuite) TestSimulationKeepsSnapshotsWithinRetention(c *C) {
+	configs := &models.VolumeSnapshotConfigs{{
+		Name:                 "daily",
+		Labels:               models.Label{Key: "snapshot", Value: "daily"},
+		IntervalSeconds:      86400,
+		RetentionPeriodHours: 72,
+	}}
+
+	rows, err := simulate(configs, newSimulationOptions())
+
+	c.Assert(err, IsNil)
+	// Waits between requests move the simulated clock, so the last day may not be reported
+	c.Assert(len(rows) >= 10, Equals, true)
+	c.Assert(rows[0].Day, Equals, 0)
+	c.Assert(rows[0].Policy, Equals, "daily")
+	c.Assert(rows[0].Region, Equals, defaultRegion)
+	c.Assert(rows[0].Snapshots, Equals, 1)
+	c.Assert(rows[0].Created, Equals, 1)
+	c.Assert(rows[0].StorageGiB, Equals, float64(100))
+
+	deleted := 0
+	for _, row := range rows {
+		deleted += row.Deleted
+		c.Assert(row.Snapshots <= 4, Equals, true, Commentf("day %d", row.Day))
+	}
+	last := rows[len(rows)-1]
+	c.Assert(last.Day >= 9, Equals, true)
+	c.Assert(last.Snapshots >= 3, Equals, true)
+	c.Assert(deleted > 0, Equals, true)
+}
+
+func (s *SimulateSuite) TestSimulationReportsCopyRegions(c *C) {
+	configs := &models.VolumeSnapshotConfigs{{
+		Name:                 "daily",
+		Labels:               models.Label{Key: "snapshot", Value: "daily"},
+		IntervalSeconds:      86400,
+		RetentionPeriodHours: 72,
+		CopyTo:               []*models.CopyDestination{{Region: "eu-central-1", RetentionPeriodHours: 168}},
+	}}
+	options := newSimulationOptions()
+	options.days = 2
+
+	rows, err := simulate(configs, options)
+
+	c.Assert(err, IsNil)
+	regions := make(map[string]bool)
+	for _, row := range rows {
+		regions[row.Region] = true
+	}
+	c.Assert(regions, DeepEquals, map[string]bool{defaultRegion: true, "eu-central-1": true})
+}
+
+func (s *SimulateSuite) TestStorageEstimatedFromChangedData(c *C) {
+	start := time.Now()
+	snapshots := []*ec2.Snapshot{
+		{StartTime: aws.Time(start)},
+		{StartTime: aws.Time(start.Add(24 * time.Hour))},
+		{StartTime: aws.Time(start.Add(48 * time.Hour))},
+	}
+
+	c.Assert(estimateStorage(snapshots, 100, 0.05), Equals, float64(110))
+	// A snapshot never holds more than the whole volume
+	c.Assert(estimateStorage(snapshots, 100, 2), Equals, float64(300))
+}
+
+func (s *SimulateSuite) TestSimulationReportWritten(c *C) {
+	rows := []simulationRow{{
+		Day:        1,
+		Time:       time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		Policy:     "daily",
+		Region:     defaultRegion,
+		Snapshots:  2,
+		Created:    1,
+		StorageGiB: 105,
+	}}
+
+	out := &bytes.Buffer{}
+	c.Assert(writeSimulationReport(out, rows, "json"), IsNil)
+	decoded := make([]simulationRow, 0)
+	c.Assert(json.Unmarshal(out.Bytes(), &decoded), IsNil)
+	c.Assert(decoded, DeepEquals, rows)
+
+	out.Reset()
+	c.Assert(writeSimulationReport(out, rows, "table"), IsNil)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	c.Assert(lines, HasLen, 2)
+	c.Assert(strings.Fields(lines[1]), DeepEquals,
+		[]string{"1", "2020-01-02T00:00:00Z", "daily", defaultRegion, "2", "1", "0", "105.0GiB"})
+}
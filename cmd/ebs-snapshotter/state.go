@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/store"
+	"k8s.io/client-go/kubernetes"
+)
+
+// stateOptions holds the settings of the state store
+type stateOptions struct {
+	store                string
+	file                 string
+	namespace, configMap string
+}
+
+// newStateStore used to create the state store chosen by STATE_STORE, or nil when state isn't kept
+func newStateStore(kubeClient kubernetes.Interface, options *stateOptions) (store.StateStore, error) {
+	switch options.store {
+	case "", "none":
+		return nil, nil
+	case "bolt":
+		return store.NewBoltStore(options.file)
+	case "configmap":
+		if kubeClient == nil {
+			return nil, errors.New("the configmap state store requires running in a Kubernetes cluster")
+		}
+		return store.NewConfigMapStore(kubeClient, options.namespace, options.configMap), nil
+	default:
+		return nil, errors.Errorf("unknown state store %s", options.store)
+	}
+}
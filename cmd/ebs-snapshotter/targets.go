@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/utilitywarehouse/ebs-snapshotter/audit"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/hooks"
+	"github.com/utilitywarehouse/ebs-snapshotter/kube"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"github.com/utilitywarehouse/ebs-snapshotter/notify"
+	"github.com/utilitywarehouse/ebs-snapshotter/store"
+	w "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const clientsTracerName = "github.com/utilitywarehouse/ebs-snapshotter/clients"
+
+// targetWatcher holds the watcher of a single region and account
+type targetWatcher struct {
+	region, account string
+	watcher         *w.EBSSnapshotWatcher
+}
+
+// clientFactory holds the functions creating the AWS clients of a target from its config, so that
+// tests can replace them
+type clientFactory struct {
+	sts func(config *aws.Config) stsiface.STSAPI
+	ec2 func(config *aws.Config) ec2iface.EC2API
+	kms func(config *aws.Config) *kms.KMS
+}
+
+// newClientFactory used to create a factory of AWS clients sharing given session
+func newClientFactory(sess *session.Session) *clientFactory {
+	return &clientFactory{
+		sts: func(config *aws.Config) stsiface.STSAPI { return sts.New(sess, config) },
+		ec2: func(config *aws.Config) ec2iface.EC2API { return ec2.New(sess, config) },
+		kms: func(config *aws.Config) *kms.KMS { return kms.New(sess, config) },
+	}
+}
+
+// targetOptions holds the settings shared by the watchers of all targets
+type targetOptions struct {
+	clients                   *clientFactory
+	metrics                   *w.Metrics
+	logger                    *slog.Logger
+	tracerProvider            trace.TracerProvider
+	hookRunner                hooks.Runner
+	registrar                 kube.SnapshotRegistrar
+	notifier                  notify.Notifier
+	auditSink                 audit.Sink
+	stateStore                store.StateStore
+	vaultRoleARN, vaultRegion string
+}
+
+func newTargetWatcher(
+	sess *session.Session,
+	target *models.Target,
+	snapshotConfigs *models.VolumeSnapshotConfigs,
+	options *targetOptions) (*targetWatcher, error) {
+
+	config := targetConfig(sess, target)
+
+	account, err := lookupAccount(options.clients.sts(config))
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := options.metrics.MustCurryWith(prometheus.Labels{"region": target.Region, "account": account})
+	tracer := options.tracerProvider.Tracer(clientsTracerName)
+	ebsClient := clients.NewInstrumentedEBSClient(
+		clients.NewEBSClient(options.clients.ec2(config), options.clients.kms(config)),
+		metrics.APILatency,
+		tracer)
+	logger := options.logger.With("region", target.Region, "account", account)
+	watcher := w.NewEBSSnapshotWatcher(ebsClient, metrics)
+	watcher.SetLogger(logger)
+	watcher.SetTracerProvider(options.tracerProvider)
+	watcher.SetHookRunner(options.hookRunner)
+	if options.registrar != nil {
+		watcher.SetSnapshotRegistrar(options.registrar)
+	}
+	if options.notifier != nil {
+		watcher.SetNotifier(options.notifier)
+	}
+	if options.auditSink != nil {
+		watcher.SetAuditSink(options.auditSink)
+	}
+	if options.stateStore != nil {
+		watcher.SetStateStore(options.stateStore, target.Region+"/"+account)
+	}
+
+	if regions := copyRegions(snapshotConfigs); len(regions) > 0 {
+		destinations := make(map[string]clients.EBSClient)
+		for _, region := range regions {
+			destinations[region] = clients.NewInstrumentedEBSClient(
+				clients.NewEBSClient(options.clients.ec2(config.Copy().WithRegion(region)), nil),
+				metrics.APILatency,
+				tracer)
+		}
+		copier := clients.NewSnapshotCopier(target.Region, destinations, logger)
+		watcher.SetSnapshotCopier(copier)
+		logger.Info("copying snapshots to other regions", "regions", regions)
+	}
+
+	if options.vaultRoleARN != "" {
+		roleARN, err := arn.Parse(options.vaultRoleARN)
+		if err != nil {
+			return nil, errors.Wrapf(err, "VAULT_ROLE_ARN must be a valid ARN, got %v", options.vaultRoleARN)
+		}
+		region := options.vaultRegion
+		if region == "" {
+			region = target.Region
+		}
+		vaultConfig := aws.NewConfig().
+			WithRegion(region).
+			WithCredentials(stscreds.NewCredentials(sess, options.vaultRoleARN))
+		vaultClient := clients.NewInstrumentedEBSClient(
+			clients.NewEBSClient(options.clients.ec2(vaultConfig), nil),
+			metrics.APILatency,
+			tracer)
+		vaultCopier := clients.NewVaultCopier(ebsClient, target.Region, roleARN.AccountID, region, vaultClient, logger)
+		watcher.SetVaultCopier(vaultCopier, region)
+		logger.Info("copying snapshots to vault account", "vault_account", roleARN.AccountID, "vault_region", region)
+	}
+
+	return &targetWatcher{
+		region:  target.Region,
+		account: account,
+		watcher: watcher,
+	}, nil
+}
+
+// lookupAccount used to obtain the ID of the account the credentials of given client belong to
+func lookupAccount(client stsiface.STSAPI) (string, error) {
+	identity, err := client.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", errors.Wrap(err, "error while obtaining the caller identity")
+	}
+	return aws.StringValue(identity.Account), nil
+}
+
+// loadTargets used to read the targets from given file, or to obtain the default region alone when
+// no file is set. Targets without a region are given the default region.
+func loadTargets(targetsConfigFile, defaultRegion string) (models.Targets, error) {
+	if targetsConfigFile == "" {
+		return models.Targets{{Region: defaultRegion}}, nil
+	}
+
+	fileContent, err := ioutil.ReadFile(targetsConfigFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error while reading targets config file")
+	}
+	targets := models.Targets{}
+	if err = json.Unmarshal(fileContent, &targets); err != nil {
+		return nil, errors.Wrap(err, "error while deserialising targets config file")
+	}
+	for _, target := range targets {
+		if target.Region == "" {
+			target.Region = defaultRegion
+		}
+	}
+	return targets, nil
+}
+
+// targetConfig used to build the AWS config of a target, assuming its role when set
+func targetConfig(sess *session.Session, target *models.Target) *aws.Config {
+	config := aws.NewConfig().WithRegion(target.Region)
+	if target.RoleARN != "" {
+		config = config.WithCredentials(stscreds.NewCredentials(sess, target.RoleARN))
+	}
+	return config
+}
+
+func copyRegions(snapshotConfigs *models.VolumeSnapshotConfigs) []string {
+	seen := make(map[string]bool)
+	regions := make([]string, 0)
+	for _, config := range *snapshotConfigs {
+		for _, dest := range config.CopyTo {
+			if !seen[dest.Region] {
+				seen[dest.Region] = true
+				regions = append(regions, dest.Region)
+			}
+		}
+	}
+	return regions
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/utilitywarehouse/ebs-snapshotter/fakeec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	w "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+	"go.opentelemetry.io/otel/trace/noop"
+	. "gopkg.in/check.v1"
+)
+
+const (
+	defaultRegion = "eu-west-1"
+	targetAccount = "222222222222"
+	targetRoleARN = "arn:aws:iam::222222222222:role/ebs-snapshotter"
+)
+
+var _ = Suite(&TargetsSuite{})
+
+type TargetsSuite struct{}
+
+func TestCmd(t *testing.T) { TestingT(t) }
+
+var discardLogger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+
+// fakeSTS used to return the account of the caller identity, or an error when set
+type fakeSTS struct {
+	stsiface.STSAPI
+	account string
+	err     error
+}
+
+func (f *fakeSTS) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sts.GetCallerIdentityOutput{Account: aws.String(f.account)}, nil
+}
+
+// fakeClients used to create clients backed by a fake EC2 for each region, recording the configs used
+type fakeClients struct {
+	sts     *fakeSTS
+	regions map[string]*fakeec2.EC2
+	configs []*aws.Config
+}
+
+func (f *fakeClients) factory() *clientFactory {
+	return &clientFactory{
+		sts: func(config *aws.Config) stsiface.STSAPI {
+			f.configs = append(f.configs, config)
+			return f.sts
+		},
+		ec2: func(config *aws.Config) ec2iface.EC2API {
+			region := aws.StringValue(config.Region)
+			if _, ok := f.regions[region]; !ok {
+				f.regions[region] = fakeec2.New(region, f.sts.account)
+			}
+			return f.regions[region]
+		},
+		kms: func(config *aws.Config) *kms.KMS { return nil },
+	}
+}
+
+func newFakeClients() *fakeClients {
+	return &fakeClients{
+		sts:     &fakeSTS{account: targetAccount},
+		regions: make(map[string]*fakeec2.EC2),
+	}
+}
+
+func (s *TargetsSuite) TestTargetsDefaultToSessionRegion(c *C) {
+	targets, err := loadTargets("", defaultRegion)
+
+	c.Assert(err, IsNil)
+	c.Assert(targets, DeepEquals, models.Targets{{Region: defaultRegion}})
+}
+
+func (s *TargetsSuite) TestTargetsWithoutRegionGivenDefaultRegion(c *C) {
+	path := filepath.Join(c.MkDir(), "targets.json")
+	c.Assert(ioutil.WriteFile(path, []byte(`[
+		{"roleArn": "`+targetRoleARN+`"},
+		{"region": "us-east-1"}
+	]`), 0644), IsNil)
+
+	targets, err := loadTargets(path, defaultRegion)
+
+	c.Assert(err, IsNil)
+	c.Assert(targets, DeepEquals, models.Targets{
+		{Region: defaultRegion, RoleARN: targetRoleARN},
+		{Region: "us-east-1"},
+	})
+}
+
+func (s *TargetsSuite) TestTargetsFailOnInvalidFile(c *C) {
+	path := filepath.Join(c.MkDir(), "targets.json")
+	c.Assert(ioutil.WriteFile(path, []byte(`{"region": "eu-west-1"}`), 0644), IsNil)
+
+	_, err := loadTargets(path, defaultRegion)
+	c.Assert(err, ErrorMatches, "error while deserialising targets config file: .*")
+
+	_, err = loadTargets(filepath.Join(c.MkDir(), "missing.json"), defaultRegion)
+	c.Assert(err, ErrorMatches, "error while reading targets config file: .*")
+}
+
+func (s *TargetsSuite) TestTargetConfigAssumesRole(c *C) {
+	sess := newTestSession(c)
+
+	config := targetConfig(sess, &models.Target{Region: "us-east-1", RoleARN: targetRoleARN})
+	c.Assert(aws.StringValue(config.Region), Equals, "us-east-1")
+	c.Assert(config.Credentials, NotNil)
+
+	config = targetConfig(sess, &models.Target{Region: "us-east-1"})
+	c.Assert(config.Credentials, IsNil)
+}
+
+func (s *TargetsSuite) TestTargetWatcherLooksUpAccount(c *C) {
+	fake := newFakeClients()
+	options := newTestTargetOptions(fake, prometheus.NewRegistry())
+
+	tw, err := newTargetWatcher(newTestSession(c), &models.Target{Region: "us-east-1", RoleARN: targetRoleARN},
+		&models.VolumeSnapshotConfigs{}, options)
+
+	c.Assert(err, IsNil)
+	c.Assert(tw.region, Equals, "us-east-1")
+	c.Assert(tw.account, Equals, targetAccount)
+	// The identity is looked up with the credentials of the target's role
+	c.Assert(fake.configs, HasLen, 1)
+	c.Assert(aws.StringValue(fake.configs[0].Region), Equals, "us-east-1")
+	c.Assert(fake.configs[0].Credentials, NotNil)
+}
+
+func (s *TargetsSuite) TestTargetWatcherFailsWithoutAccount(c *C) {
+	fake := newFakeClients()
+	fake.sts.err = errors.New("access denied")
+
+	_, err := newTargetWatcher(newTestSession(c), &models.Target{Region: defaultRegion},
+		&models.VolumeSnapshotConfigs{}, newTestTargetOptions(fake, prometheus.NewRegistry()))
+
+	c.Assert(err, ErrorMatches, "error while obtaining the caller identity: access denied")
+}
+
+func (s *TargetsSuite) TestTargetMetricsCarryRegionAndAccount(c *C) {
+	fake := newFakeClients()
+	registry := prometheus.NewRegistry()
+	options := newTestTargetOptions(fake, registry)
+	configs := &models.VolumeSnapshotConfigs{{
+		Labels:               models.Label{Key: "snapshot", Value: "daily"},
+		IntervalSeconds:      3600,
+		RetentionPeriodHours: 24,
+	}}
+
+	tw, err := newTargetWatcher(newTestSession(c), &models.Target{Region: "us-east-1"}, configs, options)
+	c.Assert(err, IsNil)
+	volumeID := fake.regions["us-east-1"].AddVolume(&ec2.Volume{
+		Tags: []*ec2.Tag{{Key: aws.String("snapshot"), Value: aws.String("daily")}},
+	})
+	c.Assert(tw.watcher.WatchSnapshots(context.Background(), configs), IsNil)
+
+	c.Assert(fake.regions["us-east-1"].Snapshots(volumeID), HasLen, 1)
+	c.Assert(testutil.ToFloat64(
+		options.metrics.SnapshotsCreated.WithLabelValues("", "", volumeID, "us-east-1", targetAccount)), Equals, float64(1))
+	c.Assert(testutil.ToFloat64(
+		options.metrics.LastSuccessfulRun.WithLabelValues("us-east-1", targetAccount)) > 0, Equals, true)
+}
+
+func (s *TargetsSuite) TestTargetWatcherCreatesClientsForCopyRegions(c *C) {
+	fake := newFakeClients()
+	configs := &models.VolumeSnapshotConfigs{{
+		Labels: models.Label{Key: "snapshot", Value: "daily"},
+		CopyTo: []*models.CopyDestination{{Region: "eu-central-1"}, {Region: "eu-central-1"}, {Region: "us-west-2"}},
+	}}
+
+	_, err := newTargetWatcher(newTestSession(c), &models.Target{Region: defaultRegion}, configs,
+		newTestTargetOptions(fake, prometheus.NewRegistry()))
+
+	c.Assert(err, IsNil)
+	c.Assert(copyRegions(configs), DeepEquals, []string{"eu-central-1", "us-west-2"})
+	for _, region := range []string{defaultRegion, "eu-central-1", "us-west-2"} {
+		c.Assert(fake.regions[region], NotNil, Commentf("region %s", region))
+	}
+}
+
+func newTestTargetOptions(fake *fakeClients, registry prometheus.Registerer) *targetOptions {
+	return &targetOptions{
+		clients:        fake.factory(),
+		metrics:        w.NewMetrics(registry, "region", "account"),
+		logger:         discardLogger,
+		tracerProvider: noop.NewTracerProvider(),
+	}
+}
+
+func newTestSession(c *C) *session.Session {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(defaultRegion)})
+	c.Assert(err, IsNil)
+	return sess
+}
@@ -0,0 +1,218 @@
+// Package controller reconciles SnapshotPolicy resources using the EBS snapshot watcher
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/apis/v1alpha1"
+	"github.com/utilitywarehouse/ebs-snapshotter/kube"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"github.com/utilitywarehouse/ebs-snapshotter/watcher"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SnapshotPolicyController used to turn SnapshotPolicy resources into snapshot policies
+// and to write the outcome of each cycle back to their status
+type SnapshotPolicyController struct {
+	dynamicClient dynamic.Interface
+	kubeClient    kubernetes.Interface
+	logger        *slog.Logger
+
+	mu       sync.Mutex
+	policies map[string]*policyState
+}
+
+// policyState holds a SnapshotPolicy seen on the last discovery along with its resolved volumes
+type policyState struct {
+	object  *unstructured.Unstructured
+	policy  *v1alpha1.SnapshotPolicy
+	volumes []string
+	errors  []string
+}
+
+// NewSnapshotPolicyController used to create a new SnapshotPolicy controller
+func NewSnapshotPolicyController(
+	dynamicClient dynamic.Interface,
+	kubeClient kubernetes.Interface,
+	logger *slog.Logger) *SnapshotPolicyController {
+
+	return &SnapshotPolicyController{
+		dynamicClient: dynamicClient,
+		kubeClient:    kubeClient,
+		logger:        logger,
+		policies:      make(map[string]*policyState),
+	}
+}
+
+// Discover used to obtain a snapshot policy for the EBS volume of every PVC selected by a SnapshotPolicy
+func (c *SnapshotPolicyController) Discover() (models.VolumeSnapshotConfigs, error) {
+	ctx := context.Background()
+	list, err := c.dynamicClient.Resource(v1alpha1.SnapshotPolicyResource).
+		Namespace(metav1.NamespaceAll).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error while listing snapshot policies")
+	}
+
+	configs := models.VolumeSnapshotConfigs{}
+	policies := make(map[string]*policyState)
+	for i := range list.Items {
+		obj := &list.Items[i]
+		policy := &v1alpha1.SnapshotPolicy{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, policy); err != nil {
+			c.logger.Error("skipped invalid snapshot policy",
+				logging.Policy, fmt.Sprintf("snapshotpolicy/%s/%s", obj.GetNamespace(), obj.GetName()),
+				logging.Action, "discover_policy",
+				logging.Error, err)
+			continue
+		}
+
+		state := &policyState{object: obj, policy: policy}
+		policies[PolicyName(policy)] = state
+
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.Selector)
+		if err != nil {
+			state.errors = append(state.errors, fmt.Sprintf("invalid selector: %v", err))
+			continue
+		}
+
+		pvcs, err := c.kubeClient.CoreV1().PersistentVolumeClaims(policy.Namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: selector.String(),
+		})
+		if err != nil {
+			state.errors = append(state.errors, fmt.Sprintf("error while listing PVCs: %v", err))
+			continue
+		}
+
+		for j := range pvcs.Items {
+			pvc := &pvcs.Items[j]
+			volumeID, err := kube.VolumeIDForPVC(ctx, c.kubeClient, pvc)
+			if err != nil {
+				state.errors = append(state.errors, fmt.Sprintf("PVC %s: %v", pvc.Name, err))
+				continue
+			}
+			state.volumes = append(state.volumes, volumeID)
+			configs = append(configs, &models.VolumeSnapshotConfig{
+				Name:                 PolicyName(policy),
+				VolumeID:             volumeID,
+				IntervalSeconds:      policy.Spec.IntervalSeconds,
+				RetentionPeriodHours: policy.Spec.RetentionPeriodHours,
+			})
+		}
+	}
+
+	c.mu.Lock()
+	c.policies = policies
+	c.mu.Unlock()
+
+	return configs, nil
+}
+
+// TargetResult holds the outcome of the last cycle in a single region and account. The states are
+// those of the last successful cycle when the cycle failed.
+type TargetResult struct {
+	States []watcher.VolumeState
+	Err    error
+}
+
+// UpdateStatus used to write the outcome of the last cycle to the status of every SnapshotPolicy
+// seen on the last discovery. The error of a target is only reported on the policies that matched
+// volumes in that target.
+func (c *SnapshotPolicyController) UpdateStatus(results []TargetResult) error {
+	byPolicy := make(map[string][]watcher.VolumeState)
+	targetErrs := make(map[string][]string)
+	for _, result := range results {
+		reported := make(map[string]bool)
+		for _, state := range result.States {
+			byPolicy[state.Policy] = append(byPolicy[state.Policy], state)
+			if result.Err != nil && !reported[state.Policy] {
+				reported[state.Policy] = true
+				targetErrs[state.Policy] = append(targetErrs[state.Policy], result.Err.Error())
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	failed := make([]string, 0)
+	for name, ps := range c.policies {
+		status := policyStatus(ps, byPolicy[name], targetErrs[name])
+
+		statusObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		obj := ps.object.DeepCopy()
+		obj.Object["status"] = statusObj
+
+		updated, err := c.dynamicClient.Resource(v1alpha1.SnapshotPolicyResource).
+			Namespace(obj.GetNamespace()).
+			UpdateStatus(context.Background(), obj, metav1.UpdateOptions{})
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		ps.object = updated
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("error while updating snapshot policy status, %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// PolicyName used to obtain the name snapshot policies of a SnapshotPolicy are reported under
+func PolicyName(policy *v1alpha1.SnapshotPolicy) string {
+	return fmt.Sprintf("snapshotpolicy/%s/%s", policy.Namespace, policy.Name)
+}
+
+func policyStatus(ps *policyState, states []watcher.VolumeState, targetErrs []string) v1alpha1.SnapshotPolicyStatus {
+	status := v1alpha1.SnapshotPolicyStatus{
+		ObservedGeneration: ps.policy.Generation,
+		Volumes:            len(ps.volumes),
+		Conditions:         ps.policy.Status.Conditions,
+	}
+
+	errs := append(append([]string{}, ps.errors...), targetErrs...)
+	for _, state := range states {
+		status.SnapshotCount += state.SnapshotCount
+		if state.LatestSnapshotTime != nil &&
+			(status.LastSnapshotTime == nil || state.LatestSnapshotTime.After(status.LastSnapshotTime.Time)) {
+			t := metav1.NewTime(*state.LatestSnapshotTime)
+			status.LastSnapshotTime = &t
+		}
+		if state.LastError != "" {
+			errs = append(errs, fmt.Sprintf("volume %s: %s", state.VolumeID, state.LastError))
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: ps.policy.Generation,
+		Reason:             "Reconciled",
+		Message:            fmt.Sprintf("%d volumes snapshotted", len(states)),
+	}
+	if len(errs) > 0 {
+		status.LastError = strings.Join(errs, "; ")
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ReconcileFailed"
+		condition.Message = status.LastError
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	return status
+}
@@ -0,0 +1,204 @@
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/apis/v1alpha1"
+	"github.com/utilitywarehouse/ebs-snapshotter/controller"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"github.com/utilitywarehouse/ebs-snapshotter/watcher"
+	. "gopkg.in/check.v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Suite(&ControllerSuite{})
+
+type ControllerSuite struct{}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestController(t *testing.T) { TestingT(t) }
+
+func (s *ControllerSuite) TestPoliciesDiscoveredForSelectedPVCs(c *C) {
+	ctrl := controller.NewSnapshotPolicyController(
+		newFakeDynamicClient(createFakeSnapshotPolicy("kafka", "kafka-data", "kafka")),
+		newFakeKubeClient(),
+		discardLogger)
+
+	configs, err := ctrl.Discover()
+
+	c.Assert(err, IsNil)
+	c.Assert(configs, DeepEquals, models.VolumeSnapshotConfigs{
+		{
+			Name:                 "snapshotpolicy/kafka/kafka-data",
+			VolumeID:             "vol-1",
+			IntervalSeconds:      43200,
+			RetentionPeriodHours: 336,
+		},
+	})
+}
+
+func (s *ControllerSuite) TestStatusWrittenBackToPolicy(c *C) {
+	dynamicClient := newFakeDynamicClient(createFakeSnapshotPolicy("kafka", "kafka-data", "kafka"))
+	ctrl := controller.NewSnapshotPolicyController(dynamicClient, newFakeKubeClient(), discardLogger)
+
+	_, err := ctrl.Discover()
+	c.Assert(err, IsNil)
+
+	latest := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = ctrl.UpdateStatus([]controller.TargetResult{{States: []watcher.VolumeState{
+		{
+			VolumeID:           "vol-1",
+			Policy:             "snapshotpolicy/kafka/kafka-data",
+			SnapshotCount:      3,
+			LatestSnapshotTime: &latest,
+		},
+		{
+			VolumeID:      "vol-9",
+			Policy:        "datadir-kafka-0",
+			SnapshotCount: 5,
+		},
+	}}})
+	c.Assert(err, IsNil)
+
+	status := getFakeSnapshotPolicyStatus(c, dynamicClient, "kafka", "kafka-data")
+	c.Assert(status.Volumes, Equals, 1)
+	c.Assert(status.SnapshotCount, Equals, 3)
+	c.Assert(status.LastSnapshotTime.Time.Equal(latest), Equals, true)
+	c.Assert(status.LastError, Equals, "")
+	c.Assert(status.Conditions[0].Type, Equals, v1alpha1.ConditionReady)
+	c.Assert(status.Conditions[0].Status, Equals, metav1.ConditionTrue)
+}
+
+func (s *ControllerSuite) TestTargetErrorOnlyReportedOnPoliciesInTarget(c *C) {
+	dynamicClient := newFakeDynamicClient(
+		createFakeSnapshotPolicy("kafka", "kafka-data", "kafka"),
+		createFakeSnapshotPolicy("kafka", "zookeeper-data", "zookeeper"))
+	ctrl := controller.NewSnapshotPolicyController(dynamicClient, newFakeKubeClient(), discardLogger)
+
+	_, err := ctrl.Discover()
+	c.Assert(err, IsNil)
+
+	err = ctrl.UpdateStatus([]controller.TargetResult{
+		{
+			States: []watcher.VolumeState{{VolumeID: "vol-1", Policy: "snapshotpolicy/kafka/kafka-data"}},
+			Err:    errors.New("error while fetching volumes"),
+		},
+		{
+			States: []watcher.VolumeState{{VolumeID: "vol-2", Policy: "snapshotpolicy/kafka/zookeeper-data"}},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	status := getFakeSnapshotPolicyStatus(c, dynamicClient, "kafka", "kafka-data")
+	c.Assert(status.LastError, Equals, "error while fetching volumes")
+	c.Assert(status.Conditions[0].Status, Equals, metav1.ConditionFalse)
+	status = getFakeSnapshotPolicyStatus(c, dynamicClient, "kafka", "zookeeper-data")
+	c.Assert(status.LastError, Equals, "")
+	c.Assert(status.Conditions[0].Status, Equals, metav1.ConditionTrue)
+}
+
+func (s *ControllerSuite) TestVolumeErrorOnlyReportedOnItsPolicy(c *C) {
+	dynamicClient := newFakeDynamicClient(
+		createFakeSnapshotPolicy("kafka", "kafka-data", "kafka"),
+		createFakeSnapshotPolicy("kafka", "zookeeper-data", "zookeeper"))
+	ctrl := controller.NewSnapshotPolicyController(dynamicClient, newFakeKubeClient(), discardLogger)
+
+	_, err := ctrl.Discover()
+	c.Assert(err, IsNil)
+
+	err = ctrl.UpdateStatus([]controller.TargetResult{{States: []watcher.VolumeState{
+		{VolumeID: "vol-1", Policy: "snapshotpolicy/kafka/kafka-data", LastError: "pre hook failed"},
+		{VolumeID: "vol-2", Policy: "snapshotpolicy/kafka/zookeeper-data"},
+	}}})
+	c.Assert(err, IsNil)
+
+	status := getFakeSnapshotPolicyStatus(c, dynamicClient, "kafka", "kafka-data")
+	c.Assert(status.LastError, Equals, "volume vol-1: pre hook failed")
+	status = getFakeSnapshotPolicyStatus(c, dynamicClient, "kafka", "zookeeper-data")
+	c.Assert(status.LastError, Equals, "")
+}
+
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.SnapshotPolicyResource: "SnapshotPolicyList"},
+		objects...)
+}
+
+func newFakeKubeClient() *fake.Clientset {
+	return fake.NewSimpleClientset(
+		createFakePVC("kafka", "datadir-kafka-0", "pv-1", "kafka"),
+		createFakePVC("kafka", "datadir-zookeeper-0", "pv-2", "zookeeper"),
+		createFakePVC("other", "datadir-kafka-0", "pv-3", "kafka"),
+		createFakePV("pv-1", "vol-1"),
+		createFakePV("pv-2", "vol-2"),
+		createFakePV("pv-3", "vol-3"),
+	)
+}
+
+func createFakeSnapshotPolicy(namespace, name, app string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": v1alpha1.GroupName + "/" + v1alpha1.Version,
+			"kind":       "SnapshotPolicy",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+			},
+			"spec": map[string]interface{}{
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{"app": app},
+				},
+				"intervalSeconds":      int64(43200),
+				"retentionPeriodHours": int64(336),
+			},
+		},
+	}
+}
+
+func getFakeSnapshotPolicyStatus(c *C, client *dynamicfake.FakeDynamicClient, namespace, name string) v1alpha1.SnapshotPolicyStatus {
+	obj, err := client.Resource(v1alpha1.SnapshotPolicyResource).
+		Namespace(namespace).
+		Get(context.Background(), name, metav1.GetOptions{})
+	c.Assert(err, IsNil)
+
+	policy := &v1alpha1.SnapshotPolicy{}
+	c.Assert(runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, policy), IsNil)
+	return policy.Status
+}
+
+func createFakePVC(namespace, name, volumeName, app string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"app": app},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			VolumeName: volumeName,
+		},
+	}
+}
+
+func createFakePV(name, volumeID string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: "ebs.csi.aws.com", VolumeHandle: volumeID},
+			},
+		},
+	}
+}
@@ -0,0 +1,141 @@
+package ebsevents
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+)
+
+const (
+	metricsNamespace = "ebs_snapshotter"
+
+	// waitTimeSeconds is the long polling wait of a receive, the longest SQS allows
+	waitTimeSeconds = 20
+	// receiveErrorDelay is waited after failing to receive messages, so that errors don't spin
+	receiveErrorDelay = 5 * time.Second
+)
+
+// Handler used to act on an EBS event. Messages of events that fail to be handled are left in the
+// queue, so that they are received again once their visibility timeout expires.
+type Handler func(ctx context.Context, event *Event) error
+
+// Metrics used to store the metrics of the event consumer
+type Metrics struct {
+	Events *prometheus.CounterVec
+	Errors *prometheus.CounterVec
+}
+
+// NewMetrics used to create the event consumer metrics and register them with given registry
+func NewMetrics(registry prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		Events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "ebs_events_total",
+			Help:      "A counter of the total number of EBS events received, by action and result",
+		}, []string{"action", "result"}),
+		Errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "ebs_event_errors_total",
+			Help:      "A counter of the total number of errors while consuming EBS events, by operation",
+		}, []string{"operation"}),
+	}
+	registry.MustRegister(m.Events, m.Errors)
+	return m
+}
+
+// Consumer used to receive EBS events from an SQS queue
+type Consumer struct {
+	client   sqsiface.SQSAPI
+	queueURL string
+	handler  Handler
+	metrics  *Metrics
+	logger   *slog.Logger
+}
+
+// NewConsumer used to create a consumer of the EBS events in given queue
+func NewConsumer(client sqsiface.SQSAPI, queueURL string, handler Handler, metrics *Metrics, logger *slog.Logger) *Consumer {
+	return &Consumer{
+		client:   client,
+		queueURL: queueURL,
+		handler:  handler,
+		metrics:  metrics,
+		logger:   logger,
+	}
+}
+
+// Run used to receive and handle events until the context is done
+func (c *Consumer) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := c.Poll(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Error("failed to receive EBS events", logging.Error, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(receiveErrorDelay):
+			}
+		}
+	}
+}
+
+// Poll used to receive a batch of messages, waiting for one when the queue is empty, and to handle
+// them. Messages that aren't EBS events are removed, as they would never be handled.
+func (c *Consumer) Poll(ctx context.Context) error {
+	out, err := c.client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(c.queueURL),
+		MaxNumberOfMessages: aws.Int64(10),
+		WaitTimeSeconds:     aws.Int64(waitTimeSeconds),
+	})
+	if err != nil {
+		c.metrics.Errors.WithLabelValues("receive").Inc()
+		return errors.Wrap(err, "error while receiving messages")
+	}
+
+	for _, message := range out.Messages {
+		event, err := Parse([]byte(aws.StringValue(message.Body)))
+		if err != nil {
+			c.metrics.Errors.WithLabelValues("parse").Inc()
+			c.logger.Warn("dropped message that isn't an EBS event",
+				"message_id", aws.StringValue(message.MessageId),
+				logging.Error, err)
+			c.delete(ctx, message)
+			continue
+		}
+
+		c.metrics.Events.WithLabelValues(event.Action, event.Result).Inc()
+		logger := c.logger.With(
+			"event_id", event.ID,
+			"event_action", event.Action,
+			"event_result", event.Result,
+			logging.VolumeID, event.VolumeID,
+			logging.SnapshotID, event.SnapshotID)
+		if err := c.handler(ctx, event); err != nil {
+			c.metrics.Errors.WithLabelValues("handle").Inc()
+			logger.Error("failed to handle EBS event, it will be received again", logging.Error, err)
+			continue
+		}
+		logger.Debug("handled EBS event")
+		c.delete(ctx, message)
+	}
+	return nil
+}
+
+func (c *Consumer) delete(ctx context.Context, message *sqs.Message) {
+	if _, err := c.client.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.queueURL),
+		ReceiptHandle: message.ReceiptHandle,
+	}); err != nil {
+		c.metrics.Errors.WithLabelValues("delete").Inc()
+		c.logger.Error("failed to delete message",
+			"message_id", aws.StringValue(message.MessageId),
+			logging.Error, err)
+	}
+}
@@ -0,0 +1,230 @@
+package ebsevents_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/utilitywarehouse/ebs-snapshotter/ebsevents"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&EventsSuite{})
+
+type EventsSuite struct{}
+
+func TestEvents(t *testing.T) { TestingT(t) }
+
+const snapshotFailedEvent = `{
+  "version": "0",
+  "id": "01234567-0123-0123-0123-012345678901",
+  "detail-type": "EBS Snapshot Notification",
+  "source": "aws.ec2",
+  "account": "012345678901",
+  "time": "2021-01-02T15:04:05Z",
+  "region": "eu-west-1",
+  "resources": ["arn:aws:ec2::eu-west-1:snapshot/snap-01234567"],
+  "detail": {
+    "event": "createSnapshot",
+    "result": "failed",
+    "cause": "Incorrect state",
+    "request-id": "",
+    "snapshot_id": "arn:aws:ec2::eu-west-1:snapshot/snap-01234567",
+    "source": "arn:aws:ec2::eu-west-1:volume/vol-01234567",
+    "startTime": "2021-01-02T15:04:05Z",
+    "endTime": "2021-01-02T15:04:05Z"
+  }
+}`
+
+const volumeDeletedEvent = `{
+  "version": "0",
+  "id": "01234567-0123-0123-0123-012345678902",
+  "detail-type": "EBS Volume Notification",
+  "source": "aws.ec2",
+  "account": "012345678901",
+  "time": "2021-01-02T15:04:05Z",
+  "region": "eu-west-1",
+  "resources": ["arn:aws:ec2:eu-west-1:012345678901:volume/vol-01234567"],
+  "detail": {
+    "result": "deleted",
+    "cause": "",
+    "event": "deleteVolume",
+    "request-id": "01234567-0123-0123-0123-0123456789ab"
+  }
+}`
+
+const copySucceededEvent = `{
+  "version": "0",
+  "id": "01234567-0123-0123-0123-012345678903",
+  "detail-type": "EBS Snapshot Notification",
+  "source": "aws.ec2",
+  "account": "012345678901",
+  "time": "2021-01-02T15:04:05Z",
+  "region": "eu-central-1",
+  "resources": ["arn:aws:ec2::eu-central-1:snapshot/snap-76543210"],
+  "detail": {
+    "event": "copySnapshot",
+    "result": "succeeded",
+    "snapshot_id": "arn:aws:ec2::eu-central-1:snapshot/snap-76543210",
+    "source": "arn:aws:ec2::eu-west-1:snapshot/snap-01234567"
+  }
+}`
+
+var logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+
+func (s *EventsSuite) TestSnapshotEventParsed(c *C) {
+	event, err := ebsevents.Parse([]byte(snapshotFailedEvent))
+
+	c.Assert(err, IsNil)
+	c.Assert(event.DetailType, Equals, ebsevents.SnapshotNotification)
+	c.Assert(event.Region, Equals, "eu-west-1")
+	c.Assert(event.Account, Equals, "012345678901")
+	c.Assert(event.Action, Equals, "createSnapshot")
+	c.Assert(event.Result, Equals, "failed")
+	c.Assert(event.Cause, Equals, "Incorrect state")
+	c.Assert(event.VolumeID, Equals, "vol-01234567")
+	c.Assert(event.SnapshotID, Equals, "snap-01234567")
+	c.Assert(event.Reconcile(), Equals, true)
+}
+
+func (s *EventsSuite) TestVolumeEventParsed(c *C) {
+	event, err := ebsevents.Parse([]byte(volumeDeletedEvent))
+
+	c.Assert(err, IsNil)
+	c.Assert(event.DetailType, Equals, ebsevents.VolumeNotification)
+	c.Assert(event.Action, Equals, "deleteVolume")
+	c.Assert(event.Result, Equals, "deleted")
+	c.Assert(event.VolumeID, Equals, "vol-01234567")
+	c.Assert(event.Reconcile(), Equals, true)
+}
+
+func (s *EventsSuite) TestCopyEventNotReconciled(c *C) {
+	event, err := ebsevents.Parse([]byte(copySucceededEvent))
+
+	c.Assert(err, IsNil)
+	c.Assert(event.VolumeID, Equals, "")
+	c.Assert(event.SnapshotID, Equals, "snap-76543210")
+	c.Assert(event.Reconcile(), Equals, false)
+}
+
+func (s *EventsSuite) TestOtherEventsRefused(c *C) {
+	_, err := ebsevents.Parse([]byte(`{"source": "aws.s3", "detail-type": "Object Created", "detail": {}}`))
+	c.Assert(err, NotNil)
+
+	_, err = ebsevents.Parse([]byte(`{"source": "aws.ec2", "detail-type": "EC2 Instance State-change Notification", "detail": {}}`))
+	c.Assert(err, NotNil)
+
+	_, err = ebsevents.Parse([]byte(`not json`))
+	c.Assert(err, NotNil)
+}
+
+func (s *EventsSuite) TestHandledMessagesDeletedAndFailedOnesKept(c *C) {
+	queue := newFakeQueue(snapshotFailedEvent, volumeDeletedEvent, "not json")
+	metrics := ebsevents.NewMetrics(prometheus.NewRegistry())
+	handled := make([]string, 0)
+	handler := func(ctx context.Context, event *ebsevents.Event) error {
+		handled = append(handled, event.Action)
+		if event.Action == "deleteVolume" {
+			return errors.New("throttled")
+		}
+		return nil
+	}
+	consumer := ebsevents.NewConsumer(queue, "https://sqs.eu-west-1.amazonaws.com/012345678901/ebs-events", handler, metrics, logger)
+
+	c.Assert(consumer.Poll(context.Background()), IsNil)
+
+	c.Assert(handled, DeepEquals, []string{"createSnapshot", "deleteVolume"})
+	// The failed event is left for redelivery, the message that isn't an event is dropped
+	c.Assert(queue.remaining(), DeepEquals, []string{volumeDeletedEvent})
+	c.Assert(testutil.ToFloat64(metrics.Events.WithLabelValues("createSnapshot", "failed")), Equals, float64(1))
+	c.Assert(testutil.ToFloat64(metrics.Errors.WithLabelValues("parse")), Equals, float64(1))
+	c.Assert(testutil.ToFloat64(metrics.Errors.WithLabelValues("handle")), Equals, float64(1))
+}
+
+func (s *EventsSuite) TestReceiveErrorReturned(c *C) {
+	queue := newFakeQueue()
+	queue.receiveErr = errors.New("access denied")
+	consumer := ebsevents.NewConsumer(queue, "queue", func(ctx context.Context, event *ebsevents.Event) error {
+		return nil
+	}, ebsevents.NewMetrics(prometheus.NewRegistry()), logger)
+
+	c.Assert(consumer.Poll(context.Background()), NotNil)
+}
+
+// fakeQueue is a local stand-in for an SQS queue, keeping messages in memory until deleted
+type fakeQueue struct {
+	sqsiface.SQSAPI
+
+	mu         sync.Mutex
+	messages   map[string]string
+	order      []string
+	receiveErr error
+}
+
+func newFakeQueue(bodies ...string) *fakeQueue {
+	q := &fakeQueue{messages: make(map[string]string)}
+	for i, body := range bodies {
+		handle := "handle-" + strconv.Itoa(i)
+		q.messages[handle] = body
+		q.order = append(q.order, handle)
+	}
+	return q
+}
+
+func (q *fakeQueue) ReceiveMessageWithContext(
+	ctx aws.Context,
+	input *sqs.ReceiveMessageInput,
+	opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.receiveErr != nil {
+		return nil, q.receiveErr
+	}
+	out := &sqs.ReceiveMessageOutput{}
+	for _, handle := range q.order {
+		body, ok := q.messages[handle]
+		if !ok || int64(len(out.Messages)) >= aws.Int64Value(input.MaxNumberOfMessages) {
+			continue
+		}
+		out.Messages = append(out.Messages, &sqs.Message{
+			MessageId:     aws.String("id-" + handle),
+			ReceiptHandle: aws.String(handle),
+			Body:          aws.String(body),
+		})
+	}
+	return out, nil
+}
+
+func (q *fakeQueue) DeleteMessageWithContext(
+	ctx aws.Context,
+	input *sqs.DeleteMessageInput,
+	opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.messages, aws.StringValue(input.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (q *fakeQueue) remaining() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	bodies := make([]string, 0)
+	for _, handle := range q.order {
+		if body, ok := q.messages[handle]; ok {
+			bodies = append(bodies, body)
+		}
+	}
+	return bodies
+}
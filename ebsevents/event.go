@@ -0,0 +1,121 @@
+// Package ebsevents consumes the EBS events EventBridge delivers to an SQS queue, so that volumes can
+// be reconciled as soon as a snapshot completes or fails, or a volume is created or deleted
+package ebsevents
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Detail types of the EBS events handled
+const (
+	SnapshotNotification = "EBS Snapshot Notification"
+	VolumeNotification   = "EBS Volume Notification"
+)
+
+// Event used to store the fields of an EBS event needed to reconcile the volume it is about
+type Event struct {
+	ID         string
+	DetailType string
+	Region     string
+	Account    string
+	Time       time.Time
+	// Action is the EC2 action reported, such as createSnapshot, createVolume or deleteVolume
+	Action string
+	// Result is the outcome of the action, such as succeeded, failed, available or deleted
+	Result     string
+	Cause      string
+	VolumeID   string
+	SnapshotID string
+}
+
+// envelope is the EventBridge event, as delivered to SQS by a rule target
+type envelope struct {
+	ID         string          `json:"id"`
+	DetailType string          `json:"detail-type"`
+	Source     string          `json:"source"`
+	Account    string          `json:"account"`
+	Time       time.Time       `json:"time"`
+	Region     string          `json:"region"`
+	Resources  []string        `json:"resources"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+type detail struct {
+	Event      string `json:"event"`
+	Result     string `json:"result"`
+	Cause      string `json:"cause"`
+	SnapshotID string `json:"snapshot_id"`
+	Source     string `json:"source"`
+}
+
+// Parse used to decode an EBS event delivered by EventBridge. Snapshot events carry the volume in
+// their `source` field, volume events carry it in their resources. Events of other sources and
+// detail types are refused.
+func Parse(body []byte) (*Event, error) {
+	env := &envelope{}
+	if err := json.Unmarshal(body, env); err != nil {
+		return nil, errors.Wrap(err, "error while decoding event")
+	}
+	if env.Source != "aws.ec2" {
+		return nil, errors.Errorf("unexpected event source %s", env.Source)
+	}
+	d := &detail{}
+	if err := json.Unmarshal(env.Detail, d); err != nil {
+		return nil, errors.Wrap(err, "error while decoding event detail")
+	}
+
+	event := &Event{
+		ID:         env.ID,
+		DetailType: env.DetailType,
+		Region:     env.Region,
+		Account:    env.Account,
+		Time:       env.Time,
+		Action:     d.Event,
+		Result:     d.Result,
+		Cause:      d.Cause,
+	}
+	switch env.DetailType {
+	case SnapshotNotification:
+		event.SnapshotID = resourceID(d.SnapshotID)
+		// The source of a copy is a snapshot rather than a volume
+		if id := resourceID(d.Source); strings.HasPrefix(id, "vol-") {
+			event.VolumeID = id
+		}
+	case VolumeNotification:
+		for _, resource := range env.Resources {
+			if id := resourceID(resource); strings.HasPrefix(id, "vol-") {
+				event.VolumeID = id
+			}
+		}
+	default:
+		return nil, errors.Errorf("unexpected event detail type %s", env.DetailType)
+	}
+	return event, nil
+}
+
+// Reconcile used to check whether the event calls for reconciling its volume: a snapshot of it
+// completed or failed, or it was created or deleted
+func (e *Event) Reconcile() bool {
+	if e.VolumeID == "" {
+		return false
+	}
+	switch e.Action {
+	case "createSnapshot", "createSnapshots":
+		return e.Result == "succeeded" || e.Result == "failed"
+	case "createVolume":
+		return e.Result == "available"
+	case "deleteVolume":
+		return e.Result == "deleted"
+	default:
+		return false
+	}
+}
+
+// resourceID used to obtain the ID at the end of an ARN, such as `arn:aws:ec2::eu-west-1:volume/vol-1`
+func resourceID(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
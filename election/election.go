@@ -0,0 +1,101 @@
+// Package election elects a single replica as leader, so that only one replica creates and removes snapshots
+package election
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "ebs_snapshotter"
+
+// Elector interface specifies functions used to campaign for leadership and check whether this replica leads
+type Elector interface {
+	// Run used to campaign for leadership until the context is done, which releases leadership
+	Run(ctx context.Context) error
+	// Leading used to obtain whether this replica leads, and a context that is done once it stops leading
+	Leading() (context.Context, bool)
+}
+
+// Metrics used to store the leadership metrics
+type Metrics struct {
+	Leader            prometheus.Gauge
+	LeadershipChanges prometheus.Counter
+}
+
+// NewMetrics used to create the leadership metrics and register them with given registry
+func NewMetrics(registry prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		Leader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "leader",
+			Help:      "Whether this replica is the leader, which creates and removes snapshots",
+		}),
+		LeadershipChanges: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "leadership_changes_total",
+			Help:      "A counter of the total number of times this replica started or stopped leading",
+		}),
+	}
+	registry.MustRegister(m.Leader, m.LeadershipChanges)
+	return m
+}
+
+// leadership used to track whether this replica leads, shared by the electors
+type leadership struct {
+	identity string
+	metrics  *Metrics
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	leading bool
+}
+
+func newLeadership(identity string, metrics *Metrics, logger *slog.Logger) *leadership {
+	return &leadership{identity: identity, metrics: metrics, logger: logger}
+}
+
+// start used to record that this replica started leading, until the returned context is done
+func (l *leadership) start(parent context.Context) context.Context {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.leading {
+		return l.ctx
+	}
+	l.ctx, l.cancel = context.WithCancel(parent)
+	l.leading = true
+	l.metrics.Leader.Set(1)
+	l.metrics.LeadershipChanges.Inc()
+	l.logger.Info("started leading", "identity", l.identity)
+	return l.ctx
+}
+
+// stop used to record that this replica stopped leading, cancelling the context of its leadership
+func (l *leadership) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.leading {
+		return
+	}
+	l.cancel()
+	l.leading = false
+	l.metrics.Leader.Set(0)
+	l.metrics.LeadershipChanges.Inc()
+	l.logger.Info("stopped leading", "identity", l.identity)
+}
+
+func (l *leadership) Leading() (context.Context, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.leading {
+		return nil, false
+	}
+	return l.ctx, true
+}
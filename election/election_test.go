@@ -0,0 +1,88 @@
+package election_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/utilitywarehouse/ebs-snapshotter/election"
+	. "gopkg.in/check.v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Suite(&ElectionSuite{})
+
+type ElectionSuite struct{}
+
+func TestElection(t *testing.T) { TestingT(t) }
+
+var logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+
+func (s *ElectionSuite) TestFileLockHeldByOneReplica(c *C) {
+	path := filepath.Join(c.MkDir(), "ebs-snapshotter.lock")
+	firstMetrics := election.NewMetrics(prometheus.NewRegistry())
+	first := election.NewFileElector(path, "first", 10*time.Millisecond, firstMetrics, logger)
+	second := election.NewFileElector(path, "second", 10*time.Millisecond, election.NewMetrics(prometheus.NewRegistry()), logger)
+
+	assertTakeover(c, first, second)
+	c.Assert(testutil.ToFloat64(firstMetrics.Leader), Equals, float64(0))
+	c.Assert(testutil.ToFloat64(firstMetrics.LeadershipChanges), Equals, float64(2))
+}
+
+func (s *ElectionSuite) TestLeaseHeldByOneReplica(c *C) {
+	client := fake.NewSimpleClientset()
+	metrics := election.NewMetrics(prometheus.NewRegistry())
+	first, err := election.NewLeaseElector(client, "kube-system", "ebs-snapshotter", "first", metrics, logger)
+	c.Assert(err, IsNil)
+	second, err := election.NewLeaseElector(
+		client, "kube-system", "ebs-snapshotter", "second", election.NewMetrics(prometheus.NewRegistry()), logger)
+	c.Assert(err, IsNil)
+
+	assertTakeover(c, first, second)
+	c.Assert(testutil.ToFloat64(metrics.Leader), Equals, float64(0))
+}
+
+// assertTakeover used to check that only the first elector leads, and that the second one takes over
+// once the first one shuts down
+func assertTakeover(c *C, first, second election.Elector) {
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		c.Check(first.Run(firstCtx), IsNil)
+		close(firstDone)
+	}()
+	leaderCtx := waitForLeadership(c, first)
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	go second.Run(secondCtx)
+	time.Sleep(100 * time.Millisecond)
+	_, leading := second.Leading()
+	c.Assert(leading, Equals, false)
+
+	stopFirst()
+	<-firstDone
+	c.Assert(leaderCtx.Err(), NotNil)
+	_, leading = first.Leading()
+	c.Assert(leading, Equals, false)
+	waitForLeadership(c, second)
+}
+
+func waitForLeadership(c *C, elector election.Elector) context.Context {
+	timeout := time.After(10 * time.Second)
+	for {
+		if ctx, leading := elector.Leading(); leading {
+			return ctx
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timed out waiting for leadership")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
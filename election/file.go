@@ -0,0 +1,62 @@
+package election
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type fileElector struct {
+	*leadership
+	path        string
+	retryPeriod time.Duration
+}
+
+// NewFileElector used to create an elector holding an exclusive lock on given file while leading,
+// for replicas sharing a host or a file system with working locks
+func NewFileElector(path, identity string, retryPeriod time.Duration, metrics *Metrics, logger *slog.Logger) Elector {
+	return &fileElector{
+		leadership:  newLeadership(identity, metrics, logger),
+		path:        path,
+		retryPeriod: retryPeriod,
+	}
+}
+
+// Run used to try to lock the file until it succeeds, then hold the lock until the context is done
+func (e *fileElector) Run(ctx context.Context) error {
+	file, err := os.OpenFile(e.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "error while opening lock file %s", e.path)
+	}
+	// Closing the file releases the lock
+	defer file.Close()
+
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			return errors.Wrapf(err, "error while locking %s", e.path)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(e.retryPeriod):
+		}
+	}
+
+	// The holder is written for whoever inspects the file, it isn't read by the electors
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(e.identity+"\n"), 0)
+	}
+
+	e.start(ctx)
+	<-ctx.Done()
+	e.stop()
+	return nil
+}
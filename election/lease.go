@@ -0,0 +1,75 @@
+package election
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+type leaseElector struct {
+	*leadership
+	config leaderelection.LeaderElectionConfig
+}
+
+// NewLeaseElector used to create an elector holding a Kubernetes Lease in given namespace while
+// leading. The identity, usually the pod name, must be unique among the replicas.
+func NewLeaseElector(
+	client kubernetes.Interface,
+	namespace, name, identity string,
+	metrics *Metrics,
+	logger *slog.Logger) (Elector, error) {
+
+	e := &leaseElector{leadership: newLeadership(identity, metrics, logger)}
+	e.config = leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Client:     client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		// The lease is released on shutdown, so that another replica takes over without waiting for it to expire
+		ReleaseOnCancel: true,
+		Name:            name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) { e.start(ctx) },
+			OnStoppedLeading: e.stop,
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					logger.Info("another replica is leading", "leader", leader)
+				}
+			},
+		},
+	}
+	// Validate the config up front, so that Run only fails when the context is done
+	if _, err := leaderelection.NewLeaderElector(e.config); err != nil {
+		return nil, errors.Wrap(err, "error while creating leader elector")
+	}
+	return e, nil
+}
+
+// Run used to campaign for the lease, again after losing it, until the context is done
+func (e *leaseElector) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		elector, err := leaderelection.NewLeaderElector(e.config)
+		if err != nil {
+			return errors.Wrap(err, "error while creating leader elector")
+		}
+		// Run returns once leadership is lost or the context is done
+		elector.Run(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,825 @@
+// Package fakeec2 provides an in-memory implementation of the parts of the EC2 API used by
+// ebs-snapshotter, so that EBS clients and watchers can be tested end to end.
+package fakeec2
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/utilitywarehouse/ebs-snapshotter/clock"
+)
+
+// Operation names, as used to inject errors and record calls
+const (
+	DescribeVolumes         = "DescribeVolumes"
+	DescribeSnapshots       = "DescribeSnapshots"
+	CreateSnapshot          = "CreateSnapshot"
+	DeleteSnapshot          = "DeleteSnapshot"
+	CopySnapshot            = "CopySnapshot"
+	ModifySnapshotAttribute = "ModifySnapshotAttribute"
+	CreateVolume            = "CreateVolume"
+	CreateTags              = "CreateTags"
+	DeleteTags              = "DeleteTags"
+)
+
+// Error codes returned by the fake, matching those of EC2
+const (
+	ErrCodeThrottled            = "RequestLimitExceeded"
+	ErrCodeVolumeNotFound       = "InvalidVolume.NotFound"
+	ErrCodeSnapshotNotFound     = "InvalidSnapshot.NotFound"
+	ErrCodeSnapshotInUse        = "InvalidSnapshot.InUse"
+	ErrCodeIncorrectState       = "IncorrectState"
+	ErrCodeInvalidParameter     = "InvalidParameterValue"
+	ErrCodeInvalidPaginationKey = "InvalidPaginationToken"
+)
+
+// copyVolumeID is the volume ID EC2 gives snapshot copies, which aren't created from a volume
+const copyVolumeID = "vol-ffffffff"
+
+type snapshot struct {
+	snapshot   *ec2.Snapshot
+	inUseBy    string
+	sharedWith []string
+}
+
+type injectedError struct {
+	operation string
+	err       error
+}
+
+// EC2 used to emulate the EC2 API for EBS volumes and snapshots of a single region and account.
+// Operations not used by ebs-snapshotter are not implemented and panic when called.
+type EC2 struct {
+	ec2iface.EC2API
+
+	region    string
+	accountID string
+
+	mu sync.Mutex
+	// completionDelay is how long created and copied snapshots stay pending
+	completionDelay time.Duration
+	// pageSize, when set, limits the results of describe calls regardless of MaxResults
+	pageSize  int
+	clock     clock.Clock
+	nextID    int
+	volumes   map[string]*ec2.Volume
+	snapshots map[string]*snapshot
+	regions   map[string]*EC2
+	errors    []injectedError
+	calls     []string
+}
+
+// New used to create a new fake EC2 API for given region and account, without any volumes or snapshots
+func New(region, accountID string) *EC2 {
+	return &EC2{
+		region:    region,
+		accountID: accountID,
+		clock:     clock.Real(),
+		volumes:   make(map[string]*ec2.Volume),
+		snapshots: make(map[string]*snapshot),
+		regions:   make(map[string]*EC2),
+	}
+}
+
+// SetCompletionDelay used to keep created and copied snapshots pending for given duration.
+// By default they are completed by the time they are next described.
+func (f *EC2) SetCompletionDelay(delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.completionDelay = delay
+}
+
+// SetClock used to replace the system clock that start times and completion are based on
+func (f *EC2) SetClock(clk clock.Clock) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clock = clk
+}
+
+// SetPageSize used to limit the number of results of each describe call, to exercise pagination
+func (f *EC2) SetPageSize(size int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pageSize = size
+}
+
+// LinkRegion used to make the snapshots of another fake available as sources of copies. Snapshots
+// of another account are only available once shared with this fake's account.
+func (f *EC2) LinkRegion(other *EC2) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.regions[other.region] = other
+}
+
+// AddVolume used to add a volume. Its ID is generated when not set, and it defaults to an
+// in-use gp3 volume in the region's first availability zone. Returns the volume's ID.
+func (f *EC2) AddVolume(volume *ec2.Volume) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v := copyVolume(volume)
+	if v.VolumeId == nil {
+		v.VolumeId = aws.String(f.newID("vol"))
+	}
+	if v.State == nil {
+		v.State = aws.String(ec2.VolumeStateInUse)
+	}
+	if v.AvailabilityZone == nil {
+		v.AvailabilityZone = aws.String(f.region + "a")
+	}
+	if v.VolumeType == nil {
+		v.VolumeType = aws.String(ec2.VolumeTypeGp3)
+	}
+	if v.Size == nil {
+		v.Size = aws.Int64(1)
+	}
+	if v.CreateTime == nil {
+		v.CreateTime = aws.Time(f.clock.Now())
+	}
+	f.volumes[*v.VolumeId] = v
+	return *v.VolumeId
+}
+
+// RemoveVolume used to delete a volume, leaving its snapshots in place
+func (f *EC2) RemoveVolume(volumeID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.volumes, volumeID)
+}
+
+// AddSnapshot used to add an existing snapshot, such as one older than a retention period.
+// Its ID is generated when not set, and it defaults to a completed snapshot owned by the account.
+// Returns the snapshot's ID.
+func (f *EC2) AddSnapshot(s *ec2.Snapshot) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snap := copySnapshot(s)
+	if snap.SnapshotId == nil {
+		snap.SnapshotId = aws.String(f.newID("snap"))
+	}
+	if snap.State == nil {
+		snap.State = aws.String(ec2.SnapshotStateCompleted)
+		snap.Progress = aws.String("100%")
+	}
+	if snap.OwnerId == nil {
+		snap.OwnerId = aws.String(f.accountID)
+	}
+	if snap.StartTime == nil {
+		snap.StartTime = aws.Time(f.clock.Now())
+	}
+	f.snapshots[*snap.SnapshotId] = &snapshot{snapshot: snap}
+	return *snap.SnapshotId
+}
+
+// SetSnapshotState used to change the state of a snapshot, such as to fail it
+func (f *EC2) SetSnapshotState(snapshotID, state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.snapshots[snapshotID]; ok {
+		s.snapshot.State = aws.String(state)
+	}
+}
+
+// SetSnapshotInUse used to mark a snapshot as used by given AMI, which prevents its deletion.
+// An empty image ID releases the snapshot.
+func (f *EC2) SetSnapshotInUse(snapshotID, imageID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.snapshots[snapshotID]; ok {
+		s.inUseBy = imageID
+	}
+}
+
+// Volume used to obtain a copy of the volume with given ID, or nil if there is none
+func (f *EC2) Volume(volumeID string) *ec2.Volume {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if v, ok := f.volumes[volumeID]; ok {
+		return copyVolume(v)
+	}
+	return nil
+}
+
+// Snapshot used to obtain a copy of the snapshot with given ID, or nil if there is none
+func (f *EC2) Snapshot(snapshotID string) *ec2.Snapshot {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.snapshots[snapshotID]; ok {
+		f.complete(s)
+		return copySnapshot(s.snapshot)
+	}
+	return nil
+}
+
+// Snapshots used to obtain copies of the snapshots of given volume, oldest first.
+// All snapshots are returned when the volume ID is empty.
+func (f *EC2) Snapshots(volumeID string) []*ec2.Snapshot {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snapshots := make([]*ec2.Snapshot, 0)
+	for _, s := range f.sortedSnapshots() {
+		if volumeID == "" || aws.StringValue(s.snapshot.VolumeId) == volumeID {
+			f.complete(s)
+			snapshots = append(snapshots, copySnapshot(s.snapshot))
+		}
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].StartTime.Before(*snapshots[j].StartTime)
+	})
+	return snapshots
+}
+
+// SharedWith used to obtain the accounts a snapshot has been shared with
+func (f *EC2) SharedWith(snapshotID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.snapshots[snapshotID]; ok {
+		return append([]string(nil), s.sharedWith...)
+	}
+	return nil
+}
+
+// FailNext used to make the next call of given operation return given error. An empty
+// operation matches any. Errors are returned in the order they were injected, and a nil
+// error lets a call succeed so that a later one can be failed.
+func (f *EC2) FailNext(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors = append(f.errors, injectedError{operation: operation, err: err})
+}
+
+// Throttle used to make the next n calls of given operation fail as EC2 does when request
+// limits are exceeded. An empty operation matches any.
+func (f *EC2) Throttle(operation string, n int) {
+	for i := 0; i < n; i++ {
+		f.FailNext(operation, awserr.New(ErrCodeThrottled, "Request limit exceeded.", nil))
+	}
+}
+
+// Calls used to obtain the operations called so far, in order
+func (f *EC2) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// DescribeVolumesWithContext used to list volumes, supporting the volume IDs, the `volume-id`,
+// `status`, `tag-key` and `tag:<key>` filters and pagination
+func (f *EC2) DescribeVolumesWithContext(
+	ctx aws.Context, input *ec2.DescribeVolumesInput, _ ...request.Option) (*ec2.DescribeVolumesOutput, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, DescribeVolumes); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(f.volumes))
+	for id := range f.volumes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	volumes := make([]*ec2.Volume, 0)
+	for _, id := range ids {
+		v := f.volumes[id]
+		if len(input.VolumeIds) > 0 && !contains(aws.StringValueSlice(input.VolumeIds), id) {
+			continue
+		}
+		if !matchesFilters(input.Filters, map[string]string{
+			"volume-id": id,
+			"status":    aws.StringValue(v.State),
+		}, v.Tags) {
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	if len(input.VolumeIds) > 0 && len(volumes) < len(input.VolumeIds) {
+		return nil, awserr.New(ErrCodeVolumeNotFound, "The volume does not exist.", nil)
+	}
+
+	start, end, next, err := f.page(len(volumes), input.MaxResults, input.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	output := &ec2.DescribeVolumesOutput{Volumes: make([]*ec2.Volume, 0, end-start), NextToken: next}
+	for _, v := range volumes[start:end] {
+		output.Volumes = append(output.Volumes, copyVolume(v))
+	}
+	return output, nil
+}
+
+// DescribeSnapshotsWithContext used to list snapshots, supporting the snapshot and owner IDs,
+// the `snapshot-id`, `volume-id`, `status`, `tag-key` and `tag:<key>` filters and pagination
+func (f *EC2) DescribeSnapshotsWithContext(
+	ctx aws.Context, input *ec2.DescribeSnapshotsInput, _ ...request.Option) (*ec2.DescribeSnapshotsOutput, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, DescribeSnapshots); err != nil {
+		return nil, err
+	}
+
+	owners := aws.StringValueSlice(input.OwnerIds)
+	for i, owner := range owners {
+		if owner == "self" {
+			owners[i] = f.accountID
+		}
+	}
+
+	snapshots := make([]*snapshot, 0)
+	for _, s := range f.sortedSnapshots() {
+		id := *s.snapshot.SnapshotId
+		if len(input.SnapshotIds) > 0 && !contains(aws.StringValueSlice(input.SnapshotIds), id) {
+			continue
+		}
+		if len(owners) > 0 && !contains(owners, aws.StringValue(s.snapshot.OwnerId)) {
+			continue
+		}
+		f.complete(s)
+		if !matchesFilters(input.Filters, map[string]string{
+			"snapshot-id": id,
+			"volume-id":   aws.StringValue(s.snapshot.VolumeId),
+			"status":      aws.StringValue(s.snapshot.State),
+		}, s.snapshot.Tags) {
+			continue
+		}
+		snapshots = append(snapshots, s)
+	}
+	if len(input.SnapshotIds) > 0 && len(snapshots) < len(input.SnapshotIds) {
+		return nil, awserr.New(ErrCodeSnapshotNotFound, "The snapshot does not exist.", nil)
+	}
+
+	start, end, next, err := f.page(len(snapshots), input.MaxResults, input.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	output := &ec2.DescribeSnapshotsOutput{Snapshots: make([]*ec2.Snapshot, 0, end-start), NextToken: next}
+	for _, s := range snapshots[start:end] {
+		output.Snapshots = append(output.Snapshots, copySnapshot(s.snapshot))
+	}
+	return output, nil
+}
+
+// CreateSnapshotWithContext used to start a snapshot of a volume, tagged as given
+func (f *EC2) CreateSnapshotWithContext(
+	ctx aws.Context, input *ec2.CreateSnapshotInput, _ ...request.Option) (*ec2.Snapshot, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, CreateSnapshot); err != nil {
+		return nil, err
+	}
+
+	volume, ok := f.volumes[aws.StringValue(input.VolumeId)]
+	if !ok {
+		return nil, awserr.New(ErrCodeVolumeNotFound,
+			fmt.Sprintf("The volume '%s' does not exist.", aws.StringValue(input.VolumeId)), nil)
+	}
+
+	snap := &ec2.Snapshot{
+		SnapshotId:  aws.String(f.newID("snap")),
+		VolumeId:    volume.VolumeId,
+		VolumeSize:  volume.Size,
+		Description: input.Description,
+		Encrypted:   aws.Bool(aws.BoolValue(volume.Encrypted)),
+		KmsKeyId:    volume.KmsKeyId,
+		OwnerId:     aws.String(f.accountID),
+		StartTime:   aws.Time(f.clock.Now()),
+		State:       aws.String(ec2.SnapshotStatePending),
+		Progress:    aws.String("0%"),
+		Tags:        tagsFor(input.TagSpecifications, ec2.ResourceTypeSnapshot),
+	}
+	f.snapshots[*snap.SnapshotId] = &snapshot{snapshot: snap}
+	return copySnapshot(snap), nil
+}
+
+// DeleteSnapshotWithContext used to delete a snapshot, unless it's used by an AMI
+func (f *EC2) DeleteSnapshotWithContext(
+	ctx aws.Context, input *ec2.DeleteSnapshotInput, _ ...request.Option) (*ec2.DeleteSnapshotOutput, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, DeleteSnapshot); err != nil {
+		return nil, err
+	}
+
+	id := aws.StringValue(input.SnapshotId)
+	s, ok := f.snapshots[id]
+	if !ok {
+		return nil, awserr.New(ErrCodeSnapshotNotFound, fmt.Sprintf("The snapshot '%s' does not exist.", id), nil)
+	}
+	if s.inUseBy != "" {
+		return nil, awserr.New(ErrCodeSnapshotInUse,
+			fmt.Sprintf("The snapshot %s is currently in use by %s", id, s.inUseBy), nil)
+	}
+	delete(f.snapshots, id)
+	return &ec2.DeleteSnapshotOutput{}, nil
+}
+
+// CopySnapshotWithContext used to start copying a completed snapshot of this or a linked region
+func (f *EC2) CopySnapshotWithContext(
+	ctx aws.Context, input *ec2.CopySnapshotInput, _ ...request.Option) (*ec2.CopySnapshotOutput, error) {
+
+	f.mu.Lock()
+	if err := f.call(ctx, CopySnapshot); err != nil {
+		f.mu.Unlock()
+		return nil, err
+	}
+	id := aws.StringValue(input.SourceSnapshotId)
+	var other *EC2
+	if region := aws.StringValue(input.SourceRegion); region != "" && region != f.region {
+		var ok bool
+		if other, ok = f.regions[region]; !ok {
+			f.mu.Unlock()
+			return nil, awserr.New(ErrCodeInvalidParameter, fmt.Sprintf("Unknown source region %s.", region), nil)
+		}
+	}
+	f.mu.Unlock()
+
+	// A source in another region is looked up without holding this region's lock, as that region takes
+	// its own, so that two regions copying from each other don't deadlock
+	var sourceSnapshot *ec2.Snapshot
+	if other != nil {
+		sourceSnapshot = other.sharedSnapshot(id, f.accountID)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if other == nil {
+		if s, ok := f.snapshots[id]; ok {
+			f.complete(s)
+			sourceSnapshot = copySnapshot(s.snapshot)
+		}
+	}
+	if sourceSnapshot == nil {
+		return nil, awserr.New(ErrCodeSnapshotNotFound, fmt.Sprintf("The snapshot '%s' does not exist.", id), nil)
+	}
+	if aws.StringValue(sourceSnapshot.State) != ec2.SnapshotStateCompleted {
+		return nil, awserr.New(ErrCodeIncorrectState, fmt.Sprintf("Snapshot '%s' is not completed.", id), nil)
+	}
+
+	snap := &ec2.Snapshot{
+		SnapshotId:  aws.String(f.newID("snap")),
+		VolumeId:    aws.String(copyVolumeID),
+		VolumeSize:  sourceSnapshot.VolumeSize,
+		Description: input.Description,
+		Encrypted:   aws.Bool(aws.BoolValue(sourceSnapshot.Encrypted) || aws.BoolValue(input.Encrypted)),
+		KmsKeyId:    sourceSnapshot.KmsKeyId,
+		OwnerId:     aws.String(f.accountID),
+		StartTime:   aws.Time(f.clock.Now()),
+		State:       aws.String(ec2.SnapshotStatePending),
+		Progress:    aws.String("0%"),
+		Tags:        tagsFor(input.TagSpecifications, ec2.ResourceTypeSnapshot),
+	}
+	if input.KmsKeyId != nil {
+		snap.KmsKeyId = input.KmsKeyId
+	}
+	f.snapshots[*snap.SnapshotId] = &snapshot{snapshot: snap}
+	return &ec2.CopySnapshotOutput{SnapshotId: snap.SnapshotId}, nil
+}
+
+// ModifySnapshotAttributeWithContext used to add or remove accounts that may create volumes from a snapshot
+func (f *EC2) ModifySnapshotAttributeWithContext(
+	ctx aws.Context,
+	input *ec2.ModifySnapshotAttributeInput,
+	_ ...request.Option) (*ec2.ModifySnapshotAttributeOutput, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, ModifySnapshotAttribute); err != nil {
+		return nil, err
+	}
+
+	id := aws.StringValue(input.SnapshotId)
+	s, ok := f.snapshots[id]
+	if !ok {
+		return nil, awserr.New(ErrCodeSnapshotNotFound, fmt.Sprintf("The snapshot '%s' does not exist.", id), nil)
+	}
+	if aws.StringValue(input.Attribute) != ec2.SnapshotAttributeNameCreateVolumePermission {
+		return nil, awserr.New(ErrCodeInvalidParameter,
+			fmt.Sprintf("Unsupported attribute %s.", aws.StringValue(input.Attribute)), nil)
+	}
+	for _, account := range aws.StringValueSlice(input.UserIds) {
+		switch aws.StringValue(input.OperationType) {
+		case ec2.OperationTypeAdd:
+			if !contains(s.sharedWith, account) {
+				s.sharedWith = append(s.sharedWith, account)
+			}
+		case ec2.OperationTypeRemove:
+			for i, shared := range s.sharedWith {
+				if shared == account {
+					s.sharedWith = append(s.sharedWith[:i], s.sharedWith[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return &ec2.ModifySnapshotAttributeOutput{}, nil
+}
+
+// CreateVolumeWithContext used to create an available volume from a completed snapshot
+func (f *EC2) CreateVolumeWithContext(
+	ctx aws.Context, input *ec2.CreateVolumeInput, _ ...request.Option) (*ec2.Volume, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, CreateVolume); err != nil {
+		return nil, err
+	}
+
+	id := aws.StringValue(input.SnapshotId)
+	s, ok := f.snapshots[id]
+	if !ok {
+		return nil, awserr.New(ErrCodeSnapshotNotFound, fmt.Sprintf("The snapshot '%s' does not exist.", id), nil)
+	}
+	f.complete(s)
+	if aws.StringValue(s.snapshot.State) != ec2.SnapshotStateCompleted {
+		return nil, awserr.New(ErrCodeIncorrectState, fmt.Sprintf("Snapshot '%s' is not completed.", id), nil)
+	}
+
+	volume := &ec2.Volume{
+		VolumeId:         aws.String(f.newID("vol")),
+		SnapshotId:       s.snapshot.SnapshotId,
+		AvailabilityZone: input.AvailabilityZone,
+		Size:             s.snapshot.VolumeSize,
+		VolumeType:       aws.String(ec2.VolumeTypeGp3),
+		Iops:             input.Iops,
+		Throughput:       input.Throughput,
+		Encrypted:        s.snapshot.Encrypted,
+		KmsKeyId:         s.snapshot.KmsKeyId,
+		CreateTime:       aws.Time(f.clock.Now()),
+		State:            aws.String(ec2.VolumeStateAvailable),
+		Tags:             tagsFor(input.TagSpecifications, ec2.ResourceTypeVolume),
+	}
+	if input.VolumeType != nil {
+		volume.VolumeType = input.VolumeType
+	}
+	f.volumes[*volume.VolumeId] = volume
+
+	created := copyVolume(volume)
+	created.State = aws.String(ec2.VolumeStateCreating)
+	return created, nil
+}
+
+// CreateTagsWithContext used to add tags to volumes and snapshots, replacing the values of existing keys
+func (f *EC2) CreateTagsWithContext(
+	ctx aws.Context, input *ec2.CreateTagsInput, _ ...request.Option) (*ec2.CreateTagsOutput, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, CreateTags); err != nil {
+		return nil, err
+	}
+
+	for _, id := range aws.StringValueSlice(input.Resources) {
+		tags, err := f.tags(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range input.Tags {
+			*tags = setTag(*tags, aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// DeleteTagsWithContext used to remove tags from volumes and snapshots. Tags given with a value
+// are only removed when the value matches.
+func (f *EC2) DeleteTagsWithContext(
+	ctx aws.Context, input *ec2.DeleteTagsInput, _ ...request.Option) (*ec2.DeleteTagsOutput, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, DeleteTags); err != nil {
+		return nil, err
+	}
+
+	for _, id := range aws.StringValueSlice(input.Resources) {
+		tags, err := f.tags(id)
+		if err != nil {
+			return nil, err
+		}
+		kept := make([]*ec2.Tag, 0, len(*tags))
+		for _, tag := range *tags {
+			if !removesTag(input.Tags, tag) {
+				kept = append(kept, tag)
+			}
+		}
+		*tags = kept
+	}
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+// sharedSnapshot used to obtain a copy of a snapshot visible to given account, which must own
+// the snapshot or have it shared with it
+func (f *EC2) sharedSnapshot(snapshotID, accountID string) *ec2.Snapshot {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.snapshots[snapshotID]
+	if !ok || (aws.StringValue(s.snapshot.OwnerId) != accountID && !contains(s.sharedWith, accountID)) {
+		return nil
+	}
+	f.complete(s)
+	return copySnapshot(s.snapshot)
+}
+
+// call used to record a call of given operation, and to fail it when the context is done or an
+// error was injected for it. The lock must be held.
+func (f *EC2) call(ctx context.Context, operation string) error {
+	f.calls = append(f.calls, operation)
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	for i, injected := range f.errors {
+		if injected.operation == "" || injected.operation == operation {
+			f.errors = append(f.errors[:i], f.errors[i+1:]...)
+			return injected.err
+		}
+	}
+	return nil
+}
+
+// page used to obtain the bounds of the page of given number of results, and the token of the next one
+func (f *EC2) page(total int, maxResults *int64, token *string) (int, int, *string, error) {
+	start := 0
+	if token != nil {
+		n, err := strconv.Atoi(*token)
+		if err != nil || n < 0 || n > total {
+			return 0, 0, nil, awserr.New(ErrCodeInvalidPaginationKey, "Invalid pagination token.", nil)
+		}
+		start = n
+	}
+
+	size := total - start
+	if maxResults != nil && int(*maxResults) < size {
+		size = int(*maxResults)
+	}
+	if f.pageSize > 0 && f.pageSize < size {
+		size = f.pageSize
+	}
+
+	end := start + size
+	if end < total {
+		return start, end, aws.String(strconv.Itoa(end)), nil
+	}
+	return start, end, nil, nil
+}
+
+// complete used to complete a pending snapshot once the completion delay has passed. The lock must be held.
+func (f *EC2) complete(s *snapshot) {
+	if aws.StringValue(s.snapshot.State) != ec2.SnapshotStatePending {
+		return
+	}
+	if f.clock.Now().Sub(*s.snapshot.StartTime) >= f.completionDelay {
+		s.snapshot.State = aws.String(ec2.SnapshotStateCompleted)
+		s.snapshot.Progress = aws.String("100%")
+	}
+}
+
+// tags used to obtain the tags of the volume or snapshot with given ID. The lock must be held.
+func (f *EC2) tags(id string) (*[]*ec2.Tag, error) {
+	switch {
+	case strings.HasPrefix(id, "snap-"):
+		if s, ok := f.snapshots[id]; ok {
+			return &s.snapshot.Tags, nil
+		}
+		return nil, awserr.New(ErrCodeSnapshotNotFound, fmt.Sprintf("The snapshot '%s' does not exist.", id), nil)
+	case strings.HasPrefix(id, "vol-"):
+		if v, ok := f.volumes[id]; ok {
+			return &v.Tags, nil
+		}
+		return nil, awserr.New(ErrCodeVolumeNotFound, fmt.Sprintf("The volume '%s' does not exist.", id), nil)
+	}
+	return nil, awserr.New(ErrCodeInvalidParameter, fmt.Sprintf("Unsupported resource %s.", id), nil)
+}
+
+// sortedSnapshots used to obtain the snapshots in a stable order, so that pages don't overlap.
+// The lock must be held.
+func (f *EC2) sortedSnapshots() []*snapshot {
+	snapshots := make([]*snapshot, 0, len(f.snapshots))
+	for _, s := range f.snapshots {
+		snapshots = append(snapshots, s)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return *snapshots[i].snapshot.SnapshotId < *snapshots[j].snapshot.SnapshotId
+	})
+	return snapshots
+}
+
+func (f *EC2) newID(prefix string) string {
+	f.nextID++
+	return fmt.Sprintf("%s-%017x", prefix, f.nextID)
+}
+
+func matchesFilters(filters []*ec2.Filter, fields map[string]string, tags []*ec2.Tag) bool {
+	for _, filter := range filters {
+		name := aws.StringValue(filter.Name)
+		values := aws.StringValueSlice(filter.Values)
+		switch {
+		case name == "tag-key":
+			if !hasTag(tags, func(tag *ec2.Tag) bool { return contains(values, aws.StringValue(tag.Key)) }) {
+				return false
+			}
+		case strings.HasPrefix(name, "tag:"):
+			key := strings.TrimPrefix(name, "tag:")
+			if !hasTag(tags, func(tag *ec2.Tag) bool {
+				return aws.StringValue(tag.Key) == key && contains(values, aws.StringValue(tag.Value))
+			}) {
+				return false
+			}
+		default:
+			value, ok := fields[name]
+			if !ok || !contains(values, value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func hasTag(tags []*ec2.Tag, match func(*ec2.Tag) bool) bool {
+	for _, tag := range tags {
+		if match(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func removesTag(removed []*ec2.Tag, tag *ec2.Tag) bool {
+	for _, r := range removed {
+		if aws.StringValue(r.Key) == aws.StringValue(tag.Key) &&
+			(r.Value == nil || aws.StringValue(r.Value) == aws.StringValue(tag.Value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func setTag(tags []*ec2.Tag, key, value string) []*ec2.Tag {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			tag.Value = aws.String(value)
+			return tags
+		}
+	}
+	return append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+}
+
+func tagsFor(specs []*ec2.TagSpecification, resourceType string) []*ec2.Tag {
+	var tags []*ec2.Tag
+	for _, spec := range specs {
+		if aws.StringValue(spec.ResourceType) != resourceType {
+			continue
+		}
+		for _, tag := range spec.Tags {
+			tags = setTag(tags, aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+		}
+	}
+	return tags
+}
+
+func copyTags(tags []*ec2.Tag) []*ec2.Tag {
+	if tags == nil {
+		return nil
+	}
+	copied := make([]*ec2.Tag, 0, len(tags))
+	for _, tag := range tags {
+		copied = append(copied, &ec2.Tag{Key: aws.String(aws.StringValue(tag.Key)), Value: aws.String(aws.StringValue(tag.Value))})
+	}
+	return copied
+}
+
+func copyVolume(volume *ec2.Volume) *ec2.Volume {
+	v := *volume
+	v.Tags = copyTags(volume.Tags)
+	return &v
+}
+
+func copySnapshot(snapshot *ec2.Snapshot) *ec2.Snapshot {
+	s := *snapshot
+	s.Tags = copyTags(snapshot.Tags)
+	return &s
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
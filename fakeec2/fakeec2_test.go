@@ -0,0 +1,308 @@
+package fakeec2_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/clock"
+	"github.com/utilitywarehouse/ebs-snapshotter/fakeec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	w "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+	. "gopkg.in/check.v1"
+)
+
+const (
+	region      = "eu-west-1"
+	copyRegion  = "eu-west-2"
+	accountID   = "111111111111"
+	policyKey   = "snapshot"
+	policyValue = "daily"
+)
+
+var _ = Suite(&FakeEC2Suite{})
+
+type FakeEC2Suite struct {
+	fake   *fakeec2.EC2
+	client clients.EBSClient
+	// retryClock is the clock the client waits on between retries, so that tests don't wait
+	retryClock *clock.Simulated
+}
+
+func TestFakeEC2(t *testing.T) { TestingT(t) }
+
+func (s *FakeEC2Suite) SetUpTest(c *C) {
+	s.fake = fakeec2.New(region, accountID)
+	s.retryClock = clock.NewSimulated(time.Now())
+	s.client = clients.NewEBSClientWithRetryPolicy(s.fake, nil, clients.DefaultRetryPolicy, s.retryClock)
+}
+
+func (s *FakeEC2Suite) TestSnapshotsListedAcrossPages(c *C) {
+	volumeID := s.fake.AddVolume(&ec2.Volume{})
+	for i := 0; i < 5; i++ {
+		s.fake.AddSnapshot(&ec2.Snapshot{
+			VolumeId:  aws.String(volumeID),
+			StartTime: aws.Time(time.Now().Add(-time.Duration(i) * time.Hour)),
+		})
+	}
+	s.fake.SetPageSize(2)
+
+	snapshots, err := s.client.GetSnapshots(context.Background())
+
+	c.Assert(err, IsNil)
+	c.Assert(snapshots[volumeID], HasLen, 5)
+	// Snapshots are sorted newest first
+	for i := 1; i < len(snapshots[volumeID]); i++ {
+		c.Assert(snapshots[volumeID][i-1].StartTime.After(*snapshots[volumeID][i].StartTime), Equals, true)
+	}
+	c.Assert(s.fake.Calls(), DeepEquals, []string{
+		fakeec2.DescribeSnapshots, fakeec2.DescribeSnapshots, fakeec2.DescribeSnapshots,
+	})
+}
+
+func (s *FakeEC2Suite) TestSnapshotCreatedWithVolumeTagsAndCompleted(c *C) {
+	s.fake.SetCompletionDelay(time.Hour)
+	volumeID := s.fake.AddVolume(&ec2.Volume{Tags: []*ec2.Tag{
+		{Key: aws.String("kubernetes.io/created-for/pvc/name"), Value: aws.String("data")},
+		{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("stack")},
+	}})
+	volumes, err := s.client.GetVolumes(context.Background())
+	c.Assert(err, IsNil)
+
+	created, err := s.client.CreateSnapshot(context.Background(), volumes[volumeID], []*ec2.Tag{
+		{Key: aws.String(clients.LabelTag), Value: aws.String("before-upgrade")},
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(*created.State, Equals, ec2.SnapshotStatePending)
+	c.Assert(created.Tags, DeepEquals, []*ec2.Tag{
+		{Key: aws.String("kubernetes.io/created-for/pvc/name"), Value: aws.String("data")},
+		{Key: aws.String(clients.LabelTag), Value: aws.String("before-upgrade")},
+	})
+	c.Assert(*s.fake.Snapshot(*created.SnapshotId).State, Equals, ec2.SnapshotStatePending)
+
+	s.fake.SetCompletionDelay(0)
+	c.Assert(*s.fake.Snapshot(*created.SnapshotId).State, Equals, ec2.SnapshotStateCompleted)
+}
+
+func (s *FakeEC2Suite) TestSnapshotInUseNotRemoved(c *C) {
+	volumeID := s.fake.AddVolume(&ec2.Volume{})
+	snapshotID := s.fake.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String(volumeID)})
+	s.fake.SetSnapshotInUse(snapshotID, "ami-1")
+
+	err := s.client.RemoveSnapshot(context.Background(), &ec2.Snapshot{SnapshotId: aws.String(snapshotID)})
+
+	c.Assert(awsErrorCode(err), Equals, fakeec2.ErrCodeSnapshotInUse)
+	c.Assert(s.fake.Snapshot(snapshotID), NotNil)
+
+	s.fake.SetSnapshotInUse(snapshotID, "")
+	c.Assert(s.client.RemoveSnapshot(context.Background(), &ec2.Snapshot{SnapshotId: aws.String(snapshotID)}), IsNil)
+	c.Assert(s.fake.Snapshot(snapshotID), IsNil)
+
+	err = s.client.RemoveSnapshot(context.Background(), &ec2.Snapshot{SnapshotId: aws.String(snapshotID)})
+	c.Assert(awsErrorCode(err), Equals, fakeec2.ErrCodeSnapshotNotFound)
+}
+
+func (s *FakeEC2Suite) TestThrottledCallsRetried(c *C) {
+	s.fake.AddVolume(&ec2.Volume{})
+	s.fake.Throttle(fakeec2.DescribeVolumes, 2)
+
+	_, err := s.client.GetSnapshots(context.Background())
+	c.Assert(err, IsNil)
+
+	volumes, err := s.client.GetVolumes(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(volumes, HasLen, 1)
+	c.Assert(s.fake.Calls(), DeepEquals, []string{
+		fakeec2.DescribeSnapshots, fakeec2.DescribeVolumes, fakeec2.DescribeVolumes, fakeec2.DescribeVolumes,
+	})
+}
+
+func (s *FakeEC2Suite) TestTagsAddedAndRemoved(c *C) {
+	snapshotID := s.fake.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String("vol-1")})
+
+	c.Assert(s.client.TagSnapshot(context.Background(), snapshotID, []*ec2.Tag{
+		{Key: aws.String(clients.HoldTag), Value: aws.String("true")},
+		{Key: aws.String(clients.LabelTag), Value: aws.String("release")},
+	}), IsNil)
+	c.Assert(s.client.UntagSnapshot(context.Background(), snapshotID, []string{clients.HoldTag}), IsNil)
+
+	c.Assert(s.fake.Snapshot(snapshotID).Tags, DeepEquals, []*ec2.Tag{
+		{Key: aws.String(clients.LabelTag), Value: aws.String("release")},
+	})
+}
+
+func (s *FakeEC2Suite) TestSnapshotCreatedAndExpiredOneRemoved(c *C) {
+	volumeID := s.fake.AddVolume(&ec2.Volume{Tags: []*ec2.Tag{
+		{Key: aws.String(policyKey), Value: aws.String(policyValue)},
+	}})
+	expiredID := s.fake.AddSnapshot(&ec2.Snapshot{
+		VolumeId:  aws.String(volumeID),
+		StartTime: aws.Time(time.Now().Add(-48 * time.Hour)),
+	})
+	watcher := newWatcher(s.client)
+
+	c.Assert(watcher.WatchSnapshots(context.Background(), policies()), IsNil)
+
+	snapshots := s.fake.Snapshots(volumeID)
+	c.Assert(snapshots, HasLen, 1)
+	c.Assert(*snapshots[0].SnapshotId, Not(Equals), expiredID)
+	c.Assert(*snapshots[0].State, Equals, ec2.SnapshotStateCompleted)
+
+	// The new snapshot is up to date, so nothing else is created or removed
+	c.Assert(watcher.WatchSnapshots(context.Background(), policies()), IsNil)
+	c.Assert(s.fake.Snapshots(volumeID), DeepEquals, snapshots)
+}
+
+func (s *FakeEC2Suite) TestSnapshotInUseKeptPastRetention(c *C) {
+	volumeID := s.fake.AddVolume(&ec2.Volume{Tags: []*ec2.Tag{
+		{Key: aws.String(policyKey), Value: aws.String(policyValue)},
+	}})
+	expiredID := s.fake.AddSnapshot(&ec2.Snapshot{
+		VolumeId:  aws.String(volumeID),
+		StartTime: aws.Time(time.Now().Add(-48 * time.Hour)),
+	})
+	s.fake.SetSnapshotInUse(expiredID, "ami-1")
+	watcher, metrics := newWatcherWithMetrics(s.client)
+
+	c.Assert(watcher.WatchSnapshots(context.Background(), policies()), IsNil)
+
+	c.Assert(s.fake.Snapshot(expiredID), NotNil)
+	c.Assert(s.fake.Snapshots(volumeID), HasLen, 2)
+	// Snapshots backing an AMI are skipped rather than failed
+	c.Assert(testutil.ToFloat64(metrics.RemovalsSkipped.WithLabelValues("", "", volumeID, "in_use")), Equals, float64(1))
+	c.Assert(testutil.ToFloat64(metrics.Errors.WithLabelValues("", "", volumeID, "remove_snapshot")), Equals, float64(0))
+}
+
+func (s *FakeEC2Suite) TestSnapshotAlreadyRemovedNotAnError(c *C) {
+	volumeID := s.fake.AddVolume(&ec2.Volume{Tags: []*ec2.Tag{
+		{Key: aws.String(policyKey), Value: aws.String(policyValue)},
+	}})
+	s.fake.AddSnapshot(&ec2.Snapshot{
+		VolumeId:  aws.String(volumeID),
+		StartTime: aws.Time(time.Now().Add(-48 * time.Hour)),
+	})
+	// The snapshot is removed by someone else between listing and removing it
+	s.fake.FailNext(fakeec2.DeleteSnapshot, awserr.New(fakeec2.ErrCodeSnapshotNotFound, "", nil))
+	watcher, metrics := newWatcherWithMetrics(s.client)
+
+	c.Assert(watcher.WatchSnapshots(context.Background(), policies()), IsNil)
+
+	c.Assert(testutil.ToFloat64(metrics.Errors.WithLabelValues("", "", volumeID, "remove_snapshot")), Equals, float64(0))
+	c.Assert(testutil.ToFloat64(metrics.SnapshotsRemoved.WithLabelValues("", "", volumeID)), Equals, float64(0))
+	c.Assert(watcher.States()[0].LastError, Equals, "")
+}
+
+func (s *FakeEC2Suite) TestThrottledCycleFails(c *C) {
+	volumeID := s.fake.AddVolume(&ec2.Volume{Tags: []*ec2.Tag{
+		{Key: aws.String(policyKey), Value: aws.String(policyValue)},
+	}})
+	// Throttled beyond the retries of the default policy
+	s.fake.Throttle(fakeec2.DescribeSnapshots, 5)
+	watcher := newWatcher(s.client)
+
+	err := watcher.WatchSnapshots(context.Background(), policies())
+
+	c.Assert(awsErrorCode(err), Equals, fakeec2.ErrCodeThrottled)
+	c.Assert(clients.ErrorClassOf(err), Equals, clients.ErrorClassThrottled)
+	c.Assert(s.fake.Snapshots(volumeID), HasLen, 0)
+
+	c.Assert(watcher.WatchSnapshots(context.Background(), policies()), IsNil)
+	c.Assert(s.fake.Snapshots(volumeID), HasLen, 1)
+}
+
+func (s *FakeEC2Suite) TestSnapshotCopiedToOtherRegion(c *C) {
+	dest := fakeec2.New(copyRegion, accountID)
+	dest.LinkRegion(s.fake)
+	volumeID := s.fake.AddVolume(&ec2.Volume{Tags: []*ec2.Tag{
+		{Key: aws.String(policyKey), Value: aws.String(policyValue)},
+	}})
+	snapshotID := s.fake.AddSnapshot(&ec2.Snapshot{
+		VolumeId:  aws.String(volumeID),
+		StartTime: aws.Time(time.Now().Add(-time.Hour)),
+	})
+	watcher := newWatcher(s.client)
+	watcher.SetSnapshotCopier(clients.NewSnapshotCopier(region, map[string]clients.EBSClient{
+		copyRegion: clients.NewEBSClient(dest, nil),
+	}, slog.Default()))
+	config := policies()
+	(*config)[0].CopyTo = []*models.CopyDestination{{Region: copyRegion, RetentionPeriodHours: 10}}
+
+	c.Assert(watcher.WatchSnapshots(context.Background(), config), IsNil)
+	c.Assert(watcher.WatchSnapshots(context.Background(), config), IsNil)
+
+	copies := dest.Snapshots("")
+	c.Assert(copies, HasLen, 1)
+	c.Assert(clients.SourceSnapshotID(copies[0]), Equals, snapshotID)
+	c.Assert(s.fake.Snapshots(volumeID), HasLen, 1)
+}
+
+func (s *FakeEC2Suite) TestRegionsCopyingFromEachOtherConcurrently(c *C) {
+	other := fakeec2.New(copyRegion, accountID)
+	other.LinkRegion(s.fake)
+	s.fake.LinkRegion(other)
+	snapshotID := s.fake.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String("vol-1")})
+	otherSnapshotID := other.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String("vol-2")})
+
+	copyFrom := func(dest *fakeec2.EC2, sourceRegion, sourceID string, errs chan<- error) {
+		for i := 0; i < 100; i++ {
+			_, err := dest.CopySnapshotWithContext(context.Background(), &ec2.CopySnapshotInput{
+				SourceRegion:     aws.String(sourceRegion),
+				SourceSnapshotId: aws.String(sourceID),
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}
+	errs := make(chan error, 2)
+	go copyFrom(s.fake, copyRegion, otherSnapshotID, errs)
+	go copyFrom(other, region, snapshotID, errs)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			c.Assert(err, IsNil)
+		case <-time.After(10 * time.Second):
+			c.Fatal("copies between regions deadlocked")
+		}
+	}
+	c.Assert(other.Snapshots(""), HasLen, 101)
+}
+
+func policies() *models.VolumeSnapshotConfigs {
+	return &models.VolumeSnapshotConfigs{
+		&models.VolumeSnapshotConfig{
+			Labels:               models.Label{Key: policyKey, Value: policyValue},
+			IntervalSeconds:      86400,
+			RetentionPeriodHours: 10,
+		},
+	}
+}
+
+func newWatcher(client clients.EBSClient) *w.EBSSnapshotWatcher {
+	watcher, _ := newWatcherWithMetrics(client)
+	return watcher
+}
+
+func newWatcherWithMetrics(client clients.EBSClient) (*w.EBSSnapshotWatcher, *w.Metrics) {
+	metrics := w.NewMetrics(prometheus.NewRegistry())
+	return w.NewEBSSnapshotWatcher(client, metrics), metrics
+}
+
+func awsErrorCode(err error) string {
+	if aerr, ok := errors.Cause(err).(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
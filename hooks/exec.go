@@ -0,0 +1,91 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+type kubeExecutor struct {
+	client kubernetes.Interface
+	config *rest.Config
+}
+
+// NewKubeExecutor used to create a pod executor that runs commands through the Kubernetes API
+func NewKubeExecutor(client kubernetes.Interface, config *rest.Config) PodExecutor {
+	return &kubeExecutor{
+		client: client,
+		config: config,
+	}
+}
+
+// Exec used to run the hook command in the running pod that mounts given PVC
+func (e *kubeExecutor) Exec(ctx context.Context, namespace, pvcName string, hook *models.ExecHook) error {
+	pod, err := FindPodForPVC(ctx, e.client, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+
+	container := hook.Container
+	if container == "" {
+		container = pod.Spec.Containers[0].Name
+	}
+
+	req := e.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   hook.Command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return errors.Wrap(err, "error while creating pod executor")
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}); err != nil {
+		return errors.Wrapf(err, "error while executing hook in pod %s/%s, stderr: %s",
+			pod.Namespace, pod.Name, stderr.String())
+	}
+
+	return nil
+}
+
+// FindPodForPVC used to find the running pod that mounts given PVC
+func FindPodForPVC(ctx context.Context, client kubernetes.Interface, namespace, pvcName string) (*corev1.Pod, error) {
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error while listing pods")
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvcName {
+				return pod, nil
+			}
+		}
+	}
+
+	return nil, errors.Errorf("no running pod found for PVC %s/%s", namespace, pvcName)
+}
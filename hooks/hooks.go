@@ -0,0 +1,110 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+)
+
+// Target used to store details of the volume a hook is run for
+type Target struct {
+	VolumeID     string `json:"volumeId"`
+	PVCName      string `json:"pvcName"`
+	PVCNamespace string `json:"pvcNamespace"`
+}
+
+// Runner interface specifies hook runner functions
+type Runner interface {
+	Run(ctx context.Context, hook *models.Hook, target Target) error
+}
+
+// PodExecutor interface specifies functions used to run a command in the pod that owns a PVC
+type PodExecutor interface {
+	Exec(ctx context.Context, namespace, pvcName string, hook *models.ExecHook) error
+}
+
+type runner struct {
+	httpClient *http.Client
+	executor   PodExecutor
+}
+
+// NewRunner used to create a new hook runner. The executor is only needed for exec hooks and may be nil.
+func NewRunner(httpClient *http.Client, executor PodExecutor) Runner {
+	return &runner{
+		httpClient: httpClient,
+		executor:   executor,
+	}
+}
+
+// Run used to run given hook for a volume, within the hook's timeout. The hook is stopped early
+// when given context is done.
+func (r *runner) Run(ctx context.Context, hook *models.Hook, target Target) error {
+	timeout := defaultTimeout
+	if hook.TimeoutSeconds > 0 {
+		timeout = time.Duration(hook.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch {
+	case hook.HTTP != nil:
+		return r.runHTTP(ctx, hook.HTTP, target)
+	case hook.Exec != nil:
+		if r.executor == nil {
+			return errors.New("exec hooks require Kubernetes access, which is not configured")
+		}
+		if target.PVCName == "" || target.PVCNamespace == "" {
+			return errors.Errorf("volume %s is not bound to a PVC, can't run exec hook", target.VolumeID)
+		}
+		return r.executor.Exec(ctx, target.PVCNamespace, target.PVCName, hook.Exec)
+	default:
+		return errors.New("hook has neither http nor exec defined")
+	}
+}
+
+func (r *runner) runHTTP(ctx context.Context, hook *models.HTTPHook, target Target) error {
+	method := hook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	body, err := json.Marshal(target)
+	if err != nil {
+		return errors.Wrap(err, "error while encoding hook request body")
+	}
+
+	req, err := http.NewRequest(method, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "error while creating hook request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range hook.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error while calling hook")
+	}
+	defer resp.Body.Close()
+	// Drain the body so that the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("hook %s %s returned status %d", method, hook.URL, resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package hooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/hooks"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	. "gopkg.in/check.v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Suite(&HooksSuite{})
+
+type HooksSuite struct{}
+
+func TestHooks(t *testing.T) { TestingT(t) }
+
+func (s *HooksSuite) TestHTTPHookSendsTargetDetails(c *C) {
+	var received hooks.Target
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, http.MethodPut)
+		c.Check(r.Header.Get("Authorization"), Equals, "Bearer token")
+		c.Check(json.NewDecoder(r.Body).Decode(&received), IsNil)
+	}))
+	defer server.Close()
+
+	runner := hooks.NewRunner(server.Client(), nil)
+	err := runner.Run(context.Background(), &models.Hook{
+		HTTP: &models.HTTPHook{
+			URL:     server.URL,
+			Method:  http.MethodPut,
+			Headers: map[string]string{"Authorization": "Bearer token"},
+		},
+	}, hooks.Target{VolumeID: "volume-1", PVCName: "datadir-kafka-0", PVCNamespace: "kafka"})
+
+	c.Assert(err, IsNil)
+	c.Assert(received, DeepEquals, hooks.Target{VolumeID: "volume-1", PVCName: "datadir-kafka-0", PVCNamespace: "kafka"})
+}
+
+func (s *HooksSuite) TestHTTPHookFailsOnErrorStatus(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	runner := hooks.NewRunner(server.Client(), nil)
+	err := runner.Run(context.Background(), &models.Hook{HTTP: &models.HTTPHook{URL: server.URL}}, hooks.Target{VolumeID: "volume-1"})
+
+	c.Assert(err, ErrorMatches, "hook POST .* returned status 503")
+}
+
+func (s *HooksSuite) TestHTTPHookFailsOnTimeout(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	runner := hooks.NewRunner(server.Client(), nil)
+	start := time.Now()
+	err := runner.Run(context.Background(), &models.Hook{
+		TimeoutSeconds: 1,
+		HTTP:           &models.HTTPHook{URL: server.URL},
+	}, hooks.Target{VolumeID: "volume-1"})
+
+	c.Assert(err, NotNil)
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+}
+
+func (s *HooksSuite) TestHTTPHookStoppedWhenContextDone(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	runner := hooks.NewRunner(server.Client(), nil)
+	start := time.Now()
+	err := runner.Run(ctx, &models.Hook{
+		TimeoutSeconds: 60,
+		HTTP:           &models.HTTPHook{URL: server.URL},
+	}, hooks.Target{VolumeID: "volume-1"})
+
+	c.Assert(err, ErrorMatches, "error while calling hook: .*context canceled")
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+}
+
+func (s *HooksSuite) TestExecHookFailsWithoutExecutor(c *C) {
+	runner := hooks.NewRunner(http.DefaultClient, nil)
+	err := runner.Run(context.Background(), &models.Hook{
+		Exec: &models.ExecHook{Command: []string{"fsfreeze", "-f", "/data"}},
+	}, hooks.Target{VolumeID: "volume-1", PVCName: "datadir-kafka-0", PVCNamespace: "kafka"})
+
+	c.Assert(err, ErrorMatches, "exec hooks require Kubernetes access.*")
+}
+
+func (s *HooksSuite) TestRunningPodMountingPVCFound(c *C) {
+	client := fake.NewSimpleClientset(
+		createFakePod("kafka-0", "kafka", "datadir-kafka-0", corev1.PodFailed),
+		createFakePod("kafka-0-new", "kafka", "datadir-kafka-0", corev1.PodRunning),
+		createFakePod("kafka-1", "kafka", "datadir-kafka-1", corev1.PodRunning),
+	)
+
+	pod, err := hooks.FindPodForPVC(context.Background(), client, "kafka", "datadir-kafka-0")
+
+	c.Assert(err, IsNil)
+	c.Assert(pod.Name, Equals, "kafka-0-new")
+}
+
+func (s *HooksSuite) TestNoPodFoundForUnmountedPVC(c *C) {
+	client := fake.NewSimpleClientset(
+		createFakePod("kafka-1", "kafka", "datadir-kafka-1", corev1.PodRunning),
+	)
+
+	_, err := hooks.FindPodForPVC(context.Background(), client, "kafka", "datadir-kafka-0")
+
+	c.Assert(err, ErrorMatches, "no running pod found for PVC kafka/datadir-kafka-0")
+}
+
+func createFakePod(name, namespace, pvcName string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app"}},
+			Volumes: []corev1.Volume{
+				{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+					},
+				},
+			},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
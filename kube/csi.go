@@ -0,0 +1,159 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "ebs-snapshotter"
+)
+
+var (
+	// VolumeSnapshotResource is the group version resource of CSI VolumeSnapshot objects
+	VolumeSnapshotResource = schema.GroupVersionResource{
+		Group:    "snapshot.storage.k8s.io",
+		Version:  "v1",
+		Resource: "volumesnapshots",
+	}
+	// VolumeSnapshotContentResource is the group version resource of CSI VolumeSnapshotContent objects
+	VolumeSnapshotContentResource = schema.GroupVersionResource{
+		Group:    "snapshot.storage.k8s.io",
+		Version:  "v1",
+		Resource: "volumesnapshotcontents",
+	}
+)
+
+// SnapshotRegistrar interface specifies functions used to expose EBS snapshots to Kubernetes
+type SnapshotRegistrar interface {
+	Register(snapshot *ec2.Snapshot, pvcName, pvcNamespace string) error
+	Unregister(snapshot *ec2.Snapshot, pvcName, pvcNamespace string) error
+}
+
+type csiSnapshotRegistrar struct {
+	client        dynamic.Interface
+	snapshotClass string
+
+	mu         sync.Mutex
+	registered map[string]bool
+}
+
+// NewCSISnapshotRegistrar used to create a registrar that exposes EBS snapshots as pre-provisioned
+// CSI VolumeSnapshotContent and VolumeSnapshot objects. The snapshot class may be empty.
+func NewCSISnapshotRegistrar(client dynamic.Interface, snapshotClass string) SnapshotRegistrar {
+	return &csiSnapshotRegistrar{
+		client:        client,
+		snapshotClass: snapshotClass,
+		registered:    make(map[string]bool),
+	}
+}
+
+// Register used to create a VolumeSnapshotContent and a VolumeSnapshot in the PVC's namespace for
+// given snapshot, so it can be used as a PVC data source. Existing objects are left untouched.
+func (r *csiSnapshotRegistrar) Register(snapshot *ec2.Snapshot, pvcName, pvcNamespace string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.registered[*snapshot.SnapshotId] {
+		return nil
+	}
+
+	ctx := context.Background()
+	contentName := VolumeSnapshotContentName(snapshot)
+	snapshotName := VolumeSnapshotName(snapshot, pvcName)
+
+	content := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "snapshot.storage.k8s.io/v1",
+		"kind":       "VolumeSnapshotContent",
+		"metadata": map[string]interface{}{
+			"name":   contentName,
+			"labels": map[string]interface{}{managedByLabel: managedByValue},
+		},
+		"spec": map[string]interface{}{
+			// The EBS snapshot lifecycle is managed by ebs-snapshotter retention
+			"deletionPolicy": "Retain",
+			"driver":         ebsCSIDriver,
+			"source": map[string]interface{}{
+				"snapshotHandle": *snapshot.SnapshotId,
+			},
+			"volumeSnapshotRef": map[string]interface{}{
+				"name":      snapshotName,
+				"namespace": pvcNamespace,
+			},
+		},
+	}}
+	volumeSnapshot := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "snapshot.storage.k8s.io/v1",
+		"kind":       "VolumeSnapshot",
+		"metadata": map[string]interface{}{
+			"name":      snapshotName,
+			"namespace": pvcNamespace,
+			"labels":    map[string]interface{}{managedByLabel: managedByValue},
+		},
+		"spec": map[string]interface{}{
+			"source": map[string]interface{}{
+				"volumeSnapshotContentName": contentName,
+			},
+		},
+	}}
+	if r.snapshotClass != "" {
+		unstructured.SetNestedField(content.Object, r.snapshotClass, "spec", "volumeSnapshotClassName")
+		unstructured.SetNestedField(volumeSnapshot.Object, r.snapshotClass, "spec", "volumeSnapshotClassName")
+	}
+
+	if _, err := r.client.Resource(VolumeSnapshotContentResource).
+		Create(ctx, content, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "error while creating volume snapshot content %s", contentName)
+	}
+	if _, err := r.client.Resource(VolumeSnapshotResource).
+		Namespace(pvcNamespace).
+		Create(ctx, volumeSnapshot, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "error while creating volume snapshot %s/%s", pvcNamespace, snapshotName)
+	}
+
+	r.registered[*snapshot.SnapshotId] = true
+	return nil
+}
+
+// Unregister used to remove the VolumeSnapshot and VolumeSnapshotContent of given snapshot
+func (r *csiSnapshotRegistrar) Unregister(snapshot *ec2.Snapshot, pvcName, pvcNamespace string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx := context.Background()
+	contentName := VolumeSnapshotContentName(snapshot)
+	snapshotName := VolumeSnapshotName(snapshot, pvcName)
+
+	if err := r.client.Resource(VolumeSnapshotResource).
+		Namespace(pvcNamespace).
+		Delete(ctx, snapshotName, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "error while deleting volume snapshot %s/%s", pvcNamespace, snapshotName)
+	}
+	if err := r.client.Resource(VolumeSnapshotContentResource).
+		Delete(ctx, contentName, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "error while deleting volume snapshot content %s", contentName)
+	}
+
+	delete(r.registered, *snapshot.SnapshotId)
+	return nil
+}
+
+// VolumeSnapshotContentName used to obtain the name of the VolumeSnapshotContent of an EBS snapshot
+func VolumeSnapshotContentName(snapshot *ec2.Snapshot) string {
+	return fmt.Sprintf("ebs-snapshotter-%s", *snapshot.SnapshotId)
+}
+
+// VolumeSnapshotName used to obtain the name of the VolumeSnapshot of an EBS snapshot
+func VolumeSnapshotName(snapshot *ec2.Snapshot, pvcName string) string {
+	return fmt.Sprintf("%s-%s", pvcName, *snapshot.SnapshotId)
+}
@@ -0,0 +1,77 @@
+package kube_test
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/kube"
+	. "gopkg.in/check.v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+var _ = Suite(&CSISuite{})
+
+type CSISuite struct{}
+
+func (s *CSISuite) TestSnapshotRegisteredAsPreProvisionedVolumeSnapshot(c *C) {
+	client := newFakeCSIClient()
+	registrar := kube.NewCSISnapshotRegistrar(client, "ebs-csi")
+	snapshot := &ec2.Snapshot{SnapshotId: aws.String("snap-1")}
+
+	c.Assert(registrar.Register(snapshot, "datadir-kafka-0", "kafka"), IsNil)
+	// Registering again is a no-op
+	c.Assert(registrar.Register(snapshot, "datadir-kafka-0", "kafka"), IsNil)
+
+	content, err := client.Resource(kube.VolumeSnapshotContentResource).
+		Get(context.Background(), "ebs-snapshotter-snap-1", metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	handle, _, _ := unstructured.NestedString(content.Object, "spec", "source", "snapshotHandle")
+	c.Assert(handle, Equals, "snap-1")
+	ref, _, _ := unstructured.NestedString(content.Object, "spec", "volumeSnapshotRef", "name")
+	c.Assert(ref, Equals, "datadir-kafka-0-snap-1")
+
+	volumeSnapshot, err := client.Resource(kube.VolumeSnapshotResource).
+		Namespace("kafka").
+		Get(context.Background(), "datadir-kafka-0-snap-1", metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	contentName, _, _ := unstructured.NestedString(volumeSnapshot.Object, "spec", "source", "volumeSnapshotContentName")
+	c.Assert(contentName, Equals, "ebs-snapshotter-snap-1")
+	class, _, _ := unstructured.NestedString(volumeSnapshot.Object, "spec", "volumeSnapshotClassName")
+	c.Assert(class, Equals, "ebs-csi")
+}
+
+func (s *CSISuite) TestUnregisteredSnapshotObjectsRemoved(c *C) {
+	client := newFakeCSIClient()
+	registrar := kube.NewCSISnapshotRegistrar(client, "")
+	snapshot := &ec2.Snapshot{SnapshotId: aws.String("snap-1")}
+
+	c.Assert(registrar.Register(snapshot, "datadir-kafka-0", "kafka"), IsNil)
+	c.Assert(registrar.Unregister(snapshot, "datadir-kafka-0", "kafka"), IsNil)
+	// Objects that are already gone are ignored
+	c.Assert(registrar.Unregister(snapshot, "datadir-kafka-0", "kafka"), IsNil)
+
+	contents, err := client.Resource(kube.VolumeSnapshotContentResource).
+		List(context.Background(), metav1.ListOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(len(contents.Items), Equals, 0)
+
+	snapshots, err := client.Resource(kube.VolumeSnapshotResource).
+		Namespace("kafka").
+		List(context.Background(), metav1.ListOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(len(snapshots.Items), Equals, 0)
+}
+
+func newFakeCSIClient() *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			kube.VolumeSnapshotResource:        "VolumeSnapshotList",
+			kube.VolumeSnapshotContentResource: "VolumeSnapshotContentList",
+		})
+}
@@ -0,0 +1,154 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// IntervalAnnotation is the PVC annotation holding the interval between snapshots, e.g. `12h`
+	IntervalAnnotation = "ebs-snapshotter/interval"
+	// RetentionAnnotation is the PVC annotation holding the snapshot retention period, e.g. `14d`
+	RetentionAnnotation = "ebs-snapshotter/retention"
+
+	ebsCSIDriver = "ebs.csi.aws.com"
+)
+
+// PolicyDiscoverer interface specifies functions used to discover snapshot policies
+type PolicyDiscoverer interface {
+	Discover() (models.VolumeSnapshotConfigs, error)
+}
+
+type pvcPolicyDiscoverer struct {
+	client kubernetes.Interface
+	logger *slog.Logger
+}
+
+// NewPVCPolicyDiscoverer used to create a discoverer of snapshot policies defined by PVC annotations
+func NewPVCPolicyDiscoverer(client kubernetes.Interface, logger *slog.Logger) PolicyDiscoverer {
+	return &pvcPolicyDiscoverer{
+		client: client,
+		logger: logger,
+	}
+}
+
+// Discover used to obtain a snapshot policy for every annotated PVC bound to an EBS volume.
+// PVCs with invalid annotations are skipped.
+func (d *pvcPolicyDiscoverer) Discover() (models.VolumeSnapshotConfigs, error) {
+	ctx := context.Background()
+	pvcs, err := d.client.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error while listing PVCs")
+	}
+
+	configs := models.VolumeSnapshotConfigs{}
+	for _, pvc := range pvcs.Items {
+		interval, hasInterval := pvc.Annotations[IntervalAnnotation]
+		retention, hasRetention := pvc.Annotations[RetentionAnnotation]
+		if !hasInterval && !hasRetention {
+			continue
+		}
+
+		config, err := d.policyForPVC(ctx, pvc, interval, retention)
+		if err != nil {
+			d.logger.Error("skipped snapshot policy of PVC",
+				logging.PVCName, pvc.Name,
+				logging.PVCNamespace, pvc.Namespace,
+				logging.Action, "discover_policy",
+				logging.Error, err)
+			continue
+		}
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
+func (d *pvcPolicyDiscoverer) policyForPVC(
+	ctx context.Context,
+	pvc corev1.PersistentVolumeClaim,
+	interval, retention string) (*models.VolumeSnapshotConfig, error) {
+
+	intervalDuration, err := ParseDuration(interval)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s annotation", IntervalAnnotation)
+	}
+	retentionDuration, err := ParseDuration(retention)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s annotation", RetentionAnnotation)
+	}
+
+	if intervalDuration <= 0 || retentionDuration < time.Hour {
+		return nil, errors.New("interval must be positive and retention at least one hour")
+	}
+
+	volumeID, err := VolumeIDForPVC(ctx, d.client, &pvc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.VolumeSnapshotConfig{
+		Name:                 fmt.Sprintf("pvc/%s/%s", pvc.Namespace, pvc.Name),
+		VolumeID:             volumeID,
+		IntervalSeconds:      int64(intervalDuration / time.Second),
+		RetentionPeriodHours: int64(retentionDuration / time.Hour),
+	}, nil
+}
+
+// VolumeIDForPVC used to obtain the EBS volume ID of a PVC through its bound PV
+func VolumeIDForPVC(ctx context.Context, client kubernetes.Interface, pvc *corev1.PersistentVolumeClaim) (string, error) {
+	if pvc.Spec.VolumeName == "" {
+		return "", errors.New("PVC is not bound")
+	}
+
+	pv, err := client.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "error while fetching PV %s", pvc.Spec.VolumeName)
+	}
+
+	volumeID := VolumeIDFromPV(pv)
+	if volumeID == "" {
+		return "", errors.Errorf("PV %s is not backed by an EBS volume", pv.Name)
+	}
+	return volumeID, nil
+}
+
+// VolumeIDFromPV used to obtain the EBS volume ID of an in-tree or CSI provisioned PV
+func VolumeIDFromPV(pv *corev1.PersistentVolume) string {
+	switch {
+	case pv.Spec.AWSElasticBlockStore != nil:
+		// In-tree volume IDs may be in the form of aws://<zone>/<volume id>
+		id := pv.Spec.AWSElasticBlockStore.VolumeID
+		return id[strings.LastIndex(id, "/")+1:]
+	case pv.Spec.CSI != nil && pv.Spec.CSI.Driver == ebsCSIDriver:
+		return pv.Spec.CSI.VolumeHandle
+	default:
+		return ""
+	}
+}
+
+// ParseDuration used to parse a duration, additionally accepting a number of days such as `14d`
+func ParseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, errors.New("value is empty")
+	}
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, errors.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
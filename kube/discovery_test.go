@@ -0,0 +1,151 @@
+package kube_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/kube"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	. "gopkg.in/check.v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Suite(&DiscoverySuite{})
+
+type DiscoverySuite struct{}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestKube(t *testing.T) { TestingT(t) }
+
+func (s *DiscoverySuite) TestPoliciesDiscoveredForAnnotatedPVCs(c *C) {
+	client := fake.NewSimpleClientset(
+		createFakePVC("kafka", "datadir-kafka-0", "pv-1", map[string]string{
+			kube.IntervalAnnotation:  "12h",
+			kube.RetentionAnnotation: "14d",
+		}),
+		createFakePVC("db", "data-postgres-0", "pv-2", map[string]string{
+			kube.IntervalAnnotation:  "30m",
+			kube.RetentionAnnotation: "48h",
+		}),
+		createFakePVC("kafka", "datadir-kafka-1", "pv-3", nil),
+		createFakeInTreePV("pv-1", "aws://eu-west-1a/vol-1"),
+		createFakeCSIPV("pv-2", "vol-2"),
+		createFakeCSIPV("pv-3", "vol-3"),
+	)
+
+	configs, err := kube.NewPVCPolicyDiscoverer(client, discardLogger).Discover()
+
+	c.Assert(err, IsNil)
+	c.Assert(len(configs), Equals, 2)
+	byVolume := map[string]*models.VolumeSnapshotConfig{}
+	for _, config := range configs {
+		byVolume[config.VolumeID] = config
+	}
+	c.Assert(byVolume["vol-1"], DeepEquals, &models.VolumeSnapshotConfig{
+		Name:                 "pvc/kafka/datadir-kafka-0",
+		VolumeID:             "vol-1",
+		IntervalSeconds:      43200,
+		RetentionPeriodHours: 336,
+	})
+	c.Assert(byVolume["vol-2"], DeepEquals, &models.VolumeSnapshotConfig{
+		Name:                 "pvc/db/data-postgres-0",
+		VolumeID:             "vol-2",
+		IntervalSeconds:      1800,
+		RetentionPeriodHours: 48,
+	})
+}
+
+func (s *DiscoverySuite) TestInvalidAndUnboundPVCsSkipped(c *C) {
+	client := fake.NewSimpleClientset(
+		createFakePVC("kafka", "datadir-kafka-0", "", map[string]string{
+			kube.IntervalAnnotation:  "12h",
+			kube.RetentionAnnotation: "14d",
+		}),
+		createFakePVC("kafka", "datadir-kafka-1", "pv-1", map[string]string{
+			kube.IntervalAnnotation:  "twelve hours",
+			kube.RetentionAnnotation: "14d",
+		}),
+		createFakePVC("kafka", "datadir-kafka-2", "pv-2", map[string]string{
+			kube.IntervalAnnotation: "12h",
+		}),
+		createFakeInTreePV("pv-1", "vol-1"),
+		createFakeInTreePV("pv-2", "vol-2"),
+	)
+
+	out := &bytes.Buffer{}
+	logger, err := logging.New(out, "info")
+	c.Assert(err, IsNil)
+
+	configs, err := kube.NewPVCPolicyDiscoverer(client, logger).Discover()
+
+	c.Assert(err, IsNil)
+	c.Assert(len(configs), Equals, 0)
+	skipped := make([]string, 0)
+	for _, line := range bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n")) {
+		record := make(map[string]interface{})
+		c.Assert(json.Unmarshal(line, &record), IsNil)
+		c.Assert(record["level"], Equals, "ERROR")
+		c.Assert(record[logging.PVCNamespace], Equals, "kafka")
+		c.Assert(record[logging.Error], NotNil)
+		skipped = append(skipped, record[logging.PVCName].(string))
+	}
+	sort.Strings(skipped)
+	c.Assert(skipped, DeepEquals, []string{"datadir-kafka-0", "datadir-kafka-1", "datadir-kafka-2"})
+}
+
+func (s *DiscoverySuite) TestDurationsParsed(c *C) {
+	d, err := kube.ParseDuration("14d")
+	c.Assert(err, IsNil)
+	c.Assert(d, Equals, 14*24*time.Hour)
+
+	d, err = kube.ParseDuration("90m")
+	c.Assert(err, IsNil)
+	c.Assert(d, Equals, 90*time.Minute)
+
+	_, err = kube.ParseDuration("d")
+	c.Assert(err, NotNil)
+}
+
+func createFakePVC(namespace, name, volumeName string, annotations map[string]string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			VolumeName: volumeName,
+		},
+	}
+}
+
+func createFakeInTreePV(name, volumeID string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				AWSElasticBlockStore: &corev1.AWSElasticBlockStoreVolumeSource{VolumeID: volumeID},
+			},
+		},
+	}
+}
+
+func createFakeCSIPV(name, volumeID string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: "ebs.csi.aws.com", VolumeHandle: volumeID},
+			},
+		},
+	}
+}
@@ -0,0 +1,33 @@
+// Package logging holds the structured logger setup and the field names shared by every component
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Field names used consistently across log records
+const (
+	VolumeID     = "volume_id"
+	PVCName      = "pvc_name"
+	PVCNamespace = "pvc_namespace"
+	SnapshotID   = "snapshot_id"
+	Policy       = "policy"
+	Action       = "action"
+	Reason       = "reason"
+	Destination  = "destination"
+	Error        = "error"
+)
+
+// New used to create a logger writing JSON records at or above given level to given writer.
+// The level is one of debug, info, warn or error.
+func New(out io.Writer, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+		return nil, errors.Wrapf(err, "error while parsing log level %s", level)
+	}
+	return slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: l})), nil
+}
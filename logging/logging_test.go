@@ -0,0 +1,37 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&LoggingSuite{})
+
+type LoggingSuite struct{}
+
+func TestLogging(t *testing.T) { TestingT(t) }
+
+func (s *LoggingSuite) TestRecordsBelowLevelDropped(c *C) {
+	out := &bytes.Buffer{}
+	logger, err := logging.New(out, "warn")
+	c.Assert(err, IsNil)
+
+	logger.Info("created a new snapshot")
+	logger.Warn("failed to remove old snapshot", logging.VolumeID, "vol-1")
+
+	record := make(map[string]interface{})
+	c.Assert(json.Unmarshal(out.Bytes(), &record), IsNil)
+	c.Assert(record["level"], Equals, "WARN")
+	c.Assert(record["msg"], Equals, "failed to remove old snapshot")
+	c.Assert(record[logging.VolumeID], Equals, "vol-1")
+}
+
+func (s *LoggingSuite) TestUnknownLevelRejected(c *C) {
+	_, err := logging.New(&bytes.Buffer{}, "verbose")
+
+	c.Assert(err, NotNil)
+}
@@ -5,9 +5,16 @@ type VolumeSnapshotConfigs []*VolumeSnapshotConfig
 
 // VolumeSnapshotConfig used to store volume snapshot configuration details
 type VolumeSnapshotConfig struct {
-	Labels               Label `json:"labels"`
-	IntervalSeconds      int64 `json:"intervalSeconds"`
-	RetentionPeriodHours int64 `json:"retentionPeriodHours"`
+	Name                 string             `json:"name,omitempty"`
+	VolumeID             string             `json:"volumeId,omitempty"`
+	Labels               Label              `json:"labels"`
+	IntervalSeconds      int64              `json:"intervalSeconds"`
+	RetentionPeriodHours int64              `json:"retentionPeriodHours"`
+	RPOSeconds           int64              `json:"rpoSeconds,omitempty"`
+	CopyTo               []*CopyDestination `json:"copyTo,omitempty"`
+	Vault                *VaultPolicy       `json:"vault,omitempty"`
+	PreHook              *Hook              `json:"preHook,omitempty"`
+	PostHook             *Hook              `json:"postHook,omitempty"`
 }
 
 // Label used to store volume and snapshot information
@@ -15,3 +22,46 @@ type Label struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
 }
+
+// CopyDestination used to store details of a region snapshots are copied to
+type CopyDestination struct {
+	Region               string `json:"region"`
+	RetentionPeriodHours int64  `json:"retentionPeriodHours"`
+	KmsKeyID             string `json:"kmsKeyId,omitempty"`
+}
+
+// VaultPolicy used to store details of how snapshots are kept in the vault account
+type VaultPolicy struct {
+	RetentionPeriodHours int64  `json:"retentionPeriodHours"`
+	KmsKeyID             string `json:"kmsKeyId,omitempty"`
+}
+
+// Hook used to store details of an action run around snapshot creation.
+// Exactly one of HTTP and Exec is expected to be set.
+type Hook struct {
+	TimeoutSeconds int64     `json:"timeoutSeconds"`
+	HTTP           *HTTPHook `json:"http,omitempty"`
+	Exec           *ExecHook `json:"exec,omitempty"`
+}
+
+// HTTPHook used to store details of an HTTP request made as a hook
+type HTTPHook struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// ExecHook used to store details of a command executed in the pod that owns the volume's PVC
+type ExecHook struct {
+	Container string   `json:"container,omitempty"`
+	Command   []string `json:"command"`
+}
+
+// Targets type alias for target slice
+type Targets []*Target
+
+// Target used to store a region and account ebs-snapshotter operates in
+type Target struct {
+	Region  string `json:"region"`
+	RoleARN string `json:"roleArn,omitempty"`
+}
@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const eventComponent = "ebs-snapshotter"
+
+// eventReasons maps event types to the reasons of Kubernetes Events
+var eventReasons = map[EventType]string{
+	SnapshotCreated: "SnapshotCreated",
+	SnapshotRemoved: "SnapshotRemoved",
+	SnapshotFailed:  "SnapshotFailed",
+	RPOViolated:     "RPOViolated",
+}
+
+type kubeEventNotifier struct {
+	client kubernetes.Interface
+}
+
+// NewKubeEventNotifier used to create a notifier recording events as Kubernetes Events on the
+// PVC that owns the volume. Events of volumes without a PVC are dropped.
+func NewKubeEventNotifier(client kubernetes.Interface) Notifier {
+	return &kubeEventNotifier{client: client}
+}
+
+func (n *kubeEventNotifier) Notify(ctx context.Context, event *Event) error {
+	if event.PVCName == "" || event.PVCNamespace == "" {
+		return nil
+	}
+
+	pvc, err := n.client.CoreV1().PersistentVolumeClaims(event.PVCNamespace).Get(ctx, event.PVCName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error while fetching PVC %s/%s", event.PVCNamespace, event.PVCName)
+	}
+
+	eventType := corev1.EventTypeNormal
+	if event.Warning() {
+		eventType = corev1.EventTypeWarning
+	}
+	timestamp := metav1.NewTime(event.Time)
+	if _, err := n.client.CoreV1().Events(pvc.Namespace).Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pvc.Name + ".",
+			Namespace:    pvc.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion:      "v1",
+			Kind:            "PersistentVolumeClaim",
+			Name:            pvc.Name,
+			Namespace:       pvc.Namespace,
+			UID:             pvc.UID,
+			ResourceVersion: pvc.ResourceVersion,
+		},
+		Reason:              eventReasons[event.Type],
+		Message:             event.Message,
+		Type:                eventType,
+		Source:              corev1.EventSource{Component: eventComponent},
+		ReportingController: eventComponent,
+		FirstTimestamp:      timestamp,
+		LastTimestamp:       timestamp,
+		Count:               1,
+	}, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "error while creating event for PVC %s/%s", pvc.Namespace, pvc.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type limitedNotifier struct {
+	notifier    Notifier
+	dedupWindow time.Duration
+	maxPerHour  int
+
+	mu sync.Mutex
+	// sent holds the last time an event was sent, by deduplication key
+	sent map[string]time.Time
+	// recent holds the times of the events sent within the last hour
+	recent []time.Time
+}
+
+// NewLimitedNotifier used to wrap a notifier so that an event is dropped when the same event, of the
+// same type for the same volume and snapshot, was sent within the deduplication window. At most
+// maxPerHour events are sent in any hour, which is unlimited when zero.
+func NewLimitedNotifier(notifier Notifier, dedupWindow time.Duration, maxPerHour int) Notifier {
+	return &limitedNotifier{
+		notifier:    notifier,
+		dedupWindow: dedupWindow,
+		maxPerHour:  maxPerHour,
+		sent:        make(map[string]time.Time),
+	}
+}
+
+func (n *limitedNotifier) Notify(ctx context.Context, event *Event) error {
+	if !n.allow(event, time.Now()) {
+		return nil
+	}
+	return n.notifier.Notify(ctx, event)
+}
+
+func (n *limitedNotifier) allow(event *Event, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	key := string(event.Type) + "/" + event.VolumeID + "/" + event.SnapshotID
+	if last, ok := n.sent[key]; ok && now.Sub(last) < n.dedupWindow {
+		return false
+	}
+
+	hourAgo := now.Add(-time.Hour)
+	recent := n.recent[:0]
+	for _, t := range n.recent {
+		if t.After(hourAgo) {
+			recent = append(recent, t)
+		}
+	}
+	n.recent = recent
+	if n.maxPerHour > 0 && len(n.recent) >= n.maxPerHour {
+		return false
+	}
+
+	// Keys outside the window are forgotten, so that the map doesn't grow with every snapshot
+	for k, t := range n.sent {
+		if now.Sub(t) >= n.dedupWindow {
+			delete(n.sent, k)
+		}
+	}
+	n.sent[key] = now
+	n.recent = append(n.recent, now)
+	return true
+}
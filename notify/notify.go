@@ -0,0 +1,114 @@
+// Package notify sends notifications about the lifecycle of snapshots, such as failures and RPO violations
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EventType is the kind of snapshot lifecycle event
+type EventType string
+
+// Event types sent by the watcher
+const (
+	SnapshotCreated EventType = "snapshot_created"
+	SnapshotRemoved EventType = "snapshot_removed"
+	SnapshotFailed  EventType = "snapshot_failed"
+	RPOViolated     EventType = "rpo_violated"
+)
+
+// Event used to store the details of a snapshot lifecycle event
+type Event struct {
+	Type         EventType `json:"type"`
+	Time         time.Time `json:"time"`
+	VolumeID     string    `json:"volumeId"`
+	PVCName      string    `json:"pvcName,omitempty"`
+	PVCNamespace string    `json:"pvcNamespace,omitempty"`
+	SnapshotID   string    `json:"snapshotId,omitempty"`
+	Policy       string    `json:"policy,omitempty"`
+	Message      string    `json:"message"`
+}
+
+// ParseEventTypes used to parse a comma separated list of event types
+func ParseEventTypes(list string) ([]EventType, error) {
+	types := make([]EventType, 0)
+	for _, name := range strings.Split(list, ",") {
+		t := EventType(strings.TrimSpace(name))
+		switch t {
+		case "":
+			continue
+		case SnapshotCreated, SnapshotRemoved, SnapshotFailed, RPOViolated:
+			types = append(types, t)
+		default:
+			return nil, errors.Errorf("unknown event type %s", t)
+		}
+	}
+	return types, nil
+}
+
+// Warning used to check whether the event reports a problem, rather than normal operation
+func (e *Event) Warning() bool {
+	return e.Type == SnapshotFailed || e.Type == RPOViolated
+}
+
+// Subject used to obtain a short description of the volume the event is about
+func (e *Event) Subject() string {
+	if e.PVCName != "" {
+		return fmt.Sprintf("%s/%s (%s)", e.PVCNamespace, e.PVCName, e.VolumeID)
+	}
+	return e.VolumeID
+}
+
+// Notifier interface specifies functions used to send snapshot lifecycle events
+type Notifier interface {
+	Notify(ctx context.Context, event *Event) error
+}
+
+type multiNotifier []Notifier
+
+// NewMulti used to create a notifier sending every event to each of given notifiers
+func NewMulti(notifiers ...Notifier) Notifier {
+	return multiNotifier(notifiers)
+}
+
+// Notify used to send the event to every notifier, even when some of them fail
+func (m multiNotifier) Notify(ctx context.Context, event *Event) error {
+	failed := make([]string, 0)
+	for _, notifier := range m {
+		if err := notifier.Notify(ctx, event); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("error while sending notifications: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+type filterNotifier struct {
+	notifier Notifier
+	types    map[EventType]bool
+}
+
+// NewFilter used to create a notifier that only passes events of given types to the notifier
+func NewFilter(notifier Notifier, types ...EventType) Notifier {
+	f := &filterNotifier{
+		notifier: notifier,
+		types:    make(map[EventType]bool),
+	}
+	for _, t := range types {
+		f.types[t] = true
+	}
+	return f
+}
+
+func (f *filterNotifier) Notify(ctx context.Context, event *Event) error {
+	if !f.types[event.Type] {
+		return nil
+	}
+	return f.notifier.Notify(ctx, event)
+}
@@ -0,0 +1,150 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/notify"
+	. "gopkg.in/check.v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Suite(&NotifySuite{})
+
+type NotifySuite struct{}
+
+func TestNotify(t *testing.T) { TestingT(t) }
+
+func (s *NotifySuite) TestDuplicateEventsDropped(c *C) {
+	recorder := &recordingNotifier{}
+	notifier := notify.NewLimitedNotifier(recorder, time.Hour, 0)
+
+	c.Assert(notifier.Notify(context.Background(), event(notify.SnapshotFailed, "vol-1")), IsNil)
+	c.Assert(notifier.Notify(context.Background(), event(notify.SnapshotFailed, "vol-1")), IsNil)
+	c.Assert(notifier.Notify(context.Background(), event(notify.SnapshotFailed, "vol-2")), IsNil)
+	c.Assert(notifier.Notify(context.Background(), event(notify.RPOViolated, "vol-1")), IsNil)
+
+	c.Assert(recorder.events, HasLen, 3)
+}
+
+func (s *NotifySuite) TestEventsRateLimited(c *C) {
+	recorder := &recordingNotifier{}
+	notifier := notify.NewLimitedNotifier(recorder, time.Hour, 2)
+
+	for _, volumeID := range []string{"vol-1", "vol-2", "vol-3"} {
+		c.Assert(notifier.Notify(context.Background(), event(notify.SnapshotFailed, volumeID)), IsNil)
+	}
+
+	c.Assert(recorder.events, HasLen, 2)
+}
+
+func (s *NotifySuite) TestFilterPassesGivenTypesOnly(c *C) {
+	recorder := &recordingNotifier{}
+	notifier := notify.NewFilter(recorder, notify.SnapshotFailed, notify.RPOViolated)
+
+	c.Assert(notifier.Notify(context.Background(), event(notify.SnapshotCreated, "vol-1")), IsNil)
+	c.Assert(notifier.Notify(context.Background(), event(notify.RPOViolated, "vol-1")), IsNil)
+
+	c.Assert(recorder.events, HasLen, 1)
+	c.Assert(recorder.events[0].Type, Equals, notify.RPOViolated)
+}
+
+func (s *NotifySuite) TestParseEventTypes(c *C) {
+	types, err := notify.ParseEventTypes("snapshot_failed, rpo_violated")
+	c.Assert(err, IsNil)
+	c.Assert(types, DeepEquals, []notify.EventType{notify.SnapshotFailed, notify.RPOViolated})
+
+	_, err = notify.ParseEventTypes("snapshot_failed,unknown")
+	c.Assert(err, NotNil)
+}
+
+func (s *NotifySuite) TestWebhookPostsEvent(c *C) {
+	var received notify.Event
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Content-Type"), Equals, "application/json")
+		c.Check(json.NewDecoder(r.Body).Decode(&received), IsNil)
+	}))
+	defer server.Close()
+
+	notifier := notify.NewWebhookNotifier(server.Client(), server.URL)
+	c.Assert(notifier.Notify(context.Background(), event(notify.SnapshotFailed, "vol-1")), IsNil)
+
+	c.Assert(received.Type, Equals, notify.SnapshotFailed)
+	c.Assert(received.VolumeID, Equals, "vol-1")
+	c.Assert(received.PVCName, Equals, "data")
+}
+
+func (s *NotifySuite) TestSlackMessageSent(c *C) {
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		c.Check(json.NewDecoder(r.Body).Decode(&received), IsNil)
+	}))
+	defer server.Close()
+
+	notifier := notify.NewSlackNotifier(server.Client(), server.URL)
+	c.Assert(notifier.Notify(context.Background(), event(notify.RPOViolated, "vol-1")), IsNil)
+
+	c.Assert(received["text"], Equals, ":warning: *rpo_violated* default/data (vol-1): something happened")
+}
+
+func (s *NotifySuite) TestWebhookErrorStatusReturned(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	notifier := notify.NewWebhookNotifier(server.Client(), server.URL)
+	c.Assert(notifier.Notify(context.Background(), event(notify.SnapshotFailed, "vol-1")), NotNil)
+}
+
+func (s *NotifySuite) TestKubeEventRecordedOnPVC(c *C) {
+	client := fake.NewSimpleClientset(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default", UID: "pvc-uid"},
+	})
+	notifier := notify.NewKubeEventNotifier(client)
+
+	c.Assert(notifier.Notify(context.Background(), event(notify.SnapshotFailed, "vol-1")), IsNil)
+
+	events, err := client.CoreV1().Events("default").List(context.Background(), metav1.ListOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(events.Items, HasLen, 1)
+	c.Assert(events.Items[0].Reason, Equals, "SnapshotFailed")
+	c.Assert(events.Items[0].Type, Equals, corev1.EventTypeWarning)
+	c.Assert(events.Items[0].InvolvedObject.Kind, Equals, "PersistentVolumeClaim")
+	c.Assert(string(events.Items[0].InvolvedObject.UID), Equals, "pvc-uid")
+}
+
+func (s *NotifySuite) TestKubeEventDroppedWithoutPVC(c *C) {
+	client := fake.NewSimpleClientset()
+	notifier := notify.NewKubeEventNotifier(client)
+
+	c.Assert(notifier.Notify(context.Background(), &notify.Event{Type: notify.SnapshotFailed, VolumeID: "vol-1"}), IsNil)
+
+	c.Assert(client.Actions(), HasLen, 0)
+}
+
+func event(eventType notify.EventType, volumeID string) *notify.Event {
+	return &notify.Event{
+		Type:         eventType,
+		Time:         time.Now(),
+		VolumeID:     volumeID,
+		PVCName:      "data",
+		PVCNamespace: "default",
+		Message:      "something happened",
+	}
+}
+
+type recordingNotifier struct {
+	events []*notify.Event
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, event *notify.Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
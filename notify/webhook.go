@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+type webhookNotifier struct {
+	httpClient *http.Client
+	url        string
+	payload    func(event *Event) interface{}
+}
+
+// NewWebhookNotifier used to create a notifier posting every event as JSON to given URL
+func NewWebhookNotifier(httpClient *http.Client, url string) Notifier {
+	return &webhookNotifier{
+		httpClient: httpClient,
+		url:        url,
+		payload:    func(event *Event) interface{} { return event },
+	}
+}
+
+// slackMessage used to store the payload accepted by Slack compatible incoming webhooks
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// NewSlackNotifier used to create a notifier posting every event to a Slack compatible incoming webhook
+func NewSlackNotifier(httpClient *http.Client, url string) Notifier {
+	return &webhookNotifier{
+		httpClient: httpClient,
+		url:        url,
+		payload: func(event *Event) interface{} {
+			icon := ":information_source:"
+			if event.Warning() {
+				icon = ":warning:"
+			}
+			return &slackMessage{
+				Text: fmt.Sprintf("%s *%s* %s: %s", icon, event.Type, event.Subject(), event.Message),
+			}
+		},
+	}
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(n.payload(event))
+	if err != nil {
+		return errors.Wrap(err, "error while encoding notification")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "error while creating notification request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error while sending notification")
+	}
+	defer resp.Body.Close()
+	// Drain the body so that the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("notification webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
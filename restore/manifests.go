@@ -0,0 +1,109 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	ebsCSIDriver = "ebs.csi.aws.com"
+	zoneLabel    = "topology.kubernetes.io/zone"
+)
+
+// ManifestOptions used to store the names of the PV and PVC created for a restored volume
+type ManifestOptions struct {
+	PVName       string
+	PVCName      string
+	Namespace    string
+	StorageClass string
+}
+
+// Manifests used to build a PV bound to the restored volume and a PVC bound to that PV
+func Manifests(volume *ec2.Volume, options *ManifestOptions) (*corev1.PersistentVolume, *corev1.PersistentVolumeClaim) {
+	size := resource.MustParse(fmt.Sprintf("%dGi", aws.Int64Value(volume.Size)))
+	storageClass := options.StorageClass
+
+	pv := &corev1.PersistentVolume{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolume"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "ebs-snapshotter",
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: size},
+			AccessModes:                   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			StorageClassName:              storageClass,
+			ClaimRef: &corev1.ObjectReference{
+				Namespace: options.Namespace,
+				Name:      options.PVCName,
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       ebsCSIDriver,
+					VolumeHandle: *volume.VolumeId,
+				},
+			},
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{
+							MatchExpressions: []corev1.NodeSelectorRequirement{
+								{
+									Key:      zoneLabel,
+									Operator: corev1.NodeSelectorOpIn,
+									Values:   []string{aws.StringValue(volume.AvailabilityZone)},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      options.PVCName,
+			Namespace: options.Namespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			// An explicit, possibly empty, class prevents the default class from provisioning a new volume
+			StorageClassName: &storageClass,
+			VolumeName:       options.PVName,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		},
+	}
+
+	return pv, pvc
+}
+
+// Apply used to create the PV and PVC of a restored volume
+func Apply(
+	ctx context.Context,
+	client kubernetes.Interface,
+	pv *corev1.PersistentVolume,
+	pvc *corev1.PersistentVolumeClaim) error {
+
+	if _, err := client.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "error while creating PV %s", pv.Name)
+	}
+	if _, err := client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "error while creating PVC %s/%s", pvc.Namespace, pvc.Name)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+// Package restore creates EBS volumes, and optionally matching PV and PVC objects, from snapshots
+package restore
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+)
+
+const (
+	// RestoredFromTag is the tag key used to store the snapshot ID a volume was restored from
+	RestoredFromTag = "ebs-snapshotter/restored-from"
+
+	pvcNameTag      = "kubernetes.io/created-for/pvc/name"
+	pvcNamespaceTag = "kubernetes.io/created-for/pvc/namespace"
+
+	// createdForTagPrefix is the prefix of the tags naming the PV and PVC a volume was provisioned for
+	createdForTagPrefix = "kubernetes.io/created-for/"
+	// csiTagPrefix is the prefix of the tags the EBS CSI driver sets on the volumes it manages
+	csiTagPrefix = "ebs.csi.aws.com/"
+)
+
+// csiTags are the tags the EBS CSI driver identifies its volumes and snapshots by
+var csiTags = map[string]bool{
+	"CSIVolumeName":         true,
+	"CSIVolumeSnapshotName": true,
+}
+
+// Request used to store how the snapshot to restore is chosen and how the new volume is created.
+// The snapshot is chosen by exactly one of SnapshotID, VolumeID or PVCName.
+type Request struct {
+	SnapshotID   string
+	VolumeID     string
+	PVCName      string
+	PVCNamespace string
+	// PointInTime selects the latest snapshot started at or before it, when not set the latest snapshot is used
+	PointInTime time.Time
+
+	AvailabilityZone string
+	VolumeType       string
+	Iops             int64
+	Throughput       int64
+}
+
+// Restore used to create a new volume from the snapshot chosen by the request. The volume gets the
+// tags of the snapshot and of its source volume, if it still exists, except for those identifying the
+// original volume or protecting the snapshot.
+func Restore(ctx context.Context, client clients.EBSClient, req *Request) (*ec2.Volume, *ec2.Snapshot, error) {
+	if req.AvailabilityZone == "" {
+		return nil, nil, errors.New("availability zone is required")
+	}
+
+	volumes, err := client.GetVolumes(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error while fetching volumes")
+	}
+	snapshots, err := client.GetSnapshots(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error while fetching snapshots")
+	}
+
+	snapshot, err := SelectSnapshot(volumes, snapshots, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	volume, err := client.CreateVolume(ctx, snapshot, &clients.VolumeOptions{
+		AvailabilityZone: req.AvailabilityZone,
+		VolumeType:       req.VolumeType,
+		Iops:             req.Iops,
+		Throughput:       req.Throughput,
+		Tags:             restoredTags(snapshot, volumes[aws.StringValue(snapshot.VolumeId)]),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return volume, snapshot, nil
+}
+
+// SelectSnapshot used to choose the latest completed snapshot matching the request
+func SelectSnapshot(volumes clients.EC2Volumes, snapshots clients.EC2Snapshots, req *Request) (*ec2.Snapshot, error) {
+	candidates, err := candidateSnapshots(volumes, snapshots, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected *ec2.Snapshot
+	for _, snapshot := range candidates {
+		if aws.StringValue(snapshot.State) != ec2.SnapshotStateCompleted {
+			continue
+		}
+		if !req.PointInTime.IsZero() && snapshot.StartTime.After(req.PointInTime) {
+			continue
+		}
+		if selected == nil || snapshot.StartTime.After(*selected.StartTime) {
+			selected = snapshot
+		}
+	}
+
+	if selected == nil {
+		return nil, errors.New("no completed snapshot matches the request")
+	}
+	return selected, nil
+}
+
+func candidateSnapshots(volumes clients.EC2Volumes, snapshots clients.EC2Snapshots, req *Request) ([]*ec2.Snapshot, error) {
+	candidates := make([]*ec2.Snapshot, 0)
+	switch {
+	case req.SnapshotID != "":
+		for _, snaps := range snapshots {
+			for _, snapshot := range snaps {
+				if *snapshot.SnapshotId == req.SnapshotID {
+					candidates = append(candidates, snapshot)
+				}
+			}
+		}
+	case req.VolumeID != "":
+		candidates = append(candidates, snapshots[req.VolumeID]...)
+	case req.PVCName != "":
+		// Older snapshots may not carry the PVC tags, so the tags of their volume are checked too
+		for volumeID, snaps := range snapshots {
+			volume := volumes[volumeID]
+			for _, snapshot := range snaps {
+				if hasPVCTags(snapshot.Tags, req.PVCName, req.PVCNamespace) ||
+					(volume != nil && hasPVCTags(volume.Tags, req.PVCName, req.PVCNamespace)) {
+					candidates = append(candidates, snapshot)
+				}
+			}
+		}
+	default:
+		return nil, errors.New("one of snapshot ID, volume ID or PVC name is required")
+	}
+	return candidates, nil
+}
+
+func hasPVCTags(tags []*ec2.Tag, name, namespace string) bool {
+	nameMatches := false
+	namespaceMatches := namespace == ""
+	for _, tag := range tags {
+		switch *tag.Key {
+		case pvcNameTag:
+			nameMatches = *tag.Value == name
+		case pvcNamespaceTag:
+			namespaceMatches = namespaceMatches || *tag.Value == namespace
+		}
+	}
+	return nameMatches && namespaceMatches
+}
+
+// restoredTags used to obtain the tags of a volume restored from given snapshot. The ebs-snapshotter
+// control tags are left out, so that a hold doesn't carry over to every snapshot of the new volume, and
+// so are the Kubernetes and CSI tags, so that the new volume isn't taken for the original PVC's.
+func restoredTags(snapshot *ec2.Snapshot, volume *ec2.Volume) []*ec2.Tag {
+	tags := restorableTags(snapshot.Tags)
+	if volume != nil {
+		seen := make(map[string]bool)
+		for _, tag := range tags {
+			seen[*tag.Key] = true
+		}
+		for _, tag := range restorableTags(volume.Tags) {
+			if !seen[*tag.Key] {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return append(tags, &ec2.Tag{
+		Key:   aws.String(RestoredFromTag),
+		Value: snapshot.SnapshotId,
+	})
+}
+
+func restorableTags(tags []*ec2.Tag) []*ec2.Tag {
+	output := make([]*ec2.Tag, 0, len(tags))
+	for _, tag := range clients.WithoutControlTags(clients.CopyableTags(tags)) {
+		if strings.HasPrefix(*tag.Key, createdForTagPrefix) || strings.HasPrefix(*tag.Key, csiTagPrefix) || csiTags[*tag.Key] {
+			continue
+		}
+		output = append(output, tag)
+	}
+	return output
+}
@@ -0,0 +1,266 @@
+package restore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/restore"
+	. "gopkg.in/check.v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Suite(&RestoreSuite{})
+
+type RestoreSuite struct{}
+
+func TestRestore(t *testing.T) { TestingT(t) }
+
+func (s *RestoreSuite) TestLatestCompletedSnapshotSelectedForVolume(c *C) {
+	timeNow := time.Now()
+	snapshots := clients.EC2Snapshots{
+		"vol-1": {
+			createFakeSnapshot("snap-1", "vol-1", "completed", timeNow.Add(-2*time.Hour)),
+			createFakeSnapshot("snap-2", "vol-1", "completed", timeNow.Add(-1*time.Hour)),
+			createFakeSnapshot("snap-3", "vol-1", "pending", timeNow),
+		},
+	}
+
+	snapshot, err := restore.SelectSnapshot(clients.EC2Volumes{}, snapshots, &restore.Request{VolumeID: "vol-1"})
+
+	c.Assert(err, IsNil)
+	c.Assert(*snapshot.SnapshotId, Equals, "snap-2")
+}
+
+func (s *RestoreSuite) TestSnapshotSelectedAtPointInTime(c *C) {
+	timeNow := time.Now()
+	snapshots := clients.EC2Snapshots{
+		"vol-1": {
+			createFakeSnapshot("snap-1", "vol-1", "completed", timeNow.Add(-3*time.Hour)),
+			createFakeSnapshot("snap-2", "vol-1", "completed", timeNow.Add(-2*time.Hour)),
+			createFakeSnapshot("snap-3", "vol-1", "completed", timeNow.Add(-1*time.Hour)),
+		},
+	}
+
+	snapshot, err := restore.SelectSnapshot(clients.EC2Volumes{}, snapshots, &restore.Request{
+		VolumeID:    "vol-1",
+		PointInTime: timeNow.Add(-90 * time.Minute),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(*snapshot.SnapshotId, Equals, "snap-2")
+
+	_, err = restore.SelectSnapshot(clients.EC2Volumes{}, snapshots, &restore.Request{
+		VolumeID:    "vol-1",
+		PointInTime: timeNow.Add(-4 * time.Hour),
+	})
+	c.Assert(err, NotNil)
+}
+
+func (s *RestoreSuite) TestSnapshotSelectedForPVCOfDeletedVolume(c *C) {
+	timeNow := time.Now()
+	deleted := createFakeSnapshot("snap-1", "vol-1", "completed", timeNow.Add(-1*time.Hour))
+	deleted.Tags = pvcTags("datadir-kafka-0", "kafka")
+	other := createFakeSnapshot("snap-2", "vol-2", "completed", timeNow)
+	other.Tags = pvcTags("datadir-kafka-0", "other")
+	snapshots := clients.EC2Snapshots{
+		"vol-1": {deleted},
+		"vol-2": {other},
+	}
+
+	snapshot, err := restore.SelectSnapshot(clients.EC2Volumes{}, snapshots, &restore.Request{
+		PVCName:      "datadir-kafka-0",
+		PVCNamespace: "kafka",
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(*snapshot.SnapshotId, Equals, "snap-1")
+}
+
+func (s *RestoreSuite) TestVolumeCreatedWithSnapshotAndVolumeTags(c *C) {
+	snapshot := createFakeSnapshot("snap-1", "vol-1", "completed", time.Now())
+	snapshot.Tags = []*ec2.Tag{
+		{Key: aws.String("team"), Value: aws.String("data")},
+		{Key: aws.String("aws:backup:source-resource"), Value: aws.String("vol-1")},
+	}
+	client := &fakeEBSClient{
+		volumes: clients.EC2Volumes{
+			"vol-1": {
+				VolumeId: aws.String("vol-1"),
+				Tags:     []*ec2.Tag{{Key: aws.String("team"), Value: aws.String("other")}, {Key: aws.String("env"), Value: aws.String("prod")}},
+			},
+		},
+		snapshots: clients.EC2Snapshots{"vol-1": {snapshot}},
+	}
+
+	volume, restored, err := restore.Restore(context.Background(), client, &restore.Request{
+		SnapshotID:       "snap-1",
+		AvailabilityZone: "eu-west-1a",
+		VolumeType:       "gp3",
+		Iops:             4000,
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(*restored.SnapshotId, Equals, "snap-1")
+	c.Assert(*volume.VolumeId, Equals, "vol-restored")
+	c.Assert(client.options.AvailabilityZone, Equals, "eu-west-1a")
+	c.Assert(client.options.VolumeType, Equals, "gp3")
+	c.Assert(client.options.Iops, Equals, int64(4000))
+	c.Assert(tagsToMap(client.options.Tags), DeepEquals, map[string]string{
+		"team":                  "data",
+		"env":                   "prod",
+		restore.RestoredFromTag: "snap-1",
+	})
+}
+
+func (s *RestoreSuite) TestVolumeRestoredFromHeldSnapshotNotHeldOrTakenForPVC(c *C) {
+	snapshot := createFakeSnapshot("snap-1", "vol-1", "completed", time.Now())
+	snapshot.Tags = append(pvcTags("datadir-kafka-0", "kafka"),
+		&ec2.Tag{Key: aws.String("team"), Value: aws.String("data")},
+		&ec2.Tag{Key: aws.String(clients.HoldTag), Value: aws.String("true")},
+		&ec2.Tag{Key: aws.String(clients.RetainUntilTag), Value: aws.String("2030-01-01T00:00:00Z")},
+		&ec2.Tag{Key: aws.String(clients.LabelTag), Value: aws.String("before-upgrade")},
+		&ec2.Tag{Key: aws.String(clients.SourceSnapshotIDTag), Value: aws.String("snap-0")},
+		&ec2.Tag{Key: aws.String(clients.SourceVolumeIDTag), Value: aws.String("vol-0")},
+		&ec2.Tag{Key: aws.String("CSIVolumeSnapshotName"), Value: aws.String("snapcontent-1")},
+		&ec2.Tag{Key: aws.String("ebs.csi.aws.com/cluster"), Value: aws.String("true")})
+	client := &fakeEBSClient{
+		volumes: clients.EC2Volumes{
+			"vol-1": {
+				VolumeId: aws.String("vol-1"),
+				Tags: append(pvcTags("datadir-kafka-0", "kafka"),
+					&ec2.Tag{Key: aws.String("kubernetes.io/created-for/pv/name"), Value: aws.String("pvc-1")},
+					&ec2.Tag{Key: aws.String("CSIVolumeName"), Value: aws.String("pvc-1")},
+					&ec2.Tag{Key: aws.String("env"), Value: aws.String("prod")}),
+			},
+		},
+		snapshots: clients.EC2Snapshots{"vol-1": {snapshot}},
+	}
+
+	_, _, err := restore.Restore(context.Background(), client, &restore.Request{
+		SnapshotID:       "snap-1",
+		AvailabilityZone: "eu-west-1a",
+	})
+
+	c.Assert(err, IsNil)
+	tags := client.options.Tags
+	c.Assert(tagsToMap(tags), DeepEquals, map[string]string{
+		"team":                  "data",
+		"env":                   "prod",
+		restore.RestoredFromTag: "snap-1",
+	})
+	restored := &ec2.Snapshot{Tags: tags}
+	c.Assert(clients.Protected(restored, time.Now()), Equals, false)
+}
+
+func (s *RestoreSuite) TestAvailabilityZoneRequired(c *C) {
+	client := &fakeEBSClient{}
+
+	_, _, err := restore.Restore(context.Background(), client, &restore.Request{VolumeID: "vol-1"})
+
+	c.Assert(err, NotNil)
+	c.Assert(client.options, IsNil)
+}
+
+func (s *RestoreSuite) TestManifestsBindPVCToRestoredVolume(c *C) {
+	volume := &ec2.Volume{
+		VolumeId:         aws.String("vol-restored"),
+		AvailabilityZone: aws.String("eu-west-1a"),
+		Size:             aws.Int64(100),
+	}
+
+	pv, pvc := restore.Manifests(volume, &restore.ManifestOptions{
+		PVName:    "restored-vol-restored",
+		PVCName:   "datadir-kafka-0-restored",
+		Namespace: "kafka",
+	})
+
+	c.Assert(pv.Spec.CSI.VolumeHandle, Equals, "vol-restored")
+	c.Assert(pv.Spec.PersistentVolumeReclaimPolicy, Equals, corev1.PersistentVolumeReclaimRetain)
+	c.Assert(pv.Spec.ClaimRef.Name, Equals, "datadir-kafka-0-restored")
+	c.Assert(pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values, DeepEquals, []string{"eu-west-1a"})
+	c.Assert(pv.Spec.Capacity.Storage().String(), Equals, "100Gi")
+	c.Assert(pvc.Spec.VolumeName, Equals, "restored-vol-restored")
+	c.Assert(*pvc.Spec.StorageClassName, Equals, "")
+}
+
+type fakeEBSClient struct {
+	volumes   clients.EC2Volumes
+	snapshots clients.EC2Snapshots
+	options   *clients.VolumeOptions
+}
+
+func (f *fakeEBSClient) GetVolumes(ctx context.Context) (clients.EC2Volumes, error) {
+	return f.volumes, nil
+}
+
+func (f *fakeEBSClient) GetSnapshots(ctx context.Context) (clients.EC2Snapshots, error) {
+	return f.snapshots, nil
+}
+
+func (f *fakeEBSClient) CreateSnapshot(ctx context.Context, volume *ec2.Volume, tags []*ec2.Tag) (*ec2.Snapshot, error) {
+	return &ec2.Snapshot{VolumeId: volume.VolumeId}, nil
+}
+
+func (f *fakeEBSClient) RemoveSnapshot(ctx context.Context, snapshot *ec2.Snapshot) error {
+	return nil
+}
+
+func (f *fakeEBSClient) CopySnapshot(ctx context.Context, snapshot *ec2.Snapshot, sourceRegion, kmsKeyID string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeEBSClient) GetSnapshotCopies(ctx context.Context) (clients.EC2Snapshots, error) {
+	return clients.EC2Snapshots{}, nil
+}
+
+func (f *fakeEBSClient) ShareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string) error {
+	return nil
+}
+
+func (f *fakeEBSClient) UnshareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string, keepKeyGrant bool) error {
+	return nil
+}
+
+func (f *fakeEBSClient) CreateVolume(ctx context.Context, snapshot *ec2.Snapshot, options *clients.VolumeOptions) (*ec2.Volume, error) {
+	f.options = options
+	return &ec2.Volume{
+		VolumeId:         aws.String("vol-restored"),
+		AvailabilityZone: aws.String(options.AvailabilityZone),
+		SnapshotId:       snapshot.SnapshotId,
+	}, nil
+}
+
+func (f *fakeEBSClient) TagSnapshot(ctx context.Context, snapshotID string, tags []*ec2.Tag) error {
+	return nil
+}
+
+func (f *fakeEBSClient) UntagSnapshot(ctx context.Context, snapshotID string, keys []string) error {
+	return nil
+}
+
+func createFakeSnapshot(id, volumeID, state string, startTime time.Time) *ec2.Snapshot {
+	return &ec2.Snapshot{
+		SnapshotId: aws.String(id),
+		VolumeId:   aws.String(volumeID),
+		State:      aws.String(state),
+		StartTime:  aws.Time(startTime),
+	}
+}
+
+func pvcTags(name, namespace string) []*ec2.Tag {
+	return []*ec2.Tag{
+		{Key: aws.String("kubernetes.io/created-for/pvc/name"), Value: aws.String(name)},
+		{Key: aws.String("kubernetes.io/created-for/pvc/namespace"), Value: aws.String(namespace)},
+	}
+}
+
+func tagsToMap(tags []*ec2.Tag) map[string]string {
+	output := make(map[string]string)
+	for _, tag := range tags {
+		output[*tag.Key] = *tag.Value
+	}
+	return output
+}
@@ -0,0 +1,158 @@
+// Package status exposes the health and the outcome of the last cycle over HTTP
+package status
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	w "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+)
+
+const (
+	// OutcomePending is the outcome reported before the first cycle completed
+	OutcomePending = "pending"
+	// OutcomeSuccess is the outcome of a cycle that completed for every target
+	OutcomeSuccess = "success"
+	// OutcomeError is the outcome of a cycle that failed for at least one target
+	OutcomeError = "error"
+)
+
+// Status used to store the outcome of the last cycle, as served by the `/status` endpoint
+type Status struct {
+	Ready             bool            `json:"ready"`
+	LastCycleTime     *time.Time      `json:"lastCycleTime,omitempty"`
+	LastCycleDuration string          `json:"lastCycleDuration,omitempty"`
+	Outcome           string          `json:"outcome"`
+	LastError         string          `json:"lastError,omitempty"`
+	Policies          []PolicyStatus  `json:"policies"`
+	Volumes           []w.VolumeState `json:"volumes"`
+}
+
+// PolicyStatus used to store the effective settings of a policy. Hooks are left out as they
+// may hold credentials.
+type PolicyStatus struct {
+	Name                 string       `json:"name"`
+	VolumeID             string       `json:"volumeId,omitempty"`
+	Labels               models.Label `json:"labels"`
+	IntervalSeconds      int64        `json:"intervalSeconds"`
+	RetentionPeriodHours int64        `json:"retentionPeriodHours"`
+	RPOSeconds           int64        `json:"rpoSeconds"`
+	CopyTo               []string     `json:"copyTo,omitempty"`
+	Vault                bool         `json:"vault,omitempty"`
+}
+
+// Tracker used to record the state of the service for the health, readiness and status endpoints
+type Tracker struct {
+	logger *slog.Logger
+
+	mu           sync.RWMutex
+	configLoaded bool
+	discovered   bool
+	status       Status
+}
+
+// NewTracker used to create a tracker that isn't ready until the config is loaded and the
+// first discovery succeeded
+func NewTracker(logger *slog.Logger) *Tracker {
+	return &Tracker{
+		logger: logger,
+		status: Status{
+			Outcome:  OutcomePending,
+			Policies: []PolicyStatus{},
+			Volumes:  []w.VolumeState{},
+		},
+	}
+}
+
+// ConfigLoaded used to record that the config file was loaded
+func (t *Tracker) ConfigLoaded() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.configLoaded = true
+}
+
+// Discovered used to record that the policies were discovered. It is expected to be called only
+// when every discoverer succeeded, or when none is configured.
+func (t *Tracker) Discovered(config models.VolumeSnapshotConfigs) {
+	policies := make([]PolicyStatus, 0, len(config))
+	for _, policy := range config {
+		p := PolicyStatus{
+			Name:                 w.PolicyName(policy),
+			VolumeID:             policy.VolumeID,
+			Labels:               policy.Labels,
+			IntervalSeconds:      policy.IntervalSeconds,
+			RetentionPeriodHours: policy.RetentionPeriodHours,
+			RPOSeconds:           int64(w.RPOThreshold(policy).Seconds()),
+			Vault:                policy.Vault != nil,
+		}
+		for _, dest := range policy.CopyTo {
+			p.CopyTo = append(p.CopyTo, dest.Region)
+		}
+		policies = append(policies, p)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.discovered = true
+	t.status.Policies = policies
+}
+
+// CycleCompleted used to record the outcome of a cycle
+func (t *Tracker) CycleCompleted(start time.Time, states []w.VolumeState, cycleErr error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.LastCycleTime = &start
+	t.status.LastCycleDuration = time.Since(start).String()
+	t.status.Outcome = OutcomeSuccess
+	t.status.LastError = ""
+	if cycleErr != nil {
+		t.status.Outcome = OutcomeError
+		t.status.LastError = cycleErr.Error()
+	}
+	if states == nil {
+		states = []w.VolumeState{}
+	}
+	t.status.Volumes = states
+}
+
+// Ready used to check whether the config is loaded and the first discovery succeeded
+func (t *Tracker) Ready() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.configLoaded && t.discovered
+}
+
+// Status used to obtain the outcome of the last cycle
+func (t *Tracker) Status() Status {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	status := t.status
+	status.Ready = t.configLoaded && t.discovered
+	return status
+}
+
+// Register used to add the `/healthz`, `/readyz` and `/status` endpoints to given mux
+func (t *Tracker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(rw http.ResponseWriter, r *http.Request) {
+		if !t.Ready() {
+			http.Error(rw, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		rw.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/status", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(t.Status()); err != nil {
+			t.logger.Error("failed to write status", logging.Error, err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package status_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"github.com/utilitywarehouse/ebs-snapshotter/status"
+	w "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&StatusSuite{})
+
+type StatusSuite struct{}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestStatus(t *testing.T) { TestingT(t) }
+
+func (s *StatusSuite) TestReadyOnlyOnceConfigLoadedAndDiscovered(c *C) {
+	tracker := status.NewTracker(discardLogger)
+	mux := http.NewServeMux()
+	tracker.Register(mux)
+
+	c.Assert(get(mux, "/healthz").Code, Equals, http.StatusOK)
+	c.Assert(get(mux, "/readyz").Code, Equals, http.StatusServiceUnavailable)
+
+	tracker.ConfigLoaded()
+	c.Assert(get(mux, "/readyz").Code, Equals, http.StatusServiceUnavailable)
+
+	tracker.Discovered(models.VolumeSnapshotConfigs{})
+	c.Assert(get(mux, "/readyz").Code, Equals, http.StatusOK)
+}
+
+func (s *StatusSuite) TestStatusReportsLastCycle(c *C) {
+	tracker := status.NewTracker(discardLogger)
+	mux := http.NewServeMux()
+	tracker.Register(mux)
+
+	tracker.ConfigLoaded()
+	tracker.Discovered(models.VolumeSnapshotConfigs{
+		{
+			Name:                 "kafka",
+			Labels:               models.Label{Key: "app", Value: "kafka"},
+			IntervalSeconds:      3600,
+			RetentionPeriodHours: 24,
+			CopyTo:               []*models.CopyDestination{{Region: "eu-central-1"}},
+			PreHook: &models.Hook{
+				HTTP: &models.HTTPHook{URL: "http://kafka", Headers: map[string]string{"Authorization": "secret"}},
+			},
+		},
+	})
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tracker.CycleCompleted(start, []w.VolumeState{{VolumeID: "volume-1", Policy: "kafka", SnapshotCount: 2}},
+		errors.New("error while fetching volumes"))
+
+	rec := get(mux, "/status")
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), Not(Matches), "(?s).*secret.*")
+
+	var got status.Status
+	c.Assert(json.NewDecoder(rec.Body).Decode(&got), IsNil)
+	c.Assert(got.Ready, Equals, true)
+	c.Assert(got.LastCycleTime.Equal(start), Equals, true)
+	c.Assert(got.Outcome, Equals, status.OutcomeError)
+	c.Assert(got.LastError, Equals, "error while fetching volumes")
+	c.Assert(got.Policies, DeepEquals, []status.PolicyStatus{
+		{
+			Name:                 "kafka",
+			Labels:               models.Label{Key: "app", Value: "kafka"},
+			IntervalSeconds:      3600,
+			RetentionPeriodHours: 24,
+			RPOSeconds:           7200,
+			CopyTo:               []string{"eu-central-1"},
+		},
+	})
+	c.Assert(got.Volumes[0].VolumeID, Equals, "volume-1")
+	c.Assert(got.Volumes[0].SnapshotCount, Equals, 2)
+}
+
+func get(handler http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	metaBucket       = []byte("meta")
+	volumesBucket    = []byte("volumes")
+	schemaVersionKey = []byte("schemaVersion")
+)
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore used to create a store keeping records in an embedded database at given path, which
+// is created when missing. Records of each scope are kept in their own bucket.
+func NewBoltStore(path string) (StateStore, error) {
+	// The file is locked while open, so wait a little for a previous process to exit
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while opening state database %s", path)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(volumesBucket); err != nil {
+			return err
+		}
+		if meta.Get(schemaVersionKey) == nil {
+			return meta.Put(schemaVersionKey, []byte(strconv.Itoa(SchemaVersion)))
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "error while initialising state database")
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Load(ctx context.Context, scope string) (map[string]*VolumeRecord, error) {
+	var version int
+	raw := make(map[string][]byte)
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		v, err := strconv.Atoi(string(tx.Bucket(metaBucket).Get(schemaVersionKey)))
+		if err != nil {
+			return errors.Wrap(err, "error while reading state schema version")
+		}
+		version = v
+
+		bucket := tx.Bucket(volumesBucket).Bucket([]byte(scope))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			// Values are only valid during the transaction
+			raw[string(k)] = append([]byte{}, v...)
+			return nil
+		})
+	}); err != nil {
+		return nil, errors.Wrap(err, "error while loading state")
+	}
+
+	return decodeRecords(version, raw)
+}
+
+// Save used to replace the records of given scope. Every scope is written at the current schema
+// version, so records of other scopes are migrated as well when the version changes.
+func (s *boltStore) Save(ctx context.Context, scope string, records map[string]*VolumeRecord) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		version, err := strconv.Atoi(string(meta.Get(schemaVersionKey)))
+		if err != nil {
+			return errors.Wrap(err, "error while reading state schema version")
+		}
+		volumes := tx.Bucket(volumesBucket)
+		if version != SchemaVersion {
+			if err := migrateBuckets(volumes, version); err != nil {
+				return err
+			}
+			if err := meta.Put(schemaVersionKey, []byte(strconv.Itoa(SchemaVersion))); err != nil {
+				return err
+			}
+		}
+
+		if volumes.Bucket([]byte(scope)) != nil {
+			if err := volumes.DeleteBucket([]byte(scope)); err != nil {
+				return err
+			}
+		}
+		bucket, err := volumes.CreateBucket([]byte(scope))
+		if err != nil {
+			return err
+		}
+		for volumeID, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return errors.Wrapf(err, "error while encoding state of volume %s", volumeID)
+			}
+			if err := bucket.Put([]byte(volumeID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return errors.Wrap(err, "error while saving state")
+}
+
+// migrateBuckets used to migrate the records of every scope from given schema version in place
+func migrateBuckets(volumes *bolt.Bucket, version int) error {
+	return volumes.ForEach(func(scope, _ []byte) error {
+		bucket := volumes.Bucket(scope)
+		upgraded := make(map[string][]byte)
+		if err := bucket.ForEach(func(k, v []byte) error {
+			data, err := Upgrade(v, version, SchemaVersion, migrations)
+			if err != nil {
+				return errors.Wrapf(err, "error while migrating state of volume %s", k)
+			}
+			upgraded[string(k)] = data
+			return nil
+		}); err != nil {
+			return err
+		}
+		for k, v := range upgraded {
+			if err := bucket.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const configMapSchemaVersionKey = "schemaVersion"
+
+type configMapStore struct {
+	client          kubernetes.Interface
+	namespace, name string
+}
+
+// NewConfigMapStore used to create a store keeping records in a ConfigMap, which is created when
+// missing. Each record is kept under a `<scope>.<volume ID>` key, with slashes of the scope replaced
+// by dots. A ConfigMap holds at most 1MiB, which is enough for a few thousand volumes.
+func NewConfigMapStore(client kubernetes.Interface, namespace, name string) StateStore {
+	return &configMapStore{client: client, namespace: namespace, name: name}
+}
+
+func (s *configMapStore) Load(ctx context.Context, scope string) (map[string]*VolumeRecord, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return make(map[string]*VolumeRecord), nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while fetching state ConfigMap %s/%s", s.namespace, s.name)
+	}
+
+	version, err := configMapVersion(cm)
+	if err != nil {
+		return nil, err
+	}
+	prefix := scopeKey(scope) + "."
+	raw := make(map[string][]byte)
+	for key, value := range cm.Data {
+		if strings.HasPrefix(key, prefix) {
+			raw[strings.TrimPrefix(key, prefix)] = []byte(value)
+		}
+	}
+	return decodeRecords(version, raw)
+}
+
+// Save used to replace the records of given scope. Conflicting updates fail, rather than being
+// retried, as the records are saved again on the next cycle.
+func (s *configMapStore) Save(ctx context.Context, scope string, records map[string]*VolumeRecord) error {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	create := kerrors.IsNotFound(err)
+	if err != nil && !create {
+		return errors.Wrapf(err, "error while fetching state ConfigMap %s/%s", s.namespace, s.name)
+	}
+	if create {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.name,
+				Namespace: s.namespace,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "ebs-snapshotter"},
+			},
+			Data: map[string]string{configMapSchemaVersionKey: strconv.Itoa(SchemaVersion)},
+		}
+	}
+
+	version, err := configMapVersion(cm)
+	if err != nil {
+		return err
+	}
+	data := make(map[string]string, len(cm.Data))
+	prefix := scopeKey(scope) + "."
+	for key, value := range cm.Data {
+		switch {
+		case key == configMapSchemaVersionKey, strings.HasPrefix(key, prefix):
+			continue
+		default:
+			// Records of other scopes are kept, migrated to the current schema version
+			upgraded, err := Upgrade([]byte(value), version, SchemaVersion, migrations)
+			if err != nil {
+				return errors.Wrapf(err, "error while migrating state %s", key)
+			}
+			data[key] = string(upgraded)
+		}
+	}
+	for volumeID, record := range records {
+		value, err := json.Marshal(record)
+		if err != nil {
+			return errors.Wrapf(err, "error while encoding state of volume %s", volumeID)
+		}
+		data[prefix+volumeID] = string(value)
+	}
+	data[configMapSchemaVersionKey] = strconv.Itoa(SchemaVersion)
+	cm.Data = data
+
+	if create {
+		_, err = s.client.CoreV1().ConfigMaps(s.namespace).Create(ctx, cm, metav1.CreateOptions{})
+	} else {
+		_, err = s.client.CoreV1().ConfigMaps(s.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	return errors.Wrapf(err, "error while saving state ConfigMap %s/%s", s.namespace, s.name)
+}
+
+func (s *configMapStore) Close() error {
+	return nil
+}
+
+// configMapVersion used to obtain the schema version of the records in a ConfigMap
+func configMapVersion(cm *corev1.ConfigMap) (int, error) {
+	value, ok := cm.Data[configMapSchemaVersionKey]
+	if !ok {
+		return SchemaVersion, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid state schema version %s", value)
+	}
+	return version, nil
+}
+
+// scopeKey used to turn a scope into a valid ConfigMap key
+func scopeKey(scope string) string {
+	return strings.Replace(scope, "/", ".", -1)
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// SchemaVersion is the version of the schema of the stored volume records
+const SchemaVersion = 1
+
+// Migration used to upgrade a stored volume record from one schema version to the next
+type Migration func(record map[string]interface{}) error
+
+// migrations holds the migration from each schema version to the next, by the version it upgrades
+// from. Add one, and bump SchemaVersion, whenever VolumeRecord changes in a way that records
+// stored by an older version can't be read as they are.
+var migrations = map[int]Migration{}
+
+// Upgrade used to migrate a stored record from given schema version to a later one, applying each
+// migration in turn. Records from a later version than `to` are refused, as they may not be
+// understood, such as after a rollback.
+func Upgrade(data []byte, from, to int, migrations map[int]Migration) ([]byte, error) {
+	if from > to {
+		return nil, errors.Errorf("state schema version %d is newer than supported version %d", from, to)
+	}
+	if from == to {
+		return data, nil
+	}
+
+	record := make(map[string]interface{})
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, errors.Wrap(err, "error while decoding stored record")
+	}
+	for version := from; version < to; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return nil, errors.Errorf("no migration from state schema version %d", version)
+		}
+		if err := migrate(record); err != nil {
+			return nil, errors.Wrapf(err, "error while migrating state from schema version %d", version)
+		}
+	}
+	return json.Marshal(record)
+}
+
+// decodeRecords used to decode stored records of given schema version, migrating them to the current one
+func decodeRecords(version int, raw map[string][]byte) (map[string]*VolumeRecord, error) {
+	if version > SchemaVersion {
+		return nil, errors.Errorf("state schema version %d is newer than supported version %d", version, SchemaVersion)
+	}
+	records := make(map[string]*VolumeRecord, len(raw))
+	for volumeID, data := range raw {
+		data, err := Upgrade(data, version, SchemaVersion, migrations)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error while loading state of volume %s", volumeID)
+		}
+		record := &VolumeRecord{}
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, errors.Wrapf(err, "error while decoding state of volume %s", volumeID)
+		}
+		records[volumeID] = record
+	}
+	return records, nil
+}
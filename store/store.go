@@ -0,0 +1,136 @@
+// Package store persists the watcher's scheduling decisions and in-flight operations, so that
+// they survive restarts instead of being re-derived from AWS
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// OperationCopy is the type of a pending snapshot copy
+const OperationCopy = "copy"
+
+const (
+	// minBackoff is waited after the first failure to create a snapshot of a volume
+	minBackoff = time.Minute
+	// maxBackoff is the longest wait between attempts, however many failed
+	maxBackoff = time.Hour
+)
+
+// Operation used to store an operation started but not yet seen to complete, such as a copy
+type Operation struct {
+	Type        string    `json:"type"`
+	SnapshotID  string    `json:"snapshotId"`
+	Destination string    `json:"destination,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+}
+
+// HookResult used to store the outcome of the last run of a hook
+type HookResult struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// VolumeRecord used to store what the watcher last did for a volume and what it is waiting for
+type VolumeRecord struct {
+	VolumeID       string                 `json:"volumeId"`
+	LastAction     string                 `json:"lastAction,omitempty"`
+	LastActionTime time.Time              `json:"lastActionTime,omitempty"`
+	LastSnapshotID string                 `json:"lastSnapshotId,omitempty"`
+	LastError      string                 `json:"lastError,omitempty"`
+	Failures       int                    `json:"failures,omitempty"`
+	RetryAfter     time.Time              `json:"retryAfter,omitempty"`
+	Pending        []*Operation           `json:"pending,omitempty"`
+	Hooks          map[string]*HookResult `json:"hooks,omitempty"`
+}
+
+// StateStore interface specifies functions used to persist volume records. Records are kept per
+// scope, such as a region and account, so that several watchers can share a store.
+type StateStore interface {
+	Load(ctx context.Context, scope string) (map[string]*VolumeRecord, error)
+	Save(ctx context.Context, scope string, records map[string]*VolumeRecord) error
+	Close() error
+}
+
+// SetLastAction used to record an action taken for the volume and its outcome
+func (r *VolumeRecord) SetLastAction(action, snapshotID string, err error, now time.Time) {
+	r.LastAction = action
+	r.LastActionTime = now
+	r.LastSnapshotID = snapshotID
+	r.LastError = ""
+	if err != nil {
+		r.LastError = err.Error()
+	}
+}
+
+// Retry used to record a failed attempt to create a snapshot, delaying the next attempt
+// exponentially with every consecutive failure
+func (r *VolumeRecord) Retry(now time.Time) {
+	r.Failures++
+	r.RetryAfter = now.Add(Backoff(r.Failures))
+}
+
+// ResetRetries used to record that creating a snapshot succeeded
+func (r *VolumeRecord) ResetRetries() {
+	r.Failures = 0
+	r.RetryAfter = time.Time{}
+}
+
+// RetryDue used to check whether the backoff after a failed attempt has passed
+func (r *VolumeRecord) RetryDue(now time.Time) bool {
+	return !now.Before(r.RetryAfter)
+}
+
+// Backoff used to obtain the wait after given number of consecutive failures
+func Backoff(failures int) time.Duration {
+	backoff := minBackoff
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// SetHookResult used to record the outcome of a hook run
+func (r *VolumeRecord) SetHookResult(hook string, err error, now time.Time) {
+	if r.Hooks == nil {
+		r.Hooks = make(map[string]*HookResult)
+	}
+	result := &HookResult{Time: now}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	r.Hooks[hook] = result
+}
+
+// AddPending used to record an operation that was started
+func (r *VolumeRecord) AddPending(operation *Operation) {
+	r.Pending = append(r.Pending, operation)
+}
+
+// FindPending used to obtain a pending operation of given type, snapshot and destination, or nil
+func (r *VolumeRecord) FindPending(opType, snapshotID, destination string) *Operation {
+	for _, op := range r.Pending {
+		if op.Type == opType && op.SnapshotID == snapshotID && op.Destination == destination {
+			return op
+		}
+	}
+	return nil
+}
+
+// ClearPending used to forget the pending operations of given type and destination that are done
+func (r *VolumeRecord) ClearPending(opType, destination string, done func(op *Operation) bool) {
+	pending := r.Pending[:0]
+	for _, op := range r.Pending {
+		if op.Type == opType && op.Destination == destination && done(op) {
+			continue
+		}
+		pending = append(pending, op)
+	}
+	if len(pending) == 0 {
+		pending = nil
+	}
+	r.Pending = pending
+}
@@ -0,0 +1,185 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/store"
+	bolt "go.etcd.io/bbolt"
+	. "gopkg.in/check.v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Suite(&StoreSuite{})
+
+type StoreSuite struct{}
+
+func TestStore(t *testing.T) { TestingT(t) }
+
+func (s *StoreSuite) TestBoltRecordsSavedPerScope(c *C) {
+	path := filepath.Join(c.MkDir(), "state.db")
+	stateStore, err := store.NewBoltStore(path)
+	c.Assert(err, IsNil)
+
+	assertRecordsSavedPerScope(c, stateStore)
+	c.Assert(stateStore.Close(), IsNil)
+
+	// Records survive reopening the database, such as after a restart
+	stateStore, err = store.NewBoltStore(path)
+	c.Assert(err, IsNil)
+	defer stateStore.Close()
+	records, err := stateStore.Load(context.Background(), "eu-west-1/111")
+	c.Assert(err, IsNil)
+	c.Assert(records["vol-1"], NotNil)
+	c.Assert(records["vol-1"].Failures, Equals, 2)
+}
+
+func (s *StoreSuite) TestBoltNewerSchemaRefused(c *C) {
+	path := filepath.Join(c.MkDir(), "state.db")
+	stateStore, err := store.NewBoltStore(path)
+	c.Assert(err, IsNil)
+	c.Assert(stateStore.Save(context.Background(), "eu-west-1/111", sampleRecords()), IsNil)
+	c.Assert(stateStore.Close(), IsNil)
+
+	db, err := bolt.Open(path, 0600, nil)
+	c.Assert(err, IsNil)
+	c.Assert(db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("meta")).Put([]byte("schemaVersion"), []byte("99"))
+	}), IsNil)
+	c.Assert(db.Close(), IsNil)
+
+	stateStore, err = store.NewBoltStore(path)
+	c.Assert(err, IsNil)
+	defer stateStore.Close()
+	_, err = stateStore.Load(context.Background(), "eu-west-1/111")
+	c.Assert(err, ErrorMatches, ".*newer than supported.*")
+}
+
+func (s *StoreSuite) TestConfigMapRecordsSavedPerScope(c *C) {
+	client := fake.NewSimpleClientset()
+	stateStore := store.NewConfigMapStore(client, "kube-system", "ebs-snapshotter-state")
+
+	assertRecordsSavedPerScope(c, stateStore)
+
+	cm, err := client.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "ebs-snapshotter-state", metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(cm.Data["schemaVersion"], Equals, "1")
+	c.Assert(cm.Data["eu-west-1.111.vol-1"], Not(Equals), "")
+	c.Assert(cm.Data["eu-west-1.222.vol-2"], Not(Equals), "")
+}
+
+func (s *StoreSuite) TestConfigMapMissingLoadedEmpty(c *C) {
+	stateStore := store.NewConfigMapStore(fake.NewSimpleClientset(), "kube-system", "ebs-snapshotter-state")
+
+	records, err := stateStore.Load(context.Background(), "eu-west-1/111")
+
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 0)
+}
+
+func (s *StoreSuite) TestUpgradeAppliesMigrationsInTurn(c *C) {
+	migrations := map[int]store.Migration{
+		1: func(record map[string]interface{}) error {
+			record["lastAction"] = record["action"]
+			delete(record, "action")
+			return nil
+		},
+		2: func(record map[string]interface{}) error {
+			record["failures"] = 0
+			return nil
+		},
+	}
+
+	data, err := store.Upgrade([]byte(`{"volumeId": "vol-1", "action": "create_snapshot", "failures": 3}`), 1, 3, migrations)
+
+	c.Assert(err, IsNil)
+	record := &store.VolumeRecord{}
+	c.Assert(json.Unmarshal(data, record), IsNil)
+	c.Assert(record.VolumeID, Equals, "vol-1")
+	c.Assert(record.LastAction, Equals, "create_snapshot")
+	c.Assert(record.Failures, Equals, 0)
+}
+
+func (s *StoreSuite) TestUpgradeFailsWithoutMigration(c *C) {
+	_, err := store.Upgrade([]byte(`{}`), 1, 2, map[int]store.Migration{})
+	c.Assert(err, ErrorMatches, "no migration from state schema version 1")
+}
+
+func (s *StoreSuite) TestBackoffDoublesUpToMaximum(c *C) {
+	c.Assert(store.Backoff(1), Equals, time.Minute)
+	c.Assert(store.Backoff(2), Equals, 2*time.Minute)
+	c.Assert(store.Backoff(4), Equals, 8*time.Minute)
+	c.Assert(store.Backoff(20), Equals, time.Hour)
+
+	now := time.Now()
+	record := &store.VolumeRecord{}
+	c.Assert(record.RetryDue(now), Equals, true)
+	record.Retry(now)
+	c.Assert(record.RetryDue(now.Add(30*time.Second)), Equals, false)
+	c.Assert(record.RetryDue(now.Add(time.Minute)), Equals, true)
+	record.ResetRetries()
+	c.Assert(record.Failures, Equals, 0)
+	c.Assert(record.RetryDue(now), Equals, true)
+}
+
+func (s *StoreSuite) TestPendingOperationsCleared(c *C) {
+	record := &store.VolumeRecord{}
+	record.AddPending(&store.Operation{Type: store.OperationCopy, SnapshotID: "snap-1", Destination: "eu-central-1"})
+	record.AddPending(&store.Operation{Type: store.OperationCopy, SnapshotID: "snap-1", Destination: "us-east-1"})
+
+	record.ClearPending(store.OperationCopy, "eu-central-1", func(op *store.Operation) bool { return true })
+
+	c.Assert(record.FindPending(store.OperationCopy, "snap-1", "eu-central-1"), IsNil)
+	c.Assert(record.FindPending(store.OperationCopy, "snap-1", "us-east-1"), NotNil)
+}
+
+func assertRecordsSavedPerScope(c *C, stateStore store.StateStore) {
+	ctx := context.Background()
+	c.Assert(stateStore.Save(ctx, "eu-west-1/111", sampleRecords()), IsNil)
+	c.Assert(stateStore.Save(ctx, "eu-west-1/222", map[string]*store.VolumeRecord{
+		"vol-2": {VolumeID: "vol-2", LastAction: "remove_snapshot"},
+	}), IsNil)
+
+	records, err := stateStore.Load(ctx, "eu-west-1/111")
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 1)
+	record := records["vol-1"]
+	c.Assert(record.LastAction, Equals, "create_snapshot")
+	c.Assert(record.LastError, Equals, "throttled")
+	c.Assert(record.Failures, Equals, 2)
+	c.Assert(record.Pending, HasLen, 1)
+	c.Assert(record.Pending[0].Destination, Equals, "eu-central-1")
+	c.Assert(record.Hooks["pre"].Error, Equals, "")
+
+	records, err = stateStore.Load(ctx, "eu-west-1/222")
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 1)
+	c.Assert(records["vol-2"].LastAction, Equals, "remove_snapshot")
+
+	// Saving replaces the records of the scope, forgetting volumes no longer handled
+	c.Assert(stateStore.Save(ctx, "eu-west-1/222", map[string]*store.VolumeRecord{}), IsNil)
+	records, err = stateStore.Load(ctx, "eu-west-1/222")
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 0)
+}
+
+func sampleRecords() map[string]*store.VolumeRecord {
+	now := time.Now().UTC().Truncate(time.Second)
+	record := &store.VolumeRecord{VolumeID: "vol-1"}
+	record.SetLastAction("create_snapshot", "", errors.New("throttled"), now)
+	record.Retry(now)
+	record.Retry(now)
+	record.SetHookResult("pre", nil, now)
+	record.AddPending(&store.Operation{
+		Type:        store.OperationCopy,
+		SnapshotID:  "snap-1",
+		Destination: "eu-central-1",
+		StartedAt:   now,
+	})
+	return map[string]*store.VolumeRecord{"vol-1": record}
+}
@@ -0,0 +1,70 @@
+// Package tracing sets up the OpenTelemetry tracer provider and its exporter
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Exporters supported by Setup
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Shutdown used to flush the spans not yet exported and to stop the exporter
+type Shutdown func(ctx context.Context) error
+
+// Setup used to create a tracer provider exporting spans with given exporter and to make it the
+// global provider. The OTLP exporter is configured by the standard OTEL_EXPORTER_OTLP_* variables,
+// such as OTEL_EXPORTER_OTLP_ENDPOINT, and the stdout exporter writes to given writer. Spans are
+// exported in batches, so the returned Shutdown must be called before exiting.
+func Setup(ctx context.Context, exporter, serviceName, version string, out io.Writer) (trace.TracerProvider, Shutdown, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case ExporterNone, "":
+		provider := noop.NewTracerProvider()
+		otel.SetTracerProvider(provider)
+		return provider, func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(out))
+	case ExporterOTLP:
+		spanExporter, err = otlptracegrpc.New(ctx)
+	default:
+		return nil, nil, errors.Errorf("unknown tracing exporter %s", exporter)
+	}
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error while creating %s trace exporter", exporter)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(version)))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error while creating trace resource")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider, provider.Shutdown, nil
+}
@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+)
+
+// CatalogEntry used to describe the snapshots of a volume matched by a policy
+type CatalogEntry struct {
+	VolumeID           string     `json:"volumeId"`
+	PVCName            string     `json:"pvcName,omitempty"`
+	PVCNamespace       string     `json:"pvcNamespace,omitempty"`
+	Policy             string     `json:"policy"`
+	SnapshotCount      int        `json:"snapshotCount"`
+	TotalSizeGiB       int64      `json:"totalSizeGiB"`
+	LatestSnapshotTime *time.Time `json:"latestSnapshotTime,omitempty"`
+	NextDue            time.Time  `json:"nextDue"`
+}
+
+// Catalog used to build a catalog entry for every volume matched by given policies, sorted by
+// volume ID. Policies are applied the same way as in WatchSnapshots, the first matching policy wins.
+// The total size is the sum of the source volume sizes, not the billed incremental size.
+func Catalog(
+	config models.VolumeSnapshotConfigs,
+	volumes clients.EC2Volumes,
+	snapshots clients.EC2Snapshots,
+	now time.Time) []CatalogEntry {
+
+	matched := make(map[string]bool)
+	entries := make([]CatalogEntry, 0)
+	for _, policy := range config {
+		for _, volume := range volumes {
+			if matched[*volume.VolumeId] || !MatchesPolicy(policy, volume) {
+				continue
+			}
+			matched[*volume.VolumeId] = true
+			entries = append(entries, newCatalogEntry(policy, volume, snapshots[*volume.VolumeId], now))
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].VolumeID < entries[b].VolumeID
+	})
+	return entries
+}
+
+func newCatalogEntry(
+	config *models.VolumeSnapshotConfig,
+	volume *ec2.Volume,
+	snapshots []*ec2.Snapshot,
+	now time.Time) CatalogEntry {
+
+	entry := CatalogEntry{
+		VolumeID:      *volume.VolumeId,
+		PVCName:       getPVCName(volume.Tags),
+		PVCNamespace:  getPVCNamespace(volume.Tags),
+		Policy:        PolicyName(config),
+		SnapshotCount: len(snapshots),
+		NextDue:       now,
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.VolumeSize != nil {
+			entry.TotalSizeGiB += *snapshot.VolumeSize
+		}
+	}
+	if latest := latestCompletedSnapshot(snapshots); latest != nil {
+		entry.LatestSnapshotTime = latest.StartTime
+	}
+	// Mirrors createNewEBSSnapshot, the latest snapshot counts unless it failed
+	if len(snapshots) > 0 && *snapshots[0].State != "error" {
+		if due := snapshots[0].StartTime.Add(time.Duration(config.IntervalSeconds) * time.Second); due.After(now) {
+			entry.NextDue = due
+		}
+	}
+	return entry
+}
@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/audit"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"github.com/utilitywarehouse/ebs-snapshotter/notify"
+)
+
+// sendEvent used to notify about a snapshot lifecycle event of a volume, when notifications are
+// enabled. Failures are only logged, so that they don't affect snapshots.
+func (w *EBSSnapshotWatcher) sendEvent(
+	ctx context.Context,
+	logger *slog.Logger,
+	eventType notify.EventType,
+	config *models.VolumeSnapshotConfig,
+	volume *ec2.Volume,
+	snapshotID string,
+	format string, args ...interface{}) {
+
+	if w.notifier == nil {
+		return
+	}
+
+	event := &notify.Event{
+		Type:         eventType,
+		Time:         w.clock.Now(),
+		VolumeID:     *volume.VolumeId,
+		PVCName:      getPVCName(volume.Tags),
+		PVCNamespace: getPVCNamespace(volume.Tags),
+		SnapshotID:   snapshotID,
+		Message:      fmt.Sprintf(format, args...),
+	}
+	if config.Name != "" || config.VolumeID != "" || config.Labels.Key != "" {
+		event.Policy = PolicyName(config)
+	}
+
+	if err := w.notifier.Notify(ctx, event); err != nil {
+		logger.Warn("failed to send notification", "event", eventType, logging.Error, err)
+	}
+}
+
+// writeAudit used to record a snapshot created or deleted, or the failure to do so, when auditing is
+// enabled. A record that can't be written is logged and counted as an error.
+func (w *EBSSnapshotWatcher) writeAudit(
+	ctx context.Context,
+	logger *slog.Logger,
+	action, reason string,
+	config *models.VolumeSnapshotConfig,
+	volume *ec2.Volume,
+	snapshotID string,
+	actionErr error) {
+
+	if w.auditSink == nil {
+		return
+	}
+
+	record := &audit.Record{
+		Time:         w.clock.Now(),
+		Action:       action,
+		VolumeID:     *volume.VolumeId,
+		PVCName:      getPVCName(volume.Tags),
+		PVCNamespace: getPVCNamespace(volume.Tags),
+		SnapshotID:   snapshotID,
+		Reason:       reason,
+		Outcome:      audit.OutcomeSuccess,
+	}
+	if config.Name != "" || config.VolumeID != "" || config.Labels.Key != "" {
+		record.Policy = PolicyName(config)
+	}
+	if actionErr != nil {
+		record.Outcome = audit.OutcomeFailure
+		record.Error = actionErr.Error()
+	}
+
+	if err := w.auditSink.Write(ctx, record); err != nil {
+		w.metrics.Errors.WithLabelValues(record.PVCName, record.PVCNamespace, record.VolumeID, opWriteAudit).Inc()
+		logger.Error("failed to write audit record",
+			logging.SnapshotID, snapshotID,
+			logging.Action, action,
+			logging.Error, err)
+	}
+}
@@ -0,0 +1,214 @@
+package watcher
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "ebs_snapshotter"
+
+// Operation label values of the errors counter
+const (
+	opGetVolumes         = "get_volumes"
+	opGetSnapshots       = "get_snapshots"
+	opCreateSnapshot     = "create_snapshot"
+	opRemoveSnapshot     = "remove_snapshot"
+	opRegisterSnapshot   = "register_snapshot"
+	opUnregisterSnapshot = "unregister_snapshot"
+	opRefreshCopies      = "refresh_copies"
+	opCopySnapshot       = "copy_snapshot"
+	opRemoveCopy         = "remove_copy"
+	opWriteAudit         = "write_audit"
+	opLoadState          = "load_state"
+	opSaveState          = "save_state"
+)
+
+// Reason label values of the skipped removals counter
+const (
+	// reasonInUse is the reason of snapshots that back an AMI, which must be deregistered first
+	reasonInUse = "in_use"
+)
+
+// Metrics used to store the metrics reported by the watcher
+type Metrics struct {
+	SnapshotsCreated, SnapshotsRemoved *prometheus.CounterVec
+	RemovalsSkipped                    *prometheus.CounterVec
+	Snapshots, ProtectedSnapshots      *prometheus.GaugeVec
+	Errors                             *prometheus.CounterVec
+	CopiesCreated, CopiesRemoved       *prometheus.CounterVec
+	ProtectedCopies                    *prometheus.GaugeVec
+	HookErrors                         *prometheus.CounterVec
+
+	LatestSnapshot, LatestSnapshotAge *prometheus.GaugeVec
+	RPOThreshold, RPOViolated         *prometheus.GaugeVec
+
+	CycleDuration     prometheus.ObserverVec
+	LastSuccessfulRun *prometheus.GaugeVec
+	// APILatency is not reported by the watcher, it is expected to be used to instrument its clients
+	APILatency prometheus.ObserverVec
+}
+
+// NewMetrics used to create the watcher metrics and register them with given registry. The extra
+// labels, such as region and account, are expected to be curried before the metrics are used.
+func NewMetrics(registry prometheus.Registerer, extraLabels ...string) *Metrics {
+	volumeLabels := withLabels([]string{"pvc_name", "pvc_namespace", "volume_id"}, extraLabels)
+	labelsWith := func(label string) []string {
+		return withLabels([]string{"pvc_name", "pvc_namespace", "volume_id", label}, extraLabels)
+	}
+
+	m := &Metrics{
+		SnapshotsCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "snapshots_created_total",
+			Help:      "A counter of the total number of snapshots created",
+		}, volumeLabels),
+		SnapshotsRemoved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "snapshots_removed_total",
+			Help:      "A counter of the total number of old snapshots removed",
+		}, volumeLabels),
+		RemovalsSkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "snapshot_removals_skipped_total",
+			Help:      "A counter of the total number of old snapshots and copies that could not be removed, by reason",
+		}, labelsWith("reason")),
+		Snapshots: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "snapshots",
+			Help:      "The number of snapshots of a volume",
+		}, volumeLabels),
+		ProtectedSnapshots: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "protected_snapshots",
+			Help:      "The number of snapshots of a volume held or retained, which retention doesn't remove",
+		}, volumeLabels),
+		Errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "errors_total",
+			Help:      "A counter of the total number of errors encountered, by operation",
+		}, labelsWith("operation")),
+		CopiesCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "snapshot_copies_created_total",
+			Help:      "A counter of the total number of snapshot copies started",
+		}, labelsWith("destination")),
+		CopiesRemoved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "snapshot_copies_removed_total",
+			Help:      "A counter of the total number of old snapshot copies removed",
+		}, labelsWith("destination")),
+		ProtectedCopies: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "protected_snapshot_copies",
+			Help:      "The number of snapshot copies of a volume held or retained, which retention doesn't remove",
+		}, labelsWith("destination")),
+		HookErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "hook_errors_total",
+			Help:      "A counter of the total number of failed snapshot hooks",
+		}, labelsWith("hook")),
+		LatestSnapshot: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "latest_completed_snapshot_timestamp_seconds",
+			Help:      "The start time of the latest completed snapshot of a volume",
+		}, volumeLabels),
+		LatestSnapshotAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "latest_completed_snapshot_age_seconds",
+			Help:      "The age of the latest completed snapshot of a volume when last checked",
+		}, volumeLabels),
+		RPOThreshold: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "rpo_threshold_seconds",
+			Help:      "The maximum age of the latest completed snapshot of a volume allowed by its policy",
+		}, volumeLabels),
+		RPOViolated: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "rpo_violated",
+			Help:      "Whether the latest completed snapshot of a volume is older than its RPO threshold",
+		}, volumeLabels),
+		CycleDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "cycle_duration_seconds",
+			Help:      "The duration of a cycle checking all volumes",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		}, extraLabels),
+		LastSuccessfulRun: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_successful_cycle_timestamp_seconds",
+			Help:      "The time the last cycle completed without failing",
+		}, extraLabels),
+		APILatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_request_duration_seconds",
+			Help:      "The duration of AWS API requests, by operation",
+			Buckets:   prometheus.DefBuckets,
+		}, withLabels([]string{"operation"}, extraLabels)),
+	}
+
+	registry.MustRegister(
+		m.SnapshotsCreated,
+		m.SnapshotsRemoved,
+		m.RemovalsSkipped,
+		m.Snapshots,
+		m.ProtectedSnapshots,
+		m.Errors,
+		m.CopiesCreated,
+		m.CopiesRemoved,
+		m.ProtectedCopies,
+		m.HookErrors,
+		m.LatestSnapshot,
+		m.LatestSnapshotAge,
+		m.RPOThreshold,
+		m.RPOViolated,
+		m.CycleDuration,
+		m.LastSuccessfulRun,
+		m.APILatency)
+
+	return m
+}
+
+// MustCurryWith used to obtain a copy of the metrics with given extra labels set
+func (m *Metrics) MustCurryWith(labels prometheus.Labels) *Metrics {
+	return &Metrics{
+		SnapshotsCreated:   m.SnapshotsCreated.MustCurryWith(labels),
+		SnapshotsRemoved:   m.SnapshotsRemoved.MustCurryWith(labels),
+		RemovalsSkipped:    m.RemovalsSkipped.MustCurryWith(labels),
+		Snapshots:          m.Snapshots.MustCurryWith(labels),
+		ProtectedSnapshots: m.ProtectedSnapshots.MustCurryWith(labels),
+		Errors:             m.Errors.MustCurryWith(labels),
+		CopiesCreated:      m.CopiesCreated.MustCurryWith(labels),
+		CopiesRemoved:      m.CopiesRemoved.MustCurryWith(labels),
+		ProtectedCopies:    m.ProtectedCopies.MustCurryWith(labels),
+		HookErrors:         m.HookErrors.MustCurryWith(labels),
+		LatestSnapshot:     m.LatestSnapshot.MustCurryWith(labels),
+		LatestSnapshotAge:  m.LatestSnapshotAge.MustCurryWith(labels),
+		RPOThreshold:       m.RPOThreshold.MustCurryWith(labels),
+		RPOViolated:        m.RPOViolated.MustCurryWith(labels),
+		CycleDuration:      m.CycleDuration.MustCurryWith(labels),
+		LastSuccessfulRun:  m.LastSuccessfulRun.MustCurryWith(labels),
+		APILatency:         m.APILatency.MustCurryWith(labels),
+	}
+}
+
+// deleteVolume used to remove every series of a volume, so that deleted volumes don't linger
+func (m *Metrics) deleteVolume(volumeID string) {
+	labels := prometheus.Labels{"volume_id": volumeID}
+	m.SnapshotsCreated.DeletePartialMatch(labels)
+	m.SnapshotsRemoved.DeletePartialMatch(labels)
+	m.RemovalsSkipped.DeletePartialMatch(labels)
+	m.Snapshots.DeletePartialMatch(labels)
+	m.ProtectedSnapshots.DeletePartialMatch(labels)
+	m.Errors.DeletePartialMatch(labels)
+	m.CopiesCreated.DeletePartialMatch(labels)
+	m.CopiesRemoved.DeletePartialMatch(labels)
+	m.ProtectedCopies.DeletePartialMatch(labels)
+	m.HookErrors.DeletePartialMatch(labels)
+	m.LatestSnapshot.DeletePartialMatch(labels)
+	m.LatestSnapshotAge.DeletePartialMatch(labels)
+	m.RPOThreshold.DeletePartialMatch(labels)
+	m.RPOViolated.DeletePartialMatch(labels)
+}
+
+func withLabels(base, extra []string) []string {
+	return append(append([]string{}, base...), extra...)
+}
@@ -0,0 +1,122 @@
+package watcher
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/audit"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"github.com/utilitywarehouse/ebs-snapshotter/notify"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrVolumeNotFound is returned when the volume of an on-demand snapshot doesn't exist
+var ErrVolumeNotFound = errors.New("volume not found")
+
+// OnDemandRequest used to store the volume and options of a snapshot requested outside the policy interval.
+// The volume is chosen by either VolumeID or PVCName and PVCNamespace.
+type OnDemandRequest struct {
+	VolumeID     string
+	PVCName      string
+	PVCNamespace string
+	Label        string
+	// RetainUntil keeps the snapshot from being removed by retention until then, when set
+	RetainUntil time.Time
+	// Hold keeps the snapshot from ever being removed by retention
+	Hold bool
+}
+
+// SnapshotVolume used to create a snapshot of a volume immediately. The hooks of the policy that
+// matched the volume in the last cycle are run around it, if any. A cycle in progress is waited for,
+// so that the hooks of the volume don't run twice at once.
+func (w *EBSSnapshotWatcher) SnapshotVolume(ctx context.Context, req *OnDemandRequest) (*ec2.Snapshot, error) {
+	w.cycleMu.Lock()
+	defer w.cycleMu.Unlock()
+
+	ctx, span := w.tracer.Start(ctx, "SnapshotVolume")
+	defer span.End()
+
+	volumes, err := w.ebsClient.GetVolumes(ctx)
+	if err != nil {
+		w.metrics.Errors.WithLabelValues("", "", "", opGetVolumes).Inc()
+		return nil, errors.Wrap(err, "error while fetching volumes")
+	}
+
+	volume := findVolume(volumes, req)
+	if volume == nil {
+		return nil, ErrVolumeNotFound
+	}
+	pvcName := getPVCName(volume.Tags)
+	pvcNamespace := getPVCNamespace(volume.Tags)
+
+	tags := make([]*ec2.Tag, 0)
+	if req.Label != "" {
+		tags = append(tags, &ec2.Tag{Key: aws.String(clients.LabelTag), Value: aws.String(req.Label)})
+	}
+	if !req.RetainUntil.IsZero() {
+		tags = append(tags, &ec2.Tag{
+			Key:   aws.String(clients.RetainUntilTag),
+			Value: aws.String(req.RetainUntil.UTC().Format(time.RFC3339)),
+		})
+	}
+
+	if req.Hold {
+		tags = append(tags, &ec2.Tag{Key: aws.String(clients.HoldTag), Value: aws.String("true")})
+	}
+
+	config := w.policyFor(volume)
+	logger := w.volumeLogger(config, volume, pvcName, pvcNamespace)
+	span.SetAttributes(attribute.String("volume.id", *volume.VolumeId))
+	snapshot, err := createSnapshotWithHooks(ctx, w, logger, config, volume, tags, nil, pvcName, pvcNamespace)
+	if err != nil {
+		w.sendEvent(ctx, logger, notify.SnapshotFailed, config, volume, "", "failed to create an on-demand snapshot: %v", err)
+		w.writeAudit(ctx, logger, audit.ActionCreate, "on_demand", config, volume, "", err)
+		return nil, err
+	}
+	w.writeAudit(ctx, logger, audit.ActionCreate, "on_demand", config, volume, aws.StringValue(snapshot.SnapshotId), nil)
+
+	logger.Info("created a new snapshot",
+		logging.SnapshotID, aws.StringValue(snapshot.SnapshotId),
+		logging.Action, "create_snapshot",
+		logging.Reason, "on_demand",
+		"label", req.Label,
+		"hold", req.Hold)
+	w.sendEvent(ctx, logger, notify.SnapshotCreated, config, volume, aws.StringValue(snapshot.SnapshotId),
+		"created on-demand snapshot %s", aws.StringValue(snapshot.SnapshotId))
+	w.metrics.SnapshotsCreated.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Inc()
+	return snapshot, nil
+}
+
+// policyFor used to obtain the policy that matched given volume in the last cycle, or an empty
+// policy when there is none
+func (w *EBSSnapshotWatcher) policyFor(volume *ec2.Volume) *models.VolumeSnapshotConfig {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	for _, config := range w.policies {
+		if MatchesPolicy(config, volume) {
+			return config
+		}
+	}
+	return &models.VolumeSnapshotConfig{}
+}
+
+func findVolume(volumes clients.EC2Volumes, req *OnDemandRequest) *ec2.Volume {
+	for _, volume := range volumes {
+		if req.VolumeID != "" {
+			if *volume.VolumeId == req.VolumeID {
+				return volume
+			}
+			continue
+		}
+		if req.PVCName != "" && getPVCName(volume.Tags) == req.PVCName && getPVCNamespace(volume.Tags) == req.PVCNamespace {
+			return volume
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ReconcileVolume used to check a single volume straight away, such as when an EBS event reports that
+// a snapshot of it completed or failed, or that it was created or deleted. The volume is handled by the
+// policies of the last cycle, and volumes that no longer exist or match no policy are forgotten.
+// Nothing is done before the first cycle, as the policies aren't known yet.
+func (w *EBSSnapshotWatcher) ReconcileVolume(ctx context.Context, volumeID string) (err error) {
+	w.cycleMu.Lock()
+	defer w.cycleMu.Unlock()
+
+	ctx, span := w.tracer.Start(ctx, "ReconcileVolume", trace.WithAttributes(attribute.String("volume.id", volumeID)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	w.mu.RLock()
+	policies := w.policies
+	w.mu.RUnlock()
+	if policies == nil {
+		w.logger.Debug("skipped reconciling volume before the first cycle", logging.VolumeID, volumeID)
+		return nil
+	}
+
+	volumes, err := w.ebsClient.GetVolumes(ctx)
+	if err != nil {
+		w.metrics.Errors.WithLabelValues("", "", volumeID, opGetVolumes).Inc()
+		return errors.Wrap(err, "error while fetching volumes")
+	}
+	volume := findVolume(volumes, &OnDemandRequest{VolumeID: volumeID})
+	config := matchingPolicy(policies, volume)
+	if config == nil {
+		w.updateVolumeState(ctx, volumeID, nil)
+		return nil
+	}
+
+	snapshots, err := w.ebsClient.GetSnapshots(ctx)
+	if err != nil {
+		w.metrics.Errors.WithLabelValues("", "", volumeID, opGetSnapshots).Inc()
+		return errors.Wrap(err, "error while fetching snapshots")
+	}
+
+	w.loadRecords(ctx)
+	state := w.watchVolume(ctx, config, volume, snapshots[volumeID], make(map[string]clients.EC2Snapshots))
+	w.updateVolumeState(ctx, volumeID, state)
+	return nil
+}
+
+// matchingPolicy used to obtain the first policy matching a volume, or nil when there is none or no volume
+func matchingPolicy(policies models.VolumeSnapshotConfigs, volume *ec2.Volume) *models.VolumeSnapshotConfig {
+	if volume == nil {
+		return nil
+	}
+	for _, config := range policies {
+		if MatchesPolicy(config, volume) {
+			return config
+		}
+	}
+	return nil
+}
+
+// updateVolumeState used to replace the state of a single volume, or to forget it when the state is
+// nil, keeping the states of every other volume from the last cycle
+func (w *EBSSnapshotWatcher) updateVolumeState(ctx context.Context, volumeID string, state *VolumeState) {
+	w.mu.Lock()
+	states := make(map[string]*VolumeState, len(w.states))
+	for id, s := range w.states {
+		states[id] = s
+	}
+	if state != nil {
+		states[volumeID] = state
+	} else if _, ok := states[volumeID]; ok {
+		delete(states, volumeID)
+		w.metrics.deleteVolume(volumeID)
+	}
+	w.states = states
+	w.mu.Unlock()
+
+	w.saveRecords(ctx, states)
+}
@@ -0,0 +1,62 @@
+package watcher
+
+import (
+	"context"
+
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
+	"github.com/utilitywarehouse/ebs-snapshotter/store"
+)
+
+// SetStateStore used to enable keeping the last actions, pending copies, hook results and retry
+// backoff of volumes across cycles and restarts. Records are kept under given scope of the store.
+func (w *EBSSnapshotWatcher) SetStateStore(stateStore store.StateStore, scope string) {
+	w.stateStore = stateStore
+	w.stateScope = scope
+	w.records = make(map[string]*store.VolumeRecord)
+}
+
+// loadRecords used to load the volume records at the start of a cycle. The records of the last cycle
+// are kept when the store fails, so that a store outage doesn't stop snapshots.
+func (w *EBSSnapshotWatcher) loadRecords(ctx context.Context) {
+	if w.stateStore == nil {
+		return
+	}
+	records, err := w.stateStore.Load(ctx, w.stateScope)
+	if err != nil {
+		w.metrics.Errors.WithLabelValues("", "", "", opLoadState).Inc()
+		w.logger.Error("failed to load state, using the state of the last cycle", logging.Error, err)
+		return
+	}
+	w.records = records
+}
+
+// saveRecords used to save the records of the volumes handled in a cycle, forgetting any other volume
+func (w *EBSSnapshotWatcher) saveRecords(ctx context.Context, states map[string]*VolumeState) {
+	if w.stateStore == nil {
+		return
+	}
+	records := make(map[string]*store.VolumeRecord, len(states))
+	for volumeID := range states {
+		if record, ok := w.records[volumeID]; ok {
+			records[volumeID] = record
+		}
+	}
+	w.records = records
+	if err := w.stateStore.Save(ctx, w.stateScope, records); err != nil {
+		w.metrics.Errors.WithLabelValues("", "", "", opSaveState).Inc()
+		w.logger.Error("failed to save state", logging.Error, err)
+	}
+}
+
+// volumeRecord used to obtain the record of a volume, which is nil when no state store is set
+func (w *EBSSnapshotWatcher) volumeRecord(volumeID string) *store.VolumeRecord {
+	if w.stateStore == nil {
+		return nil
+	}
+	record, ok := w.records[volumeID]
+	if !ok {
+		record = &store.VolumeRecord{VolumeID: volumeID}
+		w.records[volumeID] = record
+	}
+	return record
+}
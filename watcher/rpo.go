@@ -0,0 +1,63 @@
+package watcher
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+)
+
+// rpoIntervalMultiplier is the number of missed intervals tolerated before the RPO is violated,
+// so that a snapshot still pending at the next interval doesn't trigger it
+const rpoIntervalMultiplier = 2
+
+// RPOThreshold used to obtain the maximum age of the latest completed snapshot of a policy's volumes.
+// It defaults to twice the policy's interval when the policy doesn't set `rpoSeconds`.
+func RPOThreshold(config *models.VolumeSnapshotConfig) time.Duration {
+	if config.RPOSeconds > 0 {
+		return time.Duration(config.RPOSeconds) * time.Second
+	}
+	return rpoIntervalMultiplier * time.Duration(config.IntervalSeconds) * time.Second
+}
+
+// RPOViolated used to check whether the latest completed snapshot of a volume is older than the
+// threshold. Volumes without any completed snapshot are given the threshold from their creation.
+func RPOViolated(threshold time.Duration, volume *ec2.Volume, latest *ec2.Snapshot, now time.Time) bool {
+	switch {
+	case latest != nil:
+		return now.Sub(*latest.StartTime) > threshold
+	case volume.CreateTime != nil:
+		return now.Sub(*volume.CreateTime) > threshold
+	default:
+		return true
+	}
+}
+
+// recordRPO used to report the recovery point of a volume. The latest snapshot gauge is set to the start
+// time of the latest completed snapshot, the age gauge to its age at the time of the check.
+// Returns true when the RPO is violated.
+func recordRPO(
+	w *EBSSnapshotWatcher,
+	config *models.VolumeSnapshotConfig,
+	volume *ec2.Volume,
+	latest *ec2.Snapshot,
+	pvcName, pvcNamespace string) bool {
+
+	now := w.clock.Now()
+	threshold := RPOThreshold(config)
+	labels := []string{pvcName, pvcNamespace, *volume.VolumeId}
+
+	if latest != nil {
+		w.metrics.LatestSnapshot.WithLabelValues(labels...).Set(float64(latest.StartTime.Unix()))
+		w.metrics.LatestSnapshotAge.WithLabelValues(labels...).Set(now.Sub(*latest.StartTime).Seconds())
+	}
+	w.metrics.RPOThreshold.WithLabelValues(labels...).Set(threshold.Seconds())
+
+	violated := RPOViolated(threshold, volume, latest, now)
+	value := 0.0
+	if violated {
+		value = 1
+	}
+	w.metrics.RPOViolated.WithLabelValues(labels...).Set(value)
+	return violated
+}
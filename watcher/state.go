@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/models"
+)
+
+// VolumeState used to store the outcome of the last cycle for a volume
+type VolumeState struct {
+	VolumeID           string     `json:"volumeId"`
+	PVCName            string     `json:"pvcName,omitempty"`
+	PVCNamespace       string     `json:"pvcNamespace,omitempty"`
+	Policy             string     `json:"policy"`
+	SnapshotCount      int        `json:"snapshotCount"`
+	LatestSnapshotTime *time.Time `json:"latestSnapshotTime,omitempty"`
+	LastError          string     `json:"lastError,omitempty"`
+	CheckedAt          time.Time  `json:"checkedAt"`
+}
+
+// States used to obtain the state of every volume handled in the last cycle, sorted by volume ID
+func (w *EBSSnapshotWatcher) States() []VolumeState {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	states := make([]VolumeState, 0, len(w.states))
+	for _, state := range w.states {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(a, b int) bool {
+		return states[a].VolumeID < states[b].VolumeID
+	})
+	return states
+}
+
+// ForgetVolumes used to stop reporting the volumes of the last cycle, such as once another replica
+// leads, so that their series don't linger unchanged. The next cycle handles every volume afresh.
+func (w *EBSSnapshotWatcher) ForgetVolumes() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for volumeID := range w.states {
+		w.metrics.deleteVolume(volumeID)
+	}
+	w.states = make(map[string]*VolumeState)
+	w.policies = nil
+}
+
+// PolicyName used to obtain the name of a policy, falling back to its volume ID or label
+func PolicyName(config *models.VolumeSnapshotConfig) string {
+	switch {
+	case config.Name != "":
+		return config.Name
+	case config.VolumeID != "":
+		return fmt.Sprintf("volume/%s", config.VolumeID)
+	default:
+		return fmt.Sprintf("%s=%s", config.Labels.Key, config.Labels.Value)
+	}
+}
+
+func newVolumeState(
+	config *models.VolumeSnapshotConfig,
+	volume *ec2.Volume,
+	snapshots []*ec2.Snapshot,
+	pvcName, pvcNamespace string,
+	now time.Time) *VolumeState {
+
+	state := &VolumeState{
+		VolumeID:      *volume.VolumeId,
+		PVCName:       pvcName,
+		PVCNamespace:  pvcNamespace,
+		Policy:        PolicyName(config),
+		SnapshotCount: len(snapshots),
+		CheckedAt:     now,
+	}
+	if latest := latestCompletedSnapshot(snapshots); latest != nil {
+		state.LatestSnapshotTime = latest.StartTime
+	}
+	return state
+}
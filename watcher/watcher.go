@@ -1,117 +1,366 @@
 package watcher
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/utilitywarehouse/ebs-snapshotter/audit"
 	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/clock"
+	"github.com/utilitywarehouse/ebs-snapshotter/hooks"
+	"github.com/utilitywarehouse/ebs-snapshotter/kube"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
 	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"github.com/utilitywarehouse/ebs-snapshotter/notify"
+	"github.com/utilitywarehouse/ebs-snapshotter/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	pvcName      = "kubernetes.io/created-for/pvc/name"
 	pvcNamespace = "kubernetes.io/created-for/pvc/namespace"
+
+	preHook  = "pre"
+	postHook = "post"
+
+	// vaultDestination is the destination label used for copies in the vault account
+	vaultDestination = "vault"
+
+	tracerName = "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+
+	// requestDelay is waited between removals so that we don't exceed AWS request limits
+	requestDelay = 2 * time.Second
+
+	// pendingCopyExpiry is how long a started copy is waited for to be listed before it may be started again
+	pendingCopyExpiry = 24 * time.Hour
 )
 
 // Watcher interface specifies EBS snapshot watcher functions
 type Watcher interface {
-	WatchSnapshots(config *models.VolumeSnapshotConfigs)
+	WatchSnapshots(ctx context.Context, config *models.VolumeSnapshotConfigs) error
 }
 
 // EBSSnapshotWatcher used to check EC2 EBS snapshots
 type EBSSnapshotWatcher struct {
-	ebsClient                         clients.EBSClient
-	crCounter, delCounter, errCounter *prometheus.CounterVec
-	snapshotCounter                   *prometheus.GaugeVec
+	ebsClient clients.EBSClient
+	metrics   *Metrics
+	logger    *slog.Logger
+	tracer    trace.Tracer
+	clock     clock.Clock
+
+	copier, vaultCopier clients.SnapshotCopier
+	vaultRegion         string
+
+	hookRunner hooks.Runner
+
+	registrar kube.SnapshotRegistrar
+
+	notifier notify.Notifier
+
+	auditSink audit.Sink
+
+	// records are only accessed with cycleMu held, so they aren't guarded by mu
+	stateStore store.StateStore
+	stateScope string
+	records    map[string]*store.VolumeRecord
+
+	// cycleMu serialises cycles, reconciliations of single volumes and on-demand snapshots, so that a
+	// volume isn't snapshotted twice at once
+	cycleMu sync.Mutex
+
+	mu       sync.RWMutex
+	states   map[string]*VolumeState
+	policies models.VolumeSnapshotConfigs
 }
 
 // NewEBSSnapshotWatcher used to create a new instance of EBS snapshot watcher
-func NewEBSSnapshotWatcher(
-	ebsClient clients.EBSClient,
-	crCounter, delCounter, errCounter *prometheus.CounterVec,
-	snapshotCounter *prometheus.GaugeVec) *EBSSnapshotWatcher {
-
+func NewEBSSnapshotWatcher(ebsClient clients.EBSClient, metrics *Metrics) *EBSSnapshotWatcher {
 	return &EBSSnapshotWatcher{
-		ebsClient:       ebsClient,
-		crCounter:       crCounter,
-		delCounter:      delCounter,
-		errCounter:      errCounter,
-		snapshotCounter: snapshotCounter,
+		ebsClient: ebsClient,
+		metrics:   metrics,
+		logger:    slog.Default(),
+		tracer:    otel.Tracer(tracerName),
+		clock:     clock.Real(),
+		states:    make(map[string]*VolumeState),
 	}
 }
 
+// SetLogger used to replace the default logger, such as with one carrying the target's region and account
+func (w *EBSSnapshotWatcher) SetLogger(logger *slog.Logger) {
+	w.logger = logger
+}
+
+// SetTracerProvider used to replace the global tracer provider used to trace cycles, volumes and hooks
+func (w *EBSSnapshotWatcher) SetTracerProvider(provider trace.TracerProvider) {
+	w.tracer = provider.Tracer(tracerName)
+}
+
+// SetClock used to replace the system clock that intervals, retention and waits are based on,
+// such as with a simulated one
+func (w *EBSSnapshotWatcher) SetClock(clk clock.Clock) {
+	w.clock = clk
+}
+
+// SetNotifier used to enable notifications about snapshots created, removed or failed and RPO violations
+func (w *EBSSnapshotWatcher) SetNotifier(notifier notify.Notifier) {
+	w.notifier = notifier
+}
+
+// SetAuditSink used to enable recording every snapshot created or deleted, and why
+func (w *EBSSnapshotWatcher) SetAuditSink(sink audit.Sink) {
+	w.auditSink = sink
+}
+
+// SetSnapshotCopier used to enable copying snapshots to the regions listed in `copyTo`
+func (w *EBSSnapshotWatcher) SetSnapshotCopier(copier clients.SnapshotCopier) {
+	w.copier = copier
+}
+
+// SetHookRunner used to enable running the policies' pre and post snapshot hooks
+func (w *EBSSnapshotWatcher) SetHookRunner(runner hooks.Runner) {
+	w.hookRunner = runner
+}
+
+// SetSnapshotRegistrar used to enable exposing completed snapshots of PVC volumes to Kubernetes
+func (w *EBSSnapshotWatcher) SetSnapshotRegistrar(registrar kube.SnapshotRegistrar) {
+	w.registrar = registrar
+}
+
+// SetVaultCopier used to enable copying snapshots into the vault account for policies with a `vault` section
+func (w *EBSSnapshotWatcher) SetVaultCopier(copier clients.SnapshotCopier, region string) {
+	w.vaultCopier = copier
+	w.vaultRegion = region
+}
+
 // WatchSnapshots used to check EBS snapshots to create new ones and/or delete old ones.
-func (w *EBSSnapshotWatcher) WatchSnapshots(config *models.VolumeSnapshotConfigs) error {
-	volumes, err := w.ebsClient.GetVolumes()
+func (w *EBSSnapshotWatcher) WatchSnapshots(ctx context.Context, config *models.VolumeSnapshotConfigs) (err error) {
+	timer := prometheus.NewTimer(w.metrics.CycleDuration.WithLabelValues())
+	defer timer.ObserveDuration()
+
+	w.cycleMu.Lock()
+	defer w.cycleMu.Unlock()
+
+	ctx, span := w.tracer.Start(ctx, "WatchSnapshots")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	volumes, err := w.ebsClient.GetVolumes(ctx)
 	if err != nil {
+		w.metrics.Errors.WithLabelValues("", "", "", opGetVolumes).Inc()
 		return errors.Wrap(err, "error while fetching volumes")
 	}
 
-	snapshots, err := w.ebsClient.GetSnapshots()
+	snapshots, err := w.ebsClient.GetSnapshots(ctx)
 	if err != nil {
+		w.metrics.Errors.WithLabelValues("", "", "", opGetSnapshots).Inc()
 		return errors.Wrap(err, "error while fetching snapshots")
 	}
+	span.SetAttributes(
+		attribute.Int("volume.count", len(volumes)),
+		attribute.Int("policy.count", len(*config)))
 
-	log.Printf("checking volumes and snapshots")
-	for _, config := range *config {
-		retentionStartDate := time.Now().Add(-time.Duration(config.RetentionPeriodHours) * time.Hour)
-		acceptableStartTime := time.Now().Add(time.Duration(-config.IntervalSeconds) * time.Second)
+	w.loadRecords(ctx)
+
+	// Snapshot copies are fetched once per cycle for each destination
+	copies := make(map[string]clients.EC2Snapshots)
+	// Volumes already handled by a policy in this cycle
+	matched := make(map[string]bool)
+	states := make(map[string]*VolumeState)
 
-		key := config.Labels.Key
-		val := config.Labels.Value
+	w.logger.Info("checking volumes and snapshots", "volumes", len(volumes))
+	for _, config := range *config {
 		for _, volume := range volumes {
-			for _, tag := range volume.Tags {
-				if *tag.Key == key && *tag.Value == val {
-					var latestSnapshot *ec2.Snapshot
-
-					pvcName := getPVCName(volume.Tags)
-					pvcNamespace := getPVCNamespace(volume.Tags)
-
-					totalSnapshots := len(snapshots[*volume.VolumeId])
-
-					w.snapshotCounter.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Set(float64(totalSnapshots))
-
-					// If the volume already have at least one snapshot, use the latest
-					if totalSnapshots > 0 {
-						latestSnapshot = snapshots[*volume.VolumeId][0]
-					}
-
-					if err := createNewEBSSnapshot(
-						w,
-						latestSnapshot,
-						volume,
-						acceptableStartTime,
-						pvcName,
-						pvcNamespace); err != nil {
-
-						log.Printf("error occurred while creating a new snapshot, %v", err)
-						continue
-					}
-
-					// Removing all old snapshots for given volume
-					for _, snapshot := range snapshots[*volume.VolumeId] {
-						if err := removeOldEBSSnapshot(
-							w,
-							snapshot,
-							volume,
-							retentionStartDate,
-							pvcName,
-							pvcNamespace); err != nil {
-
-							log.Printf("failed to remove old snapshot, %v", err)
-						}
-						time.Sleep(2 * time.Second) // A delay so that we don't exceed AWS request limits
-					}
-				}
+			// A volume is handled by the first policy that matches it
+			if matched[*volume.VolumeId] || !MatchesPolicy(config, volume) {
+				continue
 			}
+			matched[*volume.VolumeId] = true
+
+			states[*volume.VolumeId] = w.watchVolume(ctx, config, volume, snapshots[*volume.VolumeId], copies)
 		}
 	}
+	span.SetAttributes(attribute.Int("volume.matched", len(states)))
+	w.saveRecords(ctx, states)
+
+	w.mu.Lock()
+	// Series of volumes that are no longer handled, usually because they were deleted, are removed
+	for volumeID := range w.states {
+		if _, ok := states[volumeID]; !ok {
+			w.metrics.deleteVolume(volumeID)
+		}
+	}
+	w.states = states
+	w.policies = *config
+	w.mu.Unlock()
+
+	w.metrics.LastSuccessfulRun.WithLabelValues().SetToCurrentTime()
 	return nil
 }
 
+// watchVolume used to create a new snapshot of a volume when due, remove its old snapshots and copies,
+// and register and copy its snapshots. Errors are recorded in the returned state.
+func (w *EBSSnapshotWatcher) watchVolume(
+	ctx context.Context,
+	config *models.VolumeSnapshotConfig,
+	volume *ec2.Volume,
+	snapshots []*ec2.Snapshot,
+	copies map[string]clients.EC2Snapshots) *VolumeState {
+
+	now := w.clock.Now()
+	retentionStartDate := now.Add(-time.Duration(config.RetentionPeriodHours) * time.Hour)
+	acceptableStartTime := now.Add(time.Duration(-config.IntervalSeconds) * time.Second)
+
+	pvcName := getPVCName(volume.Tags)
+	pvcNamespace := getPVCNamespace(volume.Tags)
+
+	ctx, span := w.tracer.Start(ctx, "watchVolume", trace.WithAttributes(
+		attribute.String("volume.id", *volume.VolumeId),
+		attribute.String("pvc.name", pvcName),
+		attribute.String("pvc.namespace", pvcNamespace),
+		attribute.String("policy", PolicyName(config)),
+		attribute.Int("snapshot.count", len(snapshots))))
+	defer span.End()
+
+	var latestSnapshot *ec2.Snapshot
+	totalSnapshots := len(snapshots)
+
+	w.metrics.Snapshots.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Set(float64(totalSnapshots))
+	w.metrics.ProtectedSnapshots.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Set(
+		float64(countProtected(snapshots, now)))
+
+	logger := w.volumeLogger(config, volume, pvcName, pvcNamespace)
+	state := newVolumeState(config, volume, snapshots, pvcName, pvcNamespace, now)
+	record := w.volumeRecord(*volume.VolumeId)
+
+	latestCompleted := latestCompletedSnapshot(snapshots)
+	if recordRPO(w, config, volume, latestCompleted, pvcName, pvcNamespace) {
+		w.sendEvent(ctx, logger, notify.RPOViolated, config, volume, "",
+			"latest completed snapshot is older than the RPO of %s", RPOThreshold(config))
+	}
+
+	// If the volume already have at least one snapshot, use the latest
+	if totalSnapshots > 0 {
+		latestSnapshot = snapshots[0]
+	}
+
+	if err := createNewEBSSnapshot(
+		ctx,
+		w,
+		logger,
+		config,
+		latestSnapshot,
+		volume,
+		record,
+		acceptableStartTime,
+		pvcName,
+		pvcNamespace); err != nil {
+
+		logger.Error("failed to create a new snapshot", logging.Action, "create_snapshot", logging.Error, err)
+		w.sendEvent(ctx, logger, notify.SnapshotFailed, config, volume, "", "failed to create a snapshot: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		state.LastError = err.Error()
+		return state
+	}
+
+	// Removing all old snapshots for given volume
+	for _, snapshot := range snapshots {
+		if err := removeOldEBSSnapshot(
+			ctx,
+			w,
+			logger,
+			config,
+			snapshot,
+			volume,
+			record,
+			retentionStartDate,
+			pvcName,
+			pvcNamespace); err != nil {
+
+			logger.Error("failed to remove old snapshot",
+				logging.SnapshotID, *snapshot.SnapshotId,
+				logging.Action, "remove_snapshot",
+				logging.Error, err)
+			w.sendEvent(ctx, logger, notify.SnapshotFailed, config, volume, *snapshot.SnapshotId,
+				"failed to remove snapshot %s: %v", *snapshot.SnapshotId, err)
+			span.RecordError(err)
+			state.LastError = err.Error()
+		}
+		delay(ctx, w)
+	}
+
+	if w.registrar != nil && pvcName != "" && pvcNamespace != "" {
+		registerSnapshots(w, logger, snapshots, volume, retentionStartDate, pvcName, pvcNamespace)
+	}
+
+	if w.copier != nil {
+		for _, dest := range config.CopyTo {
+			copySnapshot(
+				ctx,
+				w,
+				logger,
+				w.copier,
+				dest.Region,
+				dest.Region,
+				dest.KmsKeyID,
+				dest.RetentionPeriodHours,
+				latestCompleted,
+				volume,
+				record,
+				copies,
+				pvcName,
+				pvcNamespace)
+		}
+	}
+	if w.vaultCopier != nil && config.Vault != nil {
+		copySnapshot(
+			ctx,
+			w,
+			logger,
+			w.vaultCopier,
+			vaultDestination,
+			w.vaultRegion,
+			config.Vault.KmsKeyID,
+			config.Vault.RetentionPeriodHours,
+			latestCompleted,
+			volume,
+			record,
+			copies,
+			pvcName,
+			pvcNamespace)
+	}
+
+	return state
+}
+
+// MatchesPolicy used to check whether a volume is covered by given policy, either by its
+// volume ID or by its label
+func MatchesPolicy(config *models.VolumeSnapshotConfig, volume *ec2.Volume) bool {
+	if config.VolumeID != "" {
+		return *volume.VolumeId == config.VolumeID
+	}
+	for _, tag := range volume.Tags {
+		if *tag.Key == config.Labels.Key && *tag.Value == config.Labels.Value {
+			return true
+		}
+	}
+	return false
+}
+
 func getPVCName(tags []*ec2.Tag) string {
 	n := ""
 	for _, tag := range tags {
@@ -133,63 +382,455 @@ func getPVCNamespace(tags []*ec2.Tag) string {
 }
 
 func createNewEBSSnapshot(
+	ctx context.Context,
 	w *EBSSnapshotWatcher,
+	logger *slog.Logger,
+	config *models.VolumeSnapshotConfig,
 	snapshot *ec2.Snapshot,
 	volume *ec2.Volume,
+	record *store.VolumeRecord,
 	acceptableStartTime time.Time,
 	pvcName, pvcNamespace string) error {
 
+	span := trace.SpanFromContext(ctx)
 	if snapshot != nil && !snapshot.StartTime.Before(acceptableStartTime) && *snapshot.State != "error" {
-		log.Printf("volume %s has an up to date snapshot, snapshot start time: %s, acceptable start time: %s",
-			*volume.VolumeId, *snapshot.StartTime, acceptableStartTime)
+		span.SetAttributes(attribute.String("decision", "up_to_date"))
+		logger.Debug("skipped snapshot, volume has an up to date snapshot",
+			logging.SnapshotID, *snapshot.SnapshotId,
+			logging.Action, "skip_snapshot",
+			logging.Reason, "up_to_date",
+			"snapshot_start_time", *snapshot.StartTime,
+			"acceptable_start_time", acceptableStartTime)
+		return nil
+	}
+	// Attempts are backed off after failures, so that a failing volume isn't retried every cycle
+	now := w.clock.Now()
+	if record != nil && !record.RetryDue(now) {
+		span.SetAttributes(attribute.String("decision", "backoff"))
+		logger.Info("skipped snapshot, backing off after failed attempts",
+			logging.Action, "skip_snapshot",
+			logging.Reason, "backoff",
+			"failures", record.Failures,
+			"retry_after", record.RetryAfter)
 		return nil
 	}
-	if err := w.ebsClient.CreateSnapshot(volume); err != nil {
-		w.errCounter.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Inc()
+	reason := "first_snapshot"
+	if snapshot != nil {
+		reason = "interval_exceeded"
+	}
+	if snapshot != nil && *snapshot.State == "error" {
+		reason = "latest_snapshot_failed"
+		w.sendEvent(ctx, logger, notify.SnapshotFailed, config, volume, *snapshot.SnapshotId,
+			"snapshot %s failed, creating a new one", *snapshot.SnapshotId)
+	}
+	created, err := createSnapshotWithHooks(ctx, w, logger, config, volume, nil, record, pvcName, pvcNamespace)
+	if err != nil {
+		span.SetAttributes(attribute.String("decision", "create_failed"))
+		w.writeAudit(ctx, logger, audit.ActionCreate, reason, config, volume, "", err)
+		if record != nil {
+			record.SetLastAction("create_snapshot", "", err, now)
+			record.Retry(now)
+		}
 		return err
 	}
+	if record != nil {
+		record.SetLastAction("create_snapshot", aws.StringValue(created.SnapshotId), nil, now)
+		record.ResetRetries()
+	}
+	w.writeAudit(ctx, logger, audit.ActionCreate, reason, config, volume, aws.StringValue(created.SnapshotId), nil)
+	span.SetAttributes(
+		attribute.String("decision", "created"),
+		attribute.String("snapshot.id", aws.StringValue(created.SnapshotId)))
+	w.sendEvent(ctx, logger, notify.SnapshotCreated, config, volume, aws.StringValue(created.SnapshotId),
+		"created snapshot %s", aws.StringValue(created.SnapshotId))
+	w.metrics.SnapshotsCreated.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Inc()
+
 	if snapshot != nil {
-		log.Printf(
-			"created a new snapshot for %s volume, old snapshot id: %s; snapshot start time: %s, acceptable start time: %s",
-			*volume.VolumeId, *snapshot.SnapshotId, *snapshot.StartTime, acceptableStartTime)
-		w.crCounter.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Inc()
+		logger.Info("created a new snapshot",
+			logging.SnapshotID, aws.StringValue(created.SnapshotId),
+			logging.Action, "create_snapshot",
+			logging.Reason, reason,
+			"previous_snapshot_id", *snapshot.SnapshotId,
+			"previous_snapshot_start_time", *snapshot.StartTime,
+			"acceptable_start_time", acceptableStartTime)
 		return nil
 	}
 
-	log.Printf("created first snapshot for %s volume", *volume.VolumeId)
-	w.crCounter.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Inc()
+	logger.Info("created a new snapshot",
+		logging.SnapshotID, aws.StringValue(created.SnapshotId),
+		logging.Action, "create_snapshot",
+		logging.Reason, reason)
+	return nil
+}
+
+// createSnapshotWithHooks used to create a snapshot with given extra tags between the policy's pre and post
+// hooks. The snapshot is skipped if the pre hook fails, and the post hook always runs once the pre hook succeeded.
+func createSnapshotWithHooks(
+	ctx context.Context,
+	w *EBSSnapshotWatcher,
+	logger *slog.Logger,
+	config *models.VolumeSnapshotConfig,
+	volume *ec2.Volume,
+	tags []*ec2.Tag,
+	record *store.VolumeRecord,
+	pvcName, pvcNamespace string) (*ec2.Snapshot, error) {
+
+	if (config.PreHook != nil || config.PostHook != nil) && w.hookRunner == nil {
+		return nil, errors.Errorf("hooks defined for volume %s, but hooks are not enabled", *volume.VolumeId)
+	}
+
+	target := hooks.Target{
+		VolumeID:     *volume.VolumeId,
+		PVCName:      pvcName,
+		PVCNamespace: pvcNamespace,
+	}
+
+	if config.PreHook != nil {
+		if err := runHook(ctx, w, config.PreHook, preHook, target, record); err != nil {
+			w.metrics.HookErrors.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, preHook).Inc()
+			return nil, errors.Wrapf(err, "pre hook failed, skipped snapshot of volume %s", *volume.VolumeId)
+		}
+	}
+
+	if config.PostHook != nil {
+		defer func() {
+			// The post hook runs even when the context is done, so that a volume frozen by the pre hook
+			// is thawed, within the post hook's own timeout
+			hookCtx := context.WithoutCancel(ctx)
+			if hookErr := runHook(hookCtx, w, config.PostHook, postHook, target, record); hookErr != nil {
+				w.metrics.HookErrors.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, postHook).Inc()
+				logger.Error("post hook failed", logging.Action, "run_hook", "hook", postHook, logging.Error, hookErr)
+			}
+		}()
+	}
+
+	snapshot, err := w.ebsClient.CreateSnapshot(ctx, volume, tags)
+	if err != nil {
+		w.metrics.Errors.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, opCreateSnapshot).Inc()
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// runHook used to run a snapshot hook in its own span, recording its outcome when a record is given
+func runHook(
+	ctx context.Context,
+	w *EBSSnapshotWatcher,
+	hook *models.Hook,
+	name string,
+	target hooks.Target,
+	record *store.VolumeRecord) error {
+
+	ctx, span := w.tracer.Start(ctx, "hook."+name)
+	defer span.End()
+
+	err := w.hookRunner.Run(ctx, hook, target)
+	if record != nil {
+		record.SetHookResult(name, err, w.clock.Now())
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
 	return nil
 }
 
 func removeOldEBSSnapshot(
+	ctx context.Context,
 	w *EBSSnapshotWatcher,
+	logger *slog.Logger,
+	config *models.VolumeSnapshotConfig,
 	snapshot *ec2.Snapshot,
 	volume *ec2.Volume,
+	record *store.VolumeRecord,
 	retentionStartDate time.Time,
 	pvcName, pvcNamespace string) error {
 
-	if snapshot != nil && snapshot.StartTime.After(retentionStartDate) {
-		log.Printf(
-			"skipped snapshot removal, retention period not exceeded, "+
-				"volume: %s, snapshot id: %s, snapshot start time: %s, retention start time: %s",
-			*volume.VolumeId,
-			*snapshot.SnapshotId,
-			*snapshot.StartTime,
-			retentionStartDate)
+	if snapshot.StartTime.After(retentionStartDate) {
+		logger.Debug("skipped snapshot removal, retention period not exceeded",
+			logging.SnapshotID, *snapshot.SnapshotId,
+			logging.Action, "skip_removal",
+			logging.Reason, "retention_not_exceeded",
+			"snapshot_start_time", *snapshot.StartTime,
+			"retention_start_time", retentionStartDate)
+		return nil
+	}
+
+	// Held snapshots, and those retained until a later time, are kept regardless of the retention period
+	if clients.Protected(snapshot, w.clock.Now()) {
+		logger.Info("skipped snapshot removal, snapshot is held or retained",
+			logging.SnapshotID, *snapshot.SnapshotId,
+			logging.Action, "skip_removal",
+			logging.Reason, "protected")
 		return nil
 	}
 
 	// An error is an indication of a state that is not valid for old snapshot to be removed.
 	// This is done to avoid removing last remaining ebs snapshot in case of error.
-	if err := w.ebsClient.RemoveSnapshot(snapshot); err != nil {
-		w.errCounter.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Inc()
+	err := w.ebsClient.RemoveSnapshot(ctx, snapshot)
+	switch clients.ErrorClassOf(err) {
+	case clients.ErrorClassInUse:
+		// Snapshots backing an AMI are kept until the AMI is deregistered, which isn't a failure
+		w.metrics.RemovalsSkipped.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, reasonInUse).Inc()
+		logger.Info("skipped snapshot removal, snapshot is in use by an AMI",
+			logging.SnapshotID, *snapshot.SnapshotId,
+			logging.Action, "skip_removal",
+			logging.Reason, reasonInUse,
+			logging.Error, err)
+		return nil
+	case clients.ErrorClassNotFound:
+		// The snapshot is already gone, as retention wanted, so only its registration is left to remove.
+		// It is still audited and counted, as the removal is complete from then on.
+		if record != nil {
+			record.SetLastAction("remove_snapshot", *snapshot.SnapshotId, nil, w.clock.Now())
+		}
+		w.writeAudit(ctx, logger, audit.ActionDelete, "already_removed", config, volume, *snapshot.SnapshotId, nil)
+		w.metrics.SnapshotsRemoved.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Inc()
+		logger.Info("skipped snapshot removal, snapshot already removed",
+			logging.SnapshotID, *snapshot.SnapshotId,
+			logging.Action, "skip_removal",
+			logging.Reason, "already_removed")
+		return unregisterSnapshot(w, snapshot, volume, pvcName, pvcNamespace)
+	}
+	if err != nil {
+		w.metrics.Errors.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, opRemoveSnapshot).Inc()
+		w.writeAudit(ctx, logger, audit.ActionDelete, "retention_exceeded", config, volume, *snapshot.SnapshotId, err)
+		if record != nil {
+			record.SetLastAction("remove_snapshot", *snapshot.SnapshotId, err, w.clock.Now())
+		}
 		return err
 	}
+	if record != nil {
+		record.SetLastAction("remove_snapshot", *snapshot.SnapshotId, nil, w.clock.Now())
+	}
+	w.writeAudit(ctx, logger, audit.ActionDelete, "retention_exceeded", config, volume, *snapshot.SnapshotId, nil)
+
+	w.metrics.SnapshotsRemoved.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Inc()
+	logger.Info("removed old snapshot",
+		logging.SnapshotID, *snapshot.SnapshotId,
+		logging.Action, "remove_snapshot",
+		logging.Reason, "retention_exceeded")
+	w.sendEvent(ctx, logger, notify.SnapshotRemoved, config, volume, *snapshot.SnapshotId,
+		"removed snapshot %s, retention period exceeded", *snapshot.SnapshotId)
+
+	return unregisterSnapshot(w, snapshot, volume, pvcName, pvcNamespace)
+}
+
+// unregisterSnapshot used to stop exposing a removed snapshot of a PVC volume to Kubernetes, when enabled
+func unregisterSnapshot(
+	w *EBSSnapshotWatcher,
+	snapshot *ec2.Snapshot,
+	volume *ec2.Volume,
+	pvcName, pvcNamespace string) error {
+
+	if w.registrar == nil || pvcName == "" || pvcNamespace == "" {
+		return nil
+	}
+	if err := w.registrar.Unregister(snapshot, pvcName, pvcNamespace); err != nil {
+		w.metrics.Errors.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, opUnregisterSnapshot).Inc()
+		return err
+	}
+	return nil
+}
+
+// countProtected used to count the snapshots that retention must not remove at given time
+func countProtected(snapshots []*ec2.Snapshot, now time.Time) int {
+	count := 0
+	for _, snapshot := range snapshots {
+		if clients.Protected(snapshot, now) {
+			count++
+		}
+	}
+	return count
+}
+
+// registerSnapshots used to expose completed snapshots within the retention period to Kubernetes
+func registerSnapshots(
+	w *EBSSnapshotWatcher,
+	logger *slog.Logger,
+	snapshots []*ec2.Snapshot,
+	volume *ec2.Volume,
+	retentionStartDate time.Time,
+	pvcName, pvcNamespace string) {
+
+	for _, snapshot := range snapshots {
+		if *snapshot.State != "completed" || !snapshot.StartTime.After(retentionStartDate) {
+			continue
+		}
+		if err := w.registrar.Register(snapshot, pvcName, pvcNamespace); err != nil {
+			w.metrics.Errors.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, opRegisterSnapshot).Inc()
+			logger.Error("failed to register snapshot",
+				logging.SnapshotID, *snapshot.SnapshotId,
+				logging.Action, "register_snapshot",
+				logging.Error, err)
+		}
+	}
+}
+
+// copySnapshot used to copy the latest completed snapshot to given destination and to remove
+// copies that exceeded the destination's retention period
+func copySnapshot(
+	ctx context.Context,
+	w *EBSSnapshotWatcher,
+	logger *slog.Logger,
+	copier clients.SnapshotCopier,
+	destination, region, kmsKeyID string,
+	retentionPeriodHours int64,
+	latestSnapshot *ec2.Snapshot,
+	volume *ec2.Volume,
+	record *store.VolumeRecord,
+	copies map[string]clients.EC2Snapshots,
+	pvcName, pvcNamespace string) {
+
+	logger = logger.With(logging.Destination, destination)
+	destCopies, ok := copies[destination]
+	if !ok {
+		c, err := copier.Refresh(ctx, region)
+		if err != nil {
+			w.metrics.Errors.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, opRefreshCopies).Inc()
+			logger.Error("failed to fetch snapshot copies", logging.Action, "refresh_copies", logging.Error, err)
+			return
+		}
+		copies[destination] = c
+		destCopies = c
+	}
+
+	if record != nil {
+		// Copies started earlier, possibly before a restart, are done once they are listed
+		record.ClearPending(store.OperationCopy, destination, func(op *store.Operation) bool {
+			return w.clock.Now().Sub(op.StartedAt) > pendingCopyExpiry || hasCopyOf(destCopies[*volume.VolumeId], op.SnapshotID)
+		})
+	}
+
+	if latestSnapshot != nil && record != nil &&
+		record.FindPending(store.OperationCopy, *latestSnapshot.SnapshotId, destination) != nil {
+		logger.Debug("skipped snapshot copy, copy already started",
+			logging.SnapshotID, *latestSnapshot.SnapshotId,
+			logging.Action, "copy_snapshot",
+			logging.Reason, "pending")
+	} else if latestSnapshot != nil {
+		started, err := copier.StartCopy(ctx, latestSnapshot, region, kmsKeyID)
+		if err != nil {
+			w.metrics.Errors.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, opCopySnapshot).Inc()
+			logger.Error("failed to copy snapshot",
+				logging.SnapshotID, *latestSnapshot.SnapshotId,
+				logging.Action, "copy_snapshot",
+				logging.Error, err)
+			if record != nil {
+				record.SetLastAction("copy_snapshot", *latestSnapshot.SnapshotId, err, w.clock.Now())
+			}
+		}
+		if started {
+			w.metrics.CopiesCreated.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, destination).Inc()
+			if record != nil {
+				record.AddPending(&store.Operation{
+					Type:        store.OperationCopy,
+					SnapshotID:  *latestSnapshot.SnapshotId,
+					Destination: destination,
+					StartedAt:   w.clock.Now(),
+				})
+				record.SetLastAction("copy_snapshot", *latestSnapshot.SnapshotId, nil, w.clock.Now())
+			}
+		}
+	}
+
+	// Copies are retained independently of the source snapshots, and held or retained copies are kept
+	// regardless of the retention period as their sources are
+	retentionStartDate := w.clock.Now().Add(-time.Duration(retentionPeriodHours) * time.Hour)
+	w.metrics.ProtectedCopies.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, destination).Set(
+		float64(countProtected(destCopies[*volume.VolumeId], w.clock.Now())))
+	for _, snapshot := range destCopies[*volume.VolumeId] {
+		if snapshot.StartTime.After(retentionStartDate) {
+			continue
+		}
+		if clients.Protected(snapshot, w.clock.Now()) {
+			logger.Info("skipped snapshot copy removal, snapshot copy is held or retained",
+				logging.SnapshotID, *snapshot.SnapshotId,
+				logging.Action, "skip_removal",
+				logging.Reason, "protected")
+			continue
+		}
+		err := copier.RemoveCopy(ctx, snapshot, region)
+		switch {
+		case err == nil:
+			w.metrics.CopiesRemoved.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, destination).Inc()
+			logger.Info("removed old snapshot copy",
+				logging.SnapshotID, *snapshot.SnapshotId,
+				logging.Action, "remove_copy",
+				logging.Reason, "retention_exceeded")
+		case clients.ErrorClassOf(err) == clients.ErrorClassInUse:
+			w.metrics.RemovalsSkipped.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, reasonInUse).Inc()
+			logger.Info("skipped snapshot copy removal, snapshot copy is in use by an AMI",
+				logging.SnapshotID, *snapshot.SnapshotId,
+				logging.Action, "skip_removal",
+				logging.Reason, reasonInUse,
+				logging.Error, err)
+		case clients.ErrorClassOf(err) == clients.ErrorClassNotFound:
+			// The copy is already gone, as retention wanted, so its removal is complete
+			w.metrics.CopiesRemoved.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, destination).Inc()
+			logger.Info("removed old snapshot copy, snapshot copy already removed",
+				logging.SnapshotID, *snapshot.SnapshotId,
+				logging.Action, "remove_copy",
+				logging.Reason, "already_removed")
+		default:
+			w.metrics.Errors.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId, opRemoveCopy).Inc()
+			logger.Error("failed to remove old snapshot copy",
+				logging.SnapshotID, *snapshot.SnapshotId,
+				logging.Action, "remove_copy",
+				logging.Error, err)
+		}
+		delay(ctx, w)
+	}
+}
 
-	w.delCounter.WithLabelValues(pvcName, pvcNamespace, *volume.VolumeId).Inc()
-	log.Printf(
-		"old snapshot with id %s for volume %s has been deleted",
-		*snapshot.SnapshotId, *volume.VolumeId)
+// hasCopyOf used to check whether given copies include a copy of a snapshot, in any state
+func hasCopyOf(copies []*ec2.Snapshot, snapshotID string) bool {
+	for _, cp := range copies {
+		if clients.SourceSnapshotID(cp) == snapshotID {
+			return true
+		}
+	}
+	return false
+}
 
+// delay used to wait between requests so that we don't exceed AWS request limits. The wait is
+// traced, as it accounts for much of the duration of a cycle.
+func delay(ctx context.Context, w *EBSSnapshotWatcher) {
+	_, span := w.tracer.Start(ctx, "delay")
+	defer span.End()
+
+	select {
+	case <-w.clock.After(requestDelay):
+	case <-ctx.Done():
+	}
+}
+
+// volumeLogger used to obtain a logger carrying the fields identifying a volume and its policy
+func (w *EBSSnapshotWatcher) volumeLogger(
+	config *models.VolumeSnapshotConfig,
+	volume *ec2.Volume,
+	pvcName, pvcNamespace string) *slog.Logger {
+
+	// Volumes snapshotted on demand may not be matched by any policy
+	policy := ""
+	if config.Name != "" || config.VolumeID != "" || config.Labels.Key != "" {
+		policy = PolicyName(config)
+	}
+	return w.logger.With(
+		logging.VolumeID, *volume.VolumeId,
+		logging.PVCName, pvcName,
+		logging.PVCNamespace, pvcNamespace,
+		logging.Policy, policy)
+}
+
+// latestCompletedSnapshot expects snapshots sorted by start time in descending order
+func latestCompletedSnapshot(snapshots []*ec2.Snapshot) *ec2.Snapshot {
+	for _, snapshot := range snapshots {
+		if snapshot.State != nil && *snapshot.State == "completed" {
+			return snapshot
+		}
+	}
 	return nil
 }
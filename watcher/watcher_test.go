@@ -1,15 +1,31 @@
 package watcher_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/utilitywarehouse/ebs-snapshotter/audit"
 	"github.com/utilitywarehouse/ebs-snapshotter/clients"
+	"github.com/utilitywarehouse/ebs-snapshotter/clock"
+	"github.com/utilitywarehouse/ebs-snapshotter/fakeec2"
+	"github.com/utilitywarehouse/ebs-snapshotter/hooks"
+	"github.com/utilitywarehouse/ebs-snapshotter/logging"
 	"github.com/utilitywarehouse/ebs-snapshotter/models"
+	"github.com/utilitywarehouse/ebs-snapshotter/notify"
+	"github.com/utilitywarehouse/ebs-snapshotter/store"
 	w "github.com/utilitywarehouse/ebs-snapshotter/watcher"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	. "gopkg.in/check.v1"
 )
 
@@ -20,8 +36,7 @@ var (
 var _ = Suite(&WatcherSuite{})
 
 var (
-	crCounter, delCounter, errCounter *prometheus.CounterVec
-	snapshotCounter                   *prometheus.GaugeVec
+	metrics *w.Metrics
 
 	ec2Volumes   clients.EC2Volumes
 	ec2Snapshots clients.EC2Snapshots
@@ -30,6 +45,8 @@ var (
 	snapshotsErrorOnGet   error
 	SnapshotErrorOnCreate error
 	snapshotErrorOnRemove error
+
+	snapshotsCreated int
 )
 
 type WatcherSuite struct {
@@ -39,24 +56,9 @@ type WatcherSuite struct {
 func TestEBSWatcher(t *testing.T) { TestingT(t) }
 
 func (s *WatcherSuite) SetUpSuite(c *C) {
-	crCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "snapshots_performed",
-		Help: "A counter of the total number of snapshots created",
-	}, []string{"pvc_name", "pvc_namespace", "volume_id"})
-	delCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "old_snapshots_removed",
-		Help: "A counter of the total number of old snapshots removed",
-	}, []string{"pvc_name", "pvc_namespace", "volume_id"})
-	errCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "errors_total",
-		Help: "A counter of the total number of errors encountered",
-	}, []string{"pvc_name", "pvc_namespace", "volume_id"})
-	snapshotCounter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "snapshots_total",
-		Help: "A counter of the total number of snapshots",
-	}, []string{"pvc_name", "pvc_namespace", "volume_id"})
-
-	s.watcher = w.NewEBSSnapshotWatcher(&MockClient{}, crCounter, delCounter, errCounter, snapshotCounter)
+	metrics = w.NewMetrics(prometheus.NewRegistry())
+
+	s.watcher = w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
 }
 
 func (s *WatcherSuite) TestLogErrorWhenFailedToGetEC2Volumes(c *C) {
@@ -64,7 +66,7 @@ func (s *WatcherSuite) TestLogErrorWhenFailedToGetEC2Volumes(c *C) {
 	volumesErrorOnGet = errors.New(errorMsg)
 	snapshotsErrorOnGet = nil
 
-	err := s.watcher.WatchSnapshots(&models.VolumeSnapshotConfigs{})
+	err := s.watcher.WatchSnapshots(context.Background(), &models.VolumeSnapshotConfigs{})
 
 	c.Assert(err, NotNil)
 	c.Assert(err.Error(), Equals, "error while fetching volumes: test volume error message")
@@ -75,7 +77,7 @@ func (s *WatcherSuite) TestLogErrorWhenFailedToGetEC2Snapshots(c *C) {
 	snapshotsErrorOnGet = errors.New(errorMsg)
 	volumesErrorOnGet = nil
 
-	err := s.watcher.WatchSnapshots(&models.VolumeSnapshotConfigs{})
+	err := s.watcher.WatchSnapshots(context.Background(), &models.VolumeSnapshotConfigs{})
 
 	c.Assert(err, NotNil)
 	c.Assert(err.Error(), Equals, "error while fetching snapshots: test snapshots error message")
@@ -104,7 +106,7 @@ func (s *WatcherSuite) TestSnapshotNotDeletedWhenUpToDateSnapshotAndRetentionPer
 
 	snapshotsErrorOnGet = nil
 	volumesErrorOnGet = nil
-	s.watcher.WatchSnapshots(&config)
+	s.watcher.WatchSnapshots(context.Background(), &config)
 }
 
 func (s *WatcherSuite) TestIfOldSnapshotNotDeletedOnCreateNewSnapshotError(c *C) {
@@ -136,7 +138,7 @@ func (s *WatcherSuite) TestIfOldSnapshotNotDeletedOnCreateNewSnapshotError(c *C)
 	volumesErrorOnGet = nil
 	snapshotErrorOnRemove = nil
 
-	s.watcher.WatchSnapshots(&config)
+	s.watcher.WatchSnapshots(context.Background(), &config)
 }
 
 func (s *WatcherSuite) TestIfOldSnapshotNotDeletedWhenRetentionPeriodNotExceeded(c *C) {
@@ -167,7 +169,7 @@ func (s *WatcherSuite) TestIfOldSnapshotNotDeletedWhenRetentionPeriodNotExceeded
 	volumesErrorOnGet = nil
 	snapshotErrorOnRemove = nil
 
-	s.watcher.WatchSnapshots(&config)
+	s.watcher.WatchSnapshots(context.Background(), &config)
 }
 
 func (s *WatcherSuite) TestIfOldSnapshotDeletedWhenRetentionPeriodExceeded(c *C) {
@@ -198,7 +200,7 @@ func (s *WatcherSuite) TestIfOldSnapshotDeletedWhenRetentionPeriodExceeded(c *C)
 	volumesErrorOnGet = nil
 	snapshotErrorOnRemove = nil
 
-	s.watcher.WatchSnapshots(&config)
+	s.watcher.WatchSnapshots(context.Background(), &config)
 
 }
 
@@ -232,7 +234,7 @@ func (s *WatcherSuite) TestIfOldSnapshotNotDeletedWhileRemovingOldSnapshotEncoun
 	errorMsg := "test remove old snapshot error message"
 	snapshotErrorOnRemove = errors.New(errorMsg)
 
-	s.watcher.WatchSnapshots(&config)
+	s.watcher.WatchSnapshots(context.Background(), &config)
 
 }
 
@@ -280,53 +282,1277 @@ func (s *WatcherSuite) TestOnlyOldSnapshotDeletedWhenRetentionPeriodExceeded(c *
 	volumesErrorOnGet = nil
 	snapshotErrorOnRemove = nil
 
-	s.watcher.WatchSnapshots(&config)
+	s.watcher.WatchSnapshots(context.Background(), &config)
 }
 
-func createFakeVolume(snapshotId, volumeId, tagKey, tagValue string) *ec2.Volume {
-	return &ec2.Volume{
-		SnapshotId: &snapshotId,
-		VolumeId:   &volumeId,
-		Tags: []*ec2.Tag{
-			{
-				Key:   &tagKey,
-				Value: &tagValue,
+func (s *WatcherSuite) TestLatestCompletedSnapshotCopiedAndOldCopiesRemoved(c *C) {
+	copyRetentionPeriod := int64(48)
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+			CopyTo: []*models.CopyDestination{
+				{
+					Region:               "eu-central-1",
+					RetentionPeriodHours: copyRetentionPeriod,
+				},
 			},
 		},
 	}
+
+	volumeID := "volume-1"
+	ec2Volumes = clients.EC2Volumes{
+		"test-key-1": createFakeVolume("snapshot-1", volumeID, "test-key-1", "test-value-1"),
+	}
+	pending := createFakeSnapshot(time.Now().Add(-10*time.Minute), "snapshot-3", "pending")
+	latest := createFakeSnapshot(time.Now().Add(-1*time.Hour), "snapshot-2", "completed")
+	older := createFakeSnapshot(time.Now().Add(-2*time.Hour), "snapshot-1", "completed")
+	ec2Snapshots = clients.EC2Snapshots{
+		volumeID: append(append(pending, latest...), older...),
+	}
+
+	copier := &MockCopier{
+		copies: clients.EC2Snapshots{
+			volumeID: append(
+				createFakeSnapshot(time.Now().Add(-1*time.Hour), "copy-2", "completed"),
+				createFakeSnapshot(time.Now().Add(time.Duration(-copyRetentionPeriod-1)*time.Hour), "copy-1", "completed")...),
+		},
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetSnapshotCopier(copier)
+	err := watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(err, IsNil)
+	c.Assert(copier.started, DeepEquals, []string{"snapshot-2"})
+	c.Assert(copier.removed, DeepEquals, []string{"copy-1"})
 }
 
-func createFakeSnapshot(startTime time.Time, snapshotID, snapshotState string) []*ec2.Snapshot {
-	return []*ec2.Snapshot{
+func (s *WatcherSuite) TestSnapshotCopiedToVaultOnlyWhenPolicyHasVault(c *C) {
+	config := models.VolumeSnapshotConfigs{
 		{
-			SnapshotId: &snapshotID,
-			StartTime:  &startTime,
-			State:      &snapshotState,
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+			Vault: &models.VaultPolicy{
+				RetentionPeriodHours: int64(720),
+			},
+		},
+		{
+			Labels: models.Label{
+				Key:   "test-key-2",
+				Value: "test-value-2",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
 		},
 	}
+
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+		"volume-2": createFakeVolume("snapshot-2", "volume-2", "test-key-2", "test-value-2"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": createFakeSnapshot(time.Now().Add(-1*time.Hour), "snapshot-1", "completed"),
+		"volume-2": createFakeSnapshot(time.Now().Add(-1*time.Hour), "snapshot-2", "completed"),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	vaultCopier := &MockCopier{copies: clients.EC2Snapshots{}}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetVaultCopier(vaultCopier, "eu-west-1")
+	err := watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(err, IsNil)
+	c.Assert(vaultCopier.started, DeepEquals, []string{"snapshot-1"})
 }
 
-type Client interface {
-	GetVolumes() (clients.EC2Volumes, error)
-	GetSnapshots() (clients.EC2Snapshots, error)
-	CreateSnapshot(volume *ec2.Volume) error
-	RemoveSnapshot(snapshot *ec2.Snapshot) error
+func (s *WatcherSuite) TestSnapshotSkippedWhenPreHookFails(c *C) {
+	config := hookedConfig()
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+	snapshotsCreated = 0
+
+	runner := &MockHookRunner{errors: map[*models.Hook]error{config[0].PreHook: errors.New("fsfreeze failed")}}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetHookRunner(runner)
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(snapshotsCreated, Equals, 0)
+	c.Assert(runner.run, DeepEquals, []*models.Hook{config[0].PreHook})
 }
 
-type MockClient struct{}
+func (s *WatcherSuite) TestPostHookRunWhenSnapshotCreationFails(c *C) {
+	config := hookedConfig()
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{}
 
-func (c *MockClient) GetVolumes() (clients.EC2Volumes, error) {
-	return ec2Volumes, volumesErrorOnGet
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = errors.New("test snapshots error message")
+	snapshotErrorOnRemove = nil
+
+	runner := &MockHookRunner{}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetHookRunner(runner)
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(runner.run, DeepEquals, []*models.Hook{config[0].PreHook, config[0].PostHook})
 }
 
-func (c *MockClient) GetSnapshots() (clients.EC2Snapshots, error) {
-	return ec2Snapshots, snapshotsErrorOnGet
+func (s *WatcherSuite) TestPreHookStoppedAndPostHookRunWhenCycleCancelled(c *C) {
+	config := hookedConfig()
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runner := &MockHookRunner{}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetHookRunner(runner)
+	watcher.WatchSnapshots(ctx, &config)
+
+	c.Assert(runner.run, DeepEquals, []*models.Hook{config[0].PreHook, config[0].PostHook})
+	// The post hook isn't cancelled, so that a frozen volume is thawed
+	c.Assert(runner.ctxErrs, DeepEquals, []error{context.Canceled, nil})
 }
 
-func (c *MockClient) CreateSnapshot(volume *ec2.Volume) error {
-	return SnapshotErrorOnCreate
+func (s *WatcherSuite) TestVolumeMatchedByPolicyVolumeIDOrLabel(c *C) {
+	volume := createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1")
+
+	c.Assert(w.MatchesPolicy(&models.VolumeSnapshotConfig{VolumeID: "volume-1"}, volume), Equals, true)
+	c.Assert(w.MatchesPolicy(&models.VolumeSnapshotConfig{VolumeID: "volume-2"}, volume), Equals, false)
+	c.Assert(w.MatchesPolicy(&models.VolumeSnapshotConfig{
+		Labels: models.Label{Key: "test-key-1", Value: "test-value-1"},
+	}, volume), Equals, true)
+	c.Assert(w.MatchesPolicy(&models.VolumeSnapshotConfig{
+		Labels: models.Label{Key: "test-key-1", Value: "test-value-2"},
+	}, volume), Equals, false)
 }
 
-func (c *MockClient) RemoveSnapshot(snapshot *ec2.Snapshot) error {
-	return snapshotErrorOnRemove
+func (s *WatcherSuite) TestVolumeHandledOnlyByFirstMatchingPolicy(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			VolumeID:             "volume-1",
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	config = append(config, hookedConfig()...)
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+	snapshotsCreated = 0
+
+	runner := &MockHookRunner{}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetHookRunner(runner)
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(snapshotsCreated, Equals, 1)
+	c.Assert(runner.run, IsNil)
+}
+
+func (s *WatcherSuite) TestSnapshotsRegisteredAndRemovedSnapshotsUnregistered(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "kubernetes.io/created-for/pvc/name",
+				Value: "datadir-kafka-0",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	volume := createFakeVolume("snapshot-1", "volume-1", "kubernetes.io/created-for/pvc/name", "datadir-kafka-0")
+	volume.Tags = append(volume.Tags, &ec2.Tag{
+		Key:   aws.String("kubernetes.io/created-for/pvc/namespace"),
+		Value: aws.String("kafka"),
+	})
+	ec2Volumes = clients.EC2Volumes{"volume-1": volume}
+	recent := createFakeSnapshot(time.Now().Add(-1*time.Hour), "snapshot-2", "completed")
+	old := createFakeSnapshot(time.Now().Add(time.Duration(-retentionPeriod-1)*time.Hour), "snapshot-1", "completed")
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": append(recent, old...),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	registrar := &MockRegistrar{}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetSnapshotRegistrar(registrar)
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(registrar.registered, DeepEquals, []string{"kafka/datadir-kafka-0/snapshot-2"})
+	c.Assert(registrar.unregistered, DeepEquals, []string{"kafka/datadir-kafka-0/snapshot-1"})
+}
+
+func (s *WatcherSuite) TestCatalogListsMatchedVolumesWithNextDueTime(c *C) {
+	now := time.Now()
+	config := models.VolumeSnapshotConfigs{
+		{
+			Name:                 "kafka",
+			Labels:               models.Label{Key: "test-key-1", Value: "test-value-1"},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	volumes := clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+		"volume-2": createFakeVolume("snapshot-2", "volume-2", "test-key-1", "test-value-1"),
+		"volume-3": createFakeVolume("snapshot-3", "volume-3", "test-key-2", "test-value-1"),
+	}
+	pending := createFakeSnapshot(now.Add(-10*time.Minute), "snapshot-2", "pending")
+	completed := createFakeSnapshot(now.Add(-2*time.Hour), "snapshot-1", "completed")
+	pending[0].VolumeSize = aws.Int64(100)
+	completed[0].VolumeSize = aws.Int64(100)
+	snapshots := clients.EC2Snapshots{
+		"volume-1": append(pending, completed...),
+	}
+
+	entries := w.Catalog(config, volumes, snapshots, now)
+
+	c.Assert(len(entries), Equals, 2)
+	c.Assert(entries[0].VolumeID, Equals, "volume-1")
+	c.Assert(entries[0].Policy, Equals, "kafka")
+	c.Assert(entries[0].SnapshotCount, Equals, 2)
+	c.Assert(entries[0].TotalSizeGiB, Equals, int64(200))
+	c.Assert(entries[0].LatestSnapshotTime.Equal(*completed[0].StartTime), Equals, true)
+	c.Assert(entries[0].NextDue.Equal(now.Add(50*time.Minute)), Equals, true)
+	c.Assert(entries[1].VolumeID, Equals, "volume-2")
+	c.Assert(entries[1].LatestSnapshotTime, IsNil)
+	c.Assert(entries[1].NextDue.Equal(now), Equals, true)
+}
+
+func (s *WatcherSuite) TestRPOThresholdDerivedFromInterval(c *C) {
+	c.Assert(w.RPOThreshold(&models.VolumeSnapshotConfig{IntervalSeconds: 3600}), Equals, 2*time.Hour)
+	c.Assert(w.RPOThreshold(&models.VolumeSnapshotConfig{IntervalSeconds: 3600, RPOSeconds: 600}), Equals, 10*time.Minute)
+}
+
+func (s *WatcherSuite) TestRPOViolatedWhenLatestCompletedSnapshotTooOld(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	// A recent snapshot that failed doesn't count as a recovery point
+	failed := createFakeSnapshot(time.Now().Add(-1*time.Hour), "snapshot-2", "error")
+	old := createFakeSnapshot(time.Now().Add(-3*time.Hour), "snapshot-1", "completed")
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": append(failed, old...),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	m := w.NewMetrics(prometheus.NewRegistry())
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, m)
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(testutil.ToFloat64(m.LatestSnapshot.WithLabelValues("", "", "volume-1")), Equals, float64(old[0].StartTime.Unix()))
+	c.Assert(testutil.ToFloat64(m.LatestSnapshotAge.WithLabelValues("", "", "volume-1")) >= 3*3600, Equals, true)
+	c.Assert(testutil.ToFloat64(m.RPOThreshold.WithLabelValues("", "", "volume-1")), Equals, float64(2*3600))
+	c.Assert(testutil.ToFloat64(m.RPOViolated.WithLabelValues("", "", "volume-1")), Equals, float64(1))
+}
+
+func (s *WatcherSuite) TestSeriesOfDeletedVolumesRemoved(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+		"volume-2": createFakeVolume("snapshot-2", "volume-2", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = errors.New("test snapshot error message")
+	snapshotErrorOnRemove = nil
+
+	m := w.NewMetrics(prometheus.NewRegistry())
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, m)
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(testutil.ToFloat64(m.Errors.WithLabelValues("", "", "volume-2", "create_snapshot")), Equals, float64(1))
+	c.Assert(testutil.CollectAndCount(m.Snapshots), Equals, 2)
+	c.Assert(testutil.CollectAndCount(m.LastSuccessfulRun), Equals, 1)
+
+	delete(ec2Volumes, "volume-2")
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(testutil.CollectAndCount(m.Snapshots), Equals, 1)
+	c.Assert(testutil.CollectAndCount(m.Errors), Equals, 1)
+	c.Assert(testutil.CollectAndCount(m.RPOViolated), Equals, 1)
+	SnapshotErrorOnCreate = nil
+}
+
+func (s *WatcherSuite) TestSeriesOfForgottenVolumesRemoved(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	m := w.NewMetrics(prometheus.NewRegistry())
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, m)
+	watcher.WatchSnapshots(context.Background(), &config)
+	c.Assert(testutil.CollectAndCount(m.RPOViolated), Equals, 1)
+
+	watcher.ForgetVolumes()
+
+	c.Assert(testutil.CollectAndCount(m.RPOViolated), Equals, 0)
+	c.Assert(testutil.CollectAndCount(m.LatestSnapshot), Equals, 0)
+	c.Assert(testutil.CollectAndCount(m.SnapshotsCreated), Equals, 0)
+	c.Assert(watcher.States(), HasLen, 0)
+}
+
+func (s *WatcherSuite) TestOnDemandSnapshotTaggedAndRunWithPolicyHooks(c *C) {
+	config := hookedConfig()
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": createFakeSnapshot(time.Now(), "snapshot-1", "completed"),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+	snapshotsCreated = 0
+
+	runner := &MockHookRunner{}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetHookRunner(runner)
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	retainUntil := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshot, err := watcher.SnapshotVolume(context.Background(), &w.OnDemandRequest{
+		VolumeID:    "volume-1",
+		Label:       "before-migration",
+		RetainUntil: retainUntil,
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(snapshotsCreated, Equals, 1)
+	c.Assert(runner.run, DeepEquals, []*models.Hook{config[0].PreHook, config[0].PostHook})
+	until, ok := clients.RetainUntil(snapshot)
+	c.Assert(ok, Equals, true)
+	c.Assert(until.Equal(retainUntil), Equals, true)
+
+	_, err = watcher.SnapshotVolume(context.Background(), &w.OnDemandRequest{VolumeID: "volume-2"})
+	c.Assert(err, Equals, w.ErrVolumeNotFound)
+}
+
+func (s *WatcherSuite) TestOnDemandSnapshotWaitsForCycle(c *C) {
+	config := hookedConfig()
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": createFakeSnapshot(time.Now().Add(-2*time.Hour), "snapshot-1", "completed"),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetHookRunner(&MockHookRunner{})
+	// The policy of the volume is known from the first cycle, so on-demand snapshots run its hooks
+	watcher.WatchSnapshots(context.Background(), &config)
+	snapshotsCreated = 0
+
+	runner := &BlockingHookRunner{started: make(chan struct{}), release: make(chan struct{})}
+	watcher.SetHookRunner(runner)
+	cycleDone := make(chan struct{})
+	go func() {
+		watcher.WatchSnapshots(context.Background(), &config)
+		close(cycleDone)
+	}()
+	<-runner.started
+
+	onDemandDone := make(chan error)
+	go func() {
+		_, err := watcher.SnapshotVolume(context.Background(), &w.OnDemandRequest{VolumeID: "volume-1"})
+		onDemandDone <- err
+	}()
+	// The on-demand snapshot must not start while the cycle's pre hook holds the volume frozen
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(runner.runs(), Equals, 1)
+	close(runner.release)
+
+	<-cycleDone
+	c.Assert(<-onDemandDone, IsNil)
+	c.Assert(runner.runs(), Equals, 4)
+	c.Assert(runner.maxRunning, Equals, 1)
+	c.Assert(snapshotsCreated, Equals, 2)
+}
+
+func (s *WatcherSuite) TestHeldAndRetainedSnapshotsNotRemoved(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "kubernetes.io/created-for/pvc/name",
+				Value: "datadir-kafka-0",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	volume := createFakeVolume("snapshot-1", "volume-1", "kubernetes.io/created-for/pvc/name", "datadir-kafka-0")
+	volume.Tags = append(volume.Tags, &ec2.Tag{
+		Key:   aws.String("kubernetes.io/created-for/pvc/namespace"),
+		Value: aws.String("kafka"),
+	})
+	ec2Volumes = clients.EC2Volumes{"volume-1": volume}
+	recent := createFakeSnapshot(time.Now().Add(-1*time.Hour), "snapshot-3", "completed")
+	retained := createFakeSnapshot(time.Now().Add(time.Duration(-retentionPeriod-1)*time.Hour), "snapshot-2", "completed")
+	retained[0].Tags = []*ec2.Tag{{
+		Key:   aws.String(clients.RetainUntilTag),
+		Value: aws.String(time.Now().Add(time.Hour).UTC().Format(time.RFC3339)),
+	}}
+	expired := createFakeSnapshot(time.Now().Add(time.Duration(-retentionPeriod-1)*time.Hour), "snapshot-1", "completed")
+	expired[0].Tags = []*ec2.Tag{{
+		Key:   aws.String(clients.RetainUntilTag),
+		Value: aws.String(time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)),
+	}}
+	held := createFakeSnapshot(time.Now().Add(time.Duration(-retentionPeriod-2)*time.Hour), "snapshot-0", "completed")
+	held[0].Tags = []*ec2.Tag{{Key: aws.String(clients.HoldTag), Value: aws.String("true")}}
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": append(append(append(recent, retained...), expired...), held...),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	registrar := &MockRegistrar{}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetSnapshotRegistrar(registrar)
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(registrar.unregistered, DeepEquals, []string{"kafka/datadir-kafka-0/snapshot-1"})
+	c.Assert(testutil.ToFloat64(metrics.ProtectedSnapshots.WithLabelValues("datadir-kafka-0", "kafka", "volume-1")), Equals, float64(2))
+}
+
+func (s *WatcherSuite) TestHeldAndRetainedCopiesNotRemoved(c *C) {
+	copyRetentionPeriod := int64(48)
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+			CopyTo: []*models.CopyDestination{
+				{
+					Region:               "eu-central-1",
+					RetentionPeriodHours: copyRetentionPeriod,
+				},
+			},
+		},
+	}
+
+	volumeID := "volume-1"
+	ec2Volumes = clients.EC2Volumes{
+		"test-key-1": createFakeVolume("snapshot-1", volumeID, "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{
+		volumeID: createFakeSnapshot(time.Now().Add(-1*time.Hour), "snapshot-1", "completed"),
+	}
+	old := time.Now().Add(time.Duration(-copyRetentionPeriod-1) * time.Hour)
+	retained := createFakeSnapshot(old, "copy-2", "completed")
+	retained[0].Tags = []*ec2.Tag{{
+		Key:   aws.String(clients.RetainUntilTag),
+		Value: aws.String(time.Now().Add(time.Hour).UTC().Format(time.RFC3339)),
+	}}
+	held := createFakeSnapshot(old, "copy-1", "completed")
+	held[0].Tags = []*ec2.Tag{{Key: aws.String(clients.HoldTag), Value: aws.String("true")}}
+	expired := createFakeSnapshot(old, "copy-0", "completed")
+	copier := &MockCopier{
+		copies: clients.EC2Snapshots{
+			volumeID: append(append(retained, held...), expired...),
+		},
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetSnapshotCopier(copier)
+	err := watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(err, IsNil)
+	c.Assert(copier.removed, DeepEquals, []string{"copy-0"})
+	c.Assert(testutil.ToFloat64(metrics.ProtectedCopies.WithLabelValues("", "", volumeID, "eu-central-1")), Equals, float64(2))
+}
+
+func (s *WatcherSuite) TestRemovalLoggedWithVolumeFields(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Name: "kafka",
+			Labels: models.Label{
+				Key:   "kubernetes.io/created-for/pvc/name",
+				Value: "datadir-kafka-0",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	volume := createFakeVolume("snapshot-1", "volume-1", "kubernetes.io/created-for/pvc/name", "datadir-kafka-0")
+	ec2Volumes = clients.EC2Volumes{"volume-1": volume}
+	recent := createFakeSnapshot(time.Now().Add(-1*time.Hour), "snapshot-2", "completed")
+	old := createFakeSnapshot(time.Now().Add(time.Duration(-retentionPeriod-1)*time.Hour), "snapshot-1", "completed")
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": append(recent, old...),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	out := &bytes.Buffer{}
+	logger, err := logging.New(out, "info")
+	c.Assert(err, IsNil)
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetLogger(logger)
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	var removed map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n")) {
+		record := make(map[string]interface{})
+		c.Assert(json.Unmarshal(line, &record), IsNil)
+		c.Assert(record["level"], Not(Equals), "DEBUG")
+		if record[logging.Action] == "remove_snapshot" {
+			removed = record
+		}
+	}
+	c.Assert(removed, NotNil)
+	c.Assert(removed[logging.VolumeID], Equals, "volume-1")
+	c.Assert(removed[logging.PVCName], Equals, "datadir-kafka-0")
+	c.Assert(removed[logging.SnapshotID], Equals, "snapshot-1")
+	c.Assert(removed[logging.Policy], Equals, "kafka")
+	c.Assert(removed[logging.Reason], Equals, "retention_exceeded")
+}
+
+func (s *WatcherSuite) TestCycleAndVolumeTraced(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": createFakeSnapshot(time.Now(), "snapshot-1", "completed"),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	recorder := tracetest.NewSpanRecorder()
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	c.Assert(spans["WatchSnapshots"], NotNil)
+	c.Assert(spans["delay"], NotNil)
+	volume := spans["watchVolume"]
+	c.Assert(volume, NotNil)
+	c.Assert(volume.Parent().SpanID(), Equals, spans["WatchSnapshots"].SpanContext().SpanID())
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range volume.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	c.Assert(attrs["volume.id"].AsString(), Equals, "volume-1")
+	c.Assert(attrs["snapshot.count"].AsInt64(), Equals, int64(1))
+	c.Assert(attrs["decision"].AsString(), Equals, "up_to_date")
+}
+
+func (s *WatcherSuite) TestFailureAndRemovalNotified(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Name: "kafka",
+			Labels: models.Label{
+				Key:   "kubernetes.io/created-for/pvc/name",
+				Value: "datadir-kafka-0",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "kubernetes.io/created-for/pvc/name", "datadir-kafka-0"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": createFakeSnapshot(time.Now().Add(time.Duration(-retentionPeriod-1)*time.Hour), "snapshot-1", "completed"),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = errors.New("test snapshots error message")
+	snapshotErrorOnRemove = nil
+
+	notifier := &MockNotifier{}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetNotifier(notifier)
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(eventTypes(notifier.events), DeepEquals, []notify.EventType{notify.RPOViolated, notify.SnapshotFailed})
+	c.Assert(notifier.events[1].VolumeID, Equals, "volume-1")
+	c.Assert(notifier.events[1].PVCName, Equals, "datadir-kafka-0")
+	c.Assert(notifier.events[1].Policy, Equals, "kafka")
+
+	SnapshotErrorOnCreate = nil
+	notifier.events = nil
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(eventTypes(notifier.events), DeepEquals,
+		[]notify.EventType{notify.RPOViolated, notify.SnapshotCreated, notify.SnapshotRemoved})
+	c.Assert(notifier.events[2].SnapshotID, Equals, "snapshot-1")
+}
+
+func (s *WatcherSuite) TestCreateAndDeleteAudited(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Name: "kafka",
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": createFakeSnapshot(time.Now().Add(time.Duration(-retentionPeriod-1)*time.Hour), "snapshot-1", "completed"),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = errors.New("test remove error message")
+
+	sink := &MockAuditSink{}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetAuditSink(sink)
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	c.Assert(sink.records, HasLen, 2)
+	c.Assert(sink.records[0].Action, Equals, audit.ActionCreate)
+	c.Assert(sink.records[0].SnapshotID, Equals, "snapshot-new")
+	c.Assert(sink.records[0].Reason, Equals, "interval_exceeded")
+	c.Assert(sink.records[0].Outcome, Equals, audit.OutcomeSuccess)
+	c.Assert(sink.records[0].Policy, Equals, "kafka")
+	c.Assert(sink.records[1].Action, Equals, audit.ActionDelete)
+	c.Assert(sink.records[1].SnapshotID, Equals, "snapshot-1")
+	c.Assert(sink.records[1].Reason, Equals, "retention_exceeded")
+	c.Assert(sink.records[1].Outcome, Equals, audit.OutcomeFailure)
+	c.Assert(sink.records[1].Error, Equals, "test remove error message")
+}
+
+func (s *WatcherSuite) TestFailedSnapshotBackedOffAcrossRestarts(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": createFakeSnapshot(time.Now().Add(-2*time.Hour), "snapshot-1", "completed"),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = errors.New("test snapshots error message")
+	snapshotErrorOnRemove = nil
+
+	stateStore := &MockStateStore{}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetStateStore(stateStore, "eu-west-1/111")
+	watcher.WatchSnapshots(context.Background(), &config)
+
+	record := stateStore.saved["volume-1"]
+	c.Assert(record, NotNil)
+	c.Assert(record.LastAction, Equals, "create_snapshot")
+	c.Assert(record.LastError, Equals, "test snapshots error message")
+	c.Assert(record.Failures, Equals, 1)
+
+	// A new watcher, as after a restart, waits for the backoff before trying again
+	SnapshotErrorOnCreate = nil
+	snapshotsCreated = 0
+	watcher = w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetStateStore(stateStore, "eu-west-1/111")
+	watcher.WatchSnapshots(context.Background(), &config)
+	c.Assert(snapshotsCreated, Equals, 0)
+
+	stateStore.saved["volume-1"].RetryAfter = time.Now().Add(-time.Second)
+	watcher.WatchSnapshots(context.Background(), &config)
+	c.Assert(snapshotsCreated, Equals, 1)
+	c.Assert(stateStore.saved["volume-1"].Failures, Equals, 0)
+	c.Assert(stateStore.saved["volume-1"].LastSnapshotID, Equals, "snapshot-new")
+}
+
+func (s *WatcherSuite) TestPendingCopyNotStartedAgainAfterRestart(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+			CopyTo:               []*models.CopyDestination{{Region: "eu-central-1", RetentionPeriodHours: retentionPeriod}},
+		},
+	}
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": createFakeSnapshot(time.Now().Add(-1*time.Minute), "snapshot-1", "completed"),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+
+	stateStore := &MockStateStore{}
+	copier := &MockCopier{copies: clients.EC2Snapshots{}}
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetStateStore(stateStore, "eu-west-1/111")
+	watcher.SetSnapshotCopier(copier)
+	watcher.WatchSnapshots(context.Background(), &config)
+	c.Assert(copier.started, DeepEquals, []string{"snapshot-1"})
+	c.Assert(stateStore.saved["volume-1"].Pending, HasLen, 1)
+
+	// The copy isn't listed yet, so a restarted watcher doesn't start it again
+	copier = &MockCopier{copies: clients.EC2Snapshots{}}
+	watcher = w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	watcher.SetStateStore(stateStore, "eu-west-1/111")
+	watcher.SetSnapshotCopier(copier)
+	watcher.WatchSnapshots(context.Background(), &config)
+	c.Assert(copier.started, HasLen, 0)
+	c.Assert(stateStore.saved["volume-1"].Pending, HasLen, 1)
+
+	// Once listed, the copy is no longer pending
+	copier.copies = clients.EC2Snapshots{"volume-1": {{
+		SnapshotId: aws.String("copy-1"),
+		StartTime:  aws.Time(time.Now()),
+		State:      aws.String("pending"),
+		Tags:       []*ec2.Tag{{Key: aws.String(clients.SourceSnapshotIDTag), Value: aws.String("snapshot-1")}},
+	}}}
+	watcher.WatchSnapshots(context.Background(), &config)
+	c.Assert(stateStore.saved["volume-1"].Pending, HasLen, 0)
+}
+
+func (s *WatcherSuite) TestVolumeReconciledAndDeletedVolumeForgotten(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+		},
+	}
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": createFakeSnapshot(time.Now(), "snapshot-1", "completed"),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+	snapshotErrorOnRemove = nil
+	snapshotsCreated = 0
+
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+	// Nothing is reconciled before the first cycle, as the policies aren't known
+	c.Assert(watcher.ReconcileVolume(context.Background(), "volume-1"), IsNil)
+	c.Assert(watcher.States(), HasLen, 0)
+
+	c.Assert(watcher.WatchSnapshots(context.Background(), &config), IsNil)
+	c.Assert(snapshotsCreated, Equals, 0)
+
+	// The latest snapshot failed, so reconciling the volume creates a new one without waiting for a cycle
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": createFakeSnapshot(time.Now(), "snapshot-1", "error"),
+	}
+	c.Assert(watcher.ReconcileVolume(context.Background(), "volume-1"), IsNil)
+	c.Assert(snapshotsCreated, Equals, 1)
+	c.Assert(watcher.States(), HasLen, 1)
+
+	ec2Volumes = clients.EC2Volumes{}
+	c.Assert(watcher.ReconcileVolume(context.Background(), "volume-1"), IsNil)
+	c.Assert(watcher.States(), HasLen, 0)
+}
+
+func (s *WatcherSuite) TestSnapshotsKeptForRetentionOverSimulatedDays(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(86400),
+			RetentionPeriodHours: 72,
+		},
+	}
+	clk := clock.NewSimulated(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	fake := fakeec2.New("eu-west-1", "111111111111")
+	fake.SetClock(clk)
+	volumeID := fake.AddVolume(createFakeVolume("", "volume-1", "test-key-1", "test-value-1"))
+
+	metrics := w.NewMetrics(prometheus.NewRegistry())
+	watcher := w.NewEBSSnapshotWatcher(clients.NewEBSClient(fake, nil), metrics)
+	watcher.SetClock(clk)
+
+	// Cycles run every 6 hours for 10 days, waits between requests only advance the simulated time
+	for i := 0; i <= 40; i++ {
+		c.Assert(watcher.WatchSnapshots(context.Background(), &config), IsNil)
+		c.Assert(len(fake.Snapshots(volumeID)) <= 3, Equals, true)
+		clk.Advance(6 * time.Hour)
+	}
+
+	snapshots := fake.Snapshots(volumeID)
+	c.Assert(snapshots, HasLen, 3)
+	for _, snapshot := range snapshots {
+		c.Assert(clk.Now().Sub(*snapshot.StartTime) < 72*time.Hour, Equals, true)
+	}
+	c.Assert(testutil.ToFloat64(metrics.SnapshotsCreated.WithLabelValues("", "", volumeID)), Equals, float64(11))
+	c.Assert(testutil.ToFloat64(metrics.SnapshotsRemoved.WithLabelValues("", "", volumeID)), Equals, float64(8))
+}
+
+func (s *WatcherSuite) TestSnapshotInUseSkippedAndRemovedSnapshotIgnored(c *C) {
+	config := models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+			CopyTo:               []*models.CopyDestination{{Region: "eu-central-1", RetentionPeriodHours: retentionPeriod}},
+		},
+	}
+	ec2Volumes = clients.EC2Volumes{
+		"volume-1": createFakeVolume("snapshot-1", "volume-1", "test-key-1", "test-value-1"),
+	}
+	ec2Snapshots = clients.EC2Snapshots{
+		"volume-1": createFakeSnapshot(time.Now().Add(-time.Duration(retentionPeriod+1)*time.Hour), "snapshot-1", "completed"),
+	}
+
+	snapshotsErrorOnGet = nil
+	volumesErrorOnGet = nil
+	SnapshotErrorOnCreate = nil
+
+	metrics := w.NewMetrics(prometheus.NewRegistry())
+	watcher := w.NewEBSSnapshotWatcher(&MockClient{}, metrics)
+
+	snapshotErrorOnRemove = &clients.Error{
+		Class:  clients.ErrorClassInUse,
+		Action: "removing a snapshot",
+		Err:    errors.New("snapshot-1 is in use by ami-1"),
+	}
+	c.Assert(watcher.WatchSnapshots(context.Background(), &config), IsNil)
+	c.Assert(testutil.ToFloat64(metrics.RemovalsSkipped.WithLabelValues("", "", "volume-1", "in_use")), Equals, float64(1))
+	c.Assert(watcher.States()[0].LastError, Equals, "")
+
+	// A snapshot or copy already gone counts as removed, and snapshots are audited as such
+	sink := &MockAuditSink{}
+	watcher.SetAuditSink(sink)
+	snapshotErrorOnRemove = &clients.Error{
+		Class:  clients.ErrorClassNotFound,
+		Action: "removing a snapshot",
+		Err:    errors.New("snapshot-1 does not exist"),
+	}
+	watcher.SetSnapshotCopier(&MockCopier{
+		copies: clients.EC2Snapshots{
+			"volume-1": createFakeSnapshot(time.Now().Add(-time.Duration(retentionPeriod+1)*time.Hour), "copy-1", "completed"),
+		},
+		removeErr: &clients.Error{
+			Class:  clients.ErrorClassNotFound,
+			Action: "removing a snapshot copy",
+			Err:    errors.New("copy-1 does not exist"),
+		},
+	})
+	c.Assert(watcher.WatchSnapshots(context.Background(), &config), IsNil)
+	c.Assert(watcher.States()[0].LastError, Equals, "")
+
+	c.Assert(testutil.ToFloat64(metrics.Errors.WithLabelValues("", "", "volume-1", "remove_snapshot")), Equals, float64(0))
+	c.Assert(testutil.ToFloat64(metrics.SnapshotsRemoved.WithLabelValues("", "", "volume-1")), Equals, float64(1))
+	c.Assert(testutil.ToFloat64(metrics.Errors.WithLabelValues("", "", "volume-1", "remove_copy")), Equals, float64(0))
+	c.Assert(testutil.ToFloat64(metrics.CopiesRemoved.WithLabelValues("", "", "volume-1", "eu-central-1")), Equals, float64(1))
+	deletes := make([]*audit.Record, 0)
+	for _, record := range sink.records {
+		if record.Action == audit.ActionDelete {
+			deletes = append(deletes, record)
+		}
+	}
+	c.Assert(deletes, HasLen, 1)
+	c.Assert(deletes[0].SnapshotID, Equals, "snapshot-1")
+	c.Assert(deletes[0].Reason, Equals, "already_removed")
+	c.Assert(deletes[0].Outcome, Equals, audit.OutcomeSuccess)
+	snapshotErrorOnRemove = nil
+}
+
+func eventTypes(events []*notify.Event) []notify.EventType {
+	types := make([]notify.EventType, 0)
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func hookedConfig() models.VolumeSnapshotConfigs {
+	return models.VolumeSnapshotConfigs{
+		{
+			Labels: models.Label{
+				Key:   "test-key-1",
+				Value: "test-value-1",
+			},
+			IntervalSeconds:      int64(3600),
+			RetentionPeriodHours: retentionPeriod,
+			PreHook: &models.Hook{
+				Exec: &models.ExecHook{Command: []string{"fsfreeze", "-f", "/data"}},
+			},
+			PostHook: &models.Hook{
+				Exec: &models.ExecHook{Command: []string{"fsfreeze", "-u", "/data"}},
+			},
+		},
+	}
+}
+
+func createFakeVolume(snapshotId, volumeId, tagKey, tagValue string) *ec2.Volume {
+	return &ec2.Volume{
+		SnapshotId: &snapshotId,
+		VolumeId:   &volumeId,
+		Tags: []*ec2.Tag{
+			{
+				Key:   &tagKey,
+				Value: &tagValue,
+			},
+		},
+	}
+}
+
+func createFakeSnapshot(startTime time.Time, snapshotID, snapshotState string) []*ec2.Snapshot {
+	return []*ec2.Snapshot{
+		{
+			SnapshotId: &snapshotID,
+			StartTime:  &startTime,
+			State:      &snapshotState,
+		},
+	}
+}
+
+type Client interface {
+	GetVolumes(ctx context.Context) (clients.EC2Volumes, error)
+	GetSnapshots(ctx context.Context) (clients.EC2Snapshots, error)
+	CreateSnapshot(ctx context.Context, volume *ec2.Volume, tags []*ec2.Tag) (*ec2.Snapshot, error)
+	RemoveSnapshot(ctx context.Context, snapshot *ec2.Snapshot) error
+	CopySnapshot(ctx context.Context, snapshot *ec2.Snapshot, sourceRegion, kmsKeyID string) (string, error)
+	GetSnapshotCopies(ctx context.Context) (clients.EC2Snapshots, error)
+	ShareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string) error
+	UnshareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string, keepKeyGrant bool) error
+	CreateVolume(ctx context.Context, snapshot *ec2.Snapshot, options *clients.VolumeOptions) (*ec2.Volume, error)
+	TagSnapshot(ctx context.Context, snapshotID string, tags []*ec2.Tag) error
+	UntagSnapshot(ctx context.Context, snapshotID string, keys []string) error
+}
+
+type MockClient struct{}
+
+func (c *MockClient) GetVolumes(ctx context.Context) (clients.EC2Volumes, error) {
+	return ec2Volumes, volumesErrorOnGet
+}
+
+func (c *MockClient) GetSnapshots(ctx context.Context) (clients.EC2Snapshots, error) {
+	return ec2Snapshots, snapshotsErrorOnGet
+}
+
+func (c *MockClient) CreateSnapshot(ctx context.Context, volume *ec2.Volume, tags []*ec2.Tag) (*ec2.Snapshot, error) {
+	if SnapshotErrorOnCreate != nil {
+		return nil, SnapshotErrorOnCreate
+	}
+	snapshotsCreated++
+	return &ec2.Snapshot{SnapshotId: aws.String("snapshot-new"), VolumeId: volume.VolumeId, Tags: tags}, nil
+}
+
+func (c *MockClient) RemoveSnapshot(ctx context.Context, snapshot *ec2.Snapshot) error {
+	return snapshotErrorOnRemove
+}
+
+func (c *MockClient) CopySnapshot(ctx context.Context, snapshot *ec2.Snapshot, sourceRegion, kmsKeyID string) (string, error) {
+	return "", nil
+}
+
+func (c *MockClient) GetSnapshotCopies(ctx context.Context) (clients.EC2Snapshots, error) {
+	return clients.EC2Snapshots{}, nil
+}
+
+func (c *MockClient) ShareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string) error {
+	return nil
+}
+
+func (c *MockClient) UnshareSnapshot(ctx context.Context, snapshot *ec2.Snapshot, accountID string, keepKeyGrant bool) error {
+	return nil
+}
+
+func (c *MockClient) CreateVolume(ctx context.Context, snapshot *ec2.Snapshot, options *clients.VolumeOptions) (*ec2.Volume, error) {
+	return &ec2.Volume{}, nil
+}
+
+func (c *MockClient) TagSnapshot(ctx context.Context, snapshotID string, tags []*ec2.Tag) error {
+	return nil
+}
+
+func (c *MockClient) UntagSnapshot(ctx context.Context, snapshotID string, keys []string) error {
+	return nil
+}
+
+type MockCopier struct {
+	copies  clients.EC2Snapshots
+	started []string
+	removed []string
+	// removeErr is returned by every copy removal, which isn't recorded then
+	removeErr error
+}
+
+func (c *MockCopier) Refresh(ctx context.Context, region string) (clients.EC2Snapshots, error) {
+	return c.copies, nil
+}
+
+func (c *MockCopier) StartCopy(ctx context.Context, snapshot *ec2.Snapshot, region, kmsKeyID string) (bool, error) {
+	for _, copies := range c.copies {
+		for _, cp := range copies {
+			if clients.SourceSnapshotID(cp) == *snapshot.SnapshotId {
+				return false, nil
+			}
+		}
+	}
+	c.started = append(c.started, *snapshot.SnapshotId)
+	return true, nil
+}
+
+func (c *MockCopier) RemoveCopy(ctx context.Context, snapshot *ec2.Snapshot, region string) error {
+	if c.removeErr != nil {
+		return c.removeErr
+	}
+	c.removed = append(c.removed, *snapshot.SnapshotId)
+	return nil
+}
+
+type MockHookRunner struct {
+	errors map[*models.Hook]error
+	run    []*models.Hook
+	// ctxErrs holds the error of the context each hook was run with
+	ctxErrs []error
+}
+
+func (r *MockHookRunner) Run(ctx context.Context, hook *models.Hook, target hooks.Target) error {
+	r.run = append(r.run, hook)
+	r.ctxErrs = append(r.ctxErrs, ctx.Err())
+	return r.errors[hook]
+}
+
+// BlockingHookRunner used to hold the first hook run until released, recording how many hooks run at once
+type BlockingHookRunner struct {
+	started chan struct{}
+	release chan struct{}
+
+	mu         sync.Mutex
+	run        int
+	running    int
+	maxRunning int
+}
+
+func (r *BlockingHookRunner) Run(ctx context.Context, hook *models.Hook, target hooks.Target) error {
+	r.mu.Lock()
+	r.run++
+	first := r.run == 1
+	r.running++
+	if r.running > r.maxRunning {
+		r.maxRunning = r.running
+	}
+	r.mu.Unlock()
+
+	if first {
+		close(r.started)
+		<-r.release
+	}
+
+	r.mu.Lock()
+	r.running--
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *BlockingHookRunner) runs() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.run
+}
+
+type MockRegistrar struct {
+	registered   []string
+	unregistered []string
+}
+
+func (r *MockRegistrar) Register(snapshot *ec2.Snapshot, pvcName, pvcNamespace string) error {
+	r.registered = append(r.registered, pvcNamespace+"/"+pvcName+"/"+*snapshot.SnapshotId)
+	return nil
+}
+
+func (r *MockRegistrar) Unregister(snapshot *ec2.Snapshot, pvcName, pvcNamespace string) error {
+	r.unregistered = append(r.unregistered, pvcNamespace+"/"+pvcName+"/"+*snapshot.SnapshotId)
+	return nil
+}
+
+type MockNotifier struct {
+	events []*notify.Event
+}
+
+func (n *MockNotifier) Notify(ctx context.Context, event *notify.Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+type MockAuditSink struct {
+	records []*audit.Record
+}
+
+func (s *MockAuditSink) Write(ctx context.Context, record *audit.Record) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+// MockStateStore keeps the records of a single scope, encoded as a real store would
+type MockStateStore struct {
+	saved map[string]*store.VolumeRecord
+}
+
+func (s *MockStateStore) Load(ctx context.Context, scope string) (map[string]*store.VolumeRecord, error) {
+	return copyRecords(s.saved), nil
+}
+
+func (s *MockStateStore) Save(ctx context.Context, scope string, records map[string]*store.VolumeRecord) error {
+	s.saved = copyRecords(records)
+	return nil
+}
+
+func (s *MockStateStore) Close() error {
+	return nil
+}
+
+func copyRecords(records map[string]*store.VolumeRecord) map[string]*store.VolumeRecord {
+	data, _ := json.Marshal(records)
+	copied := make(map[string]*store.VolumeRecord)
+	json.Unmarshal(data, &copied)
+	return copied
 }